@@ -11,11 +11,11 @@ const {Content, Footer} = Layout;
 
 function App(props) {
     const user = useSelector(state => state.config.data.user)
+    const access = useSelector(state => state.config.data.access)
     const {
         data: {
             oAuthEnabled,
-            gitLabBaseURL,
-            gitLabAppId: gitLabBaseAppId
+            authProvider,
         },
         state: configState,
     } = useSelector(state => state.config)
@@ -25,7 +25,11 @@ function App(props) {
     }
 
     if (oAuthEnabled && user === null) {
-        return <Login gitLabBaseURL={gitLabBaseURL} gitLabBaseAppId={gitLabBaseAppId}/>
+        return <Login provider={authProvider}/>
+    }
+    // The user logged in, but isn't in allow-lists
+    if (oAuthEnabled && access && !access.allowed) {
+        return <Login provider={authProvider} reasons={access.reasons}/>
     }
     return (
         <Layout className="layout">
@@ -1,6 +1,6 @@
 import axios from 'axios';
 
-const baseUrl = '';
+const baseUrl = '/api/v1';
 
 const fetchRequest = async url => {
     const response = await axios.get(url);
@@ -170,7 +170,7 @@ export default ({lastDeployment, id, envName, updateDeploymentsHistory}) => {
 
         if (jobs && jobs.status === 'success') {
             return (
-                <a target="_brank" href={jobs.web_url} title="success">
+                <a target="_brank" href={jobs.webURL} title="success">
                     <CheckCircleTwoTone twoToneColor="#52c41a"/>
                 </a>
             )
@@ -178,7 +178,7 @@ export default ({lastDeployment, id, envName, updateDeploymentsHistory}) => {
 
         if (jobs && (jobs.status === 'canceled' || jobs.status === 'skipped' || jobs.status === 'failed')) {
             return (
-                <a target="_brank" href={jobs.web_url} title={jobs && jobs.status || ''}>
+                <a target="_brank" href={jobs.webURL} title={jobs && jobs.status || ''}>
                     <CloseCircleTwoTone twoToneColor="#eb2f96" />
                 </a>
             )
@@ -37,7 +37,7 @@ export default props => {
     if (jobs && (jobs.status === 'created' || jobs.status === 'running' || jobs.status === 'pending')) {
         return (
             <Space size="middle">
-                <a target="_brank" href={jobs.web_url}>Running...</a>
+                <a target="_brank" href={jobs.webURL}>Running...</a>
             </Space>
         )
     }
@@ -48,7 +48,7 @@ export default props => {
 
     return (
         <Space size="middle">
-            <a target="_brank" href={`${projectUrl}/pipelines/${jobs && jobs.pipeline.id}`}>{moment(jobs.finished_at).calendar()}</a>
+            <a target="_brank" href={`${projectUrl}/pipelines/${jobs && jobs.pipeline.id}`}>{moment(jobs.finishedAt).calendar()}</a>
         </Space>
     );
 }
@@ -43,7 +43,7 @@ export default (props) => {
 
     return (
         <Space size="middle">
-            <Avatar icon={<img src={jobs.user.avatar_url} alt={`${jobs.user.username} Avatar`}/>} size={50}/>
+            <Avatar icon={<img src={jobs.user.avatarURL} alt={`${jobs.user.username} Avatar`}/>} size={50}/>
             <a href={makeLink(linkTemplate, jobs.user.username, gitLabBaseUrl)}
                target="_blank"
                rel="noopener noreferrer"
@@ -13,8 +13,7 @@ import './Header.css';
 
 function Header(props) {
     const oAuthEnabled = useSelector(state => state.config.data.oAuthEnabled)
-    const gitLabBaseURL = useSelector(state => state.config.data.gitLabBaseURL)
-    const gitLabBaseAppId = useSelector(state => state.config.data.gitLabAppId)
+    const authProvider = useSelector(state => state.config.data.authProvider)
     const user = useSelector(state => state.config.data.user)
     const environments = useSelector(state => state.environments);
     const dispatch = useDispatch();
@@ -31,12 +30,13 @@ function Header(props) {
 
     let loginMenu;
 
-    if (oAuthEnabled) {
+    // Users of trusted headers log in and out on the proxy
+    if (oAuthEnabled && authProvider !== 'headers') {
         if (user === null) {
             loginMenu =
                 <div style={{float: 'right'}}>
                     <Menu.Item key={"login"}>
-                        <a href={`${gitLabBaseURL}/oauth/authorize?client_id=${gitLabBaseAppId}&redirect_uri=${window.location.origin}/oauth/code&response_type=code&scope=read_user`}>Login</a>
+                        <a href="/oauth/login">Login</a>
                     </Menu.Item>
                 </div>
         } else {
@@ -1,26 +1,44 @@
 import React from 'react';
-import {Button, Layout} from "antd";import {
+import {Alert, Button, Layout} from "antd";import {
     GitlabOutlined,
+    LoginOutlined,
 } from '@ant-design/icons';
 
 import './Login.css';
 
 const {Content} = Layout;
 
-export default ({gitLabBaseURL, gitLabBaseAppId}) => (
+const providerTitles = {
+    gitlab: 'GitLab',
+    oidc: 'SSO',
+};
+
+export default ({provider, reasons}) => (
     <Layout className="layout login-page">
         <Content className="login-page__content">
             <div className="login-page__logo" />
-            <Button
+            {reasons && <Alert
+                className="login-page__access-denied"
+                type="error"
+                message="You are not allowed to use the dashboard"
+                description={reasons.join('; ')}
+            />}
+            {/* Users of trusted headers are logged in by the proxy, the dashboard has no login */}
+            {provider === 'headers' ? !reasons && <Alert
+                className="login-page__access-denied"
+                type="warning"
+                message="The proxy didn't authenticate the request"
+                description="Open the dashboard through the SSO gateway"
+            /> : <Button
                 danger
                 className="login-page__login-btn"
                 size="large"
                 type="primary"
-                icon={<GitlabOutlined />}
-                href={`${gitLabBaseURL}/oauth/authorize?client_id=${gitLabBaseAppId}&redirect_uri=${window.location.origin}/oauth/code&response_type=code&scope=read_user`}
+                icon={provider === 'oidc' ? <LoginOutlined /> : <GitlabOutlined />}
+                href="/oauth/login"
             >
-                Sign in with GitLab
-            </Button>
+                Sign in with {providerTitles[provider] || providerTitles.gitlab}
+            </Button>}
         </Content>
     </Layout>
 );
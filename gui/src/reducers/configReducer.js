@@ -14,7 +14,9 @@ const initialState = {
         userLinkTemplate: '',
         gitLabAppId: '',
         oAuthEnabled: true,
+        authProvider: 'gitlab',
         user: null,
+        access: null,
     },
     state: LOAD_INIT,
 }
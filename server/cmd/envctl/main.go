@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gitlab-environment-dashboard/server/pkg/client"
+	"gitlab-environment-dashboard/server/pkg/dto"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	exitOK = 0
+	// exitFailed means the API failed or a deploy wasn't successful
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+const usage = `Usage: envctl <command> [flags]
+
+Commands:
+  deploy <environment> <project> <ref>        deploy the ref of the project (ID, name or path with namespace)
+  status <environment>                        show deployed refs and last jobs
+  deploy-all <environment> --query <query>    deploy matched branches of all projects
+      [--match prefix|glob|regex] [--fallback-ref <ref>]
+
+The dashboard is set by ENVCTL_URL and the API token by ENVCTL_TOKEN, or by --url and --token.
+envctl exits with 1 when the API fails or a deploy isn't successful.
+
+Flags:
+`
+
+type options struct {
+	url      string
+	token    string
+	output   string
+	wait     bool
+	interval time.Duration
+	timeout  time.Duration
+
+	query       string
+	match       string
+	fallbackRef string
+}
+
+// deployRow is a deploy of a project, it's printed by deploy and deploy-all
+type deployRow struct {
+	ProjectID int      `json:"projectId"`
+	Project   string   `json:"project"`
+	Ref       string   `json:"ref,omitempty"`
+	Outcome   string   `json:"outcome,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Job       *dto.Job `json:"job"`
+}
+
+type statusOutput struct {
+	Environment *dto.Environment `json:"environment"`
+	// Jobs are the last jobs started by the dashboard by project ID
+	Jobs map[int]*dto.Job `json:"jobs"`
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprint(stderr, usage)
+		newFlagSet("", &options{}, stderr).PrintDefaults()
+		return exitUsage
+	}
+
+	command := args[0]
+	opts := &options{}
+	flags := newFlagSet(command, opts, stderr)
+	if command == "deploy-all" {
+		flags.StringVar(&opts.query, "query", "", "branches to deploy, at least 3 symbols")
+		flags.StringVar(&opts.match, "match", "", "match mode of the query: prefix (default), glob or regex")
+		flags.StringVar(&opts.fallbackRef, "fallback-ref", "", "ref of projects without a matched branch")
+	}
+	positional, err := parseFlags(flags, args[1:])
+	if err != nil {
+		return exitUsage
+	}
+	if opts.url == "" || opts.token == "" {
+		fmt.Fprintln(stderr, "the dashboard URL and the API token are required, set ENVCTL_URL and ENVCTL_TOKEN")
+		return exitUsage
+	}
+	if opts.output != outputTable && opts.output != outputJSON {
+		fmt.Fprintf(stderr, "unknown output %s, use table or json\n", opts.output)
+		return exitUsage
+	}
+	if opts.interval <= 0 || opts.timeout <= 0 {
+		fmt.Fprintln(stderr, "--interval and --timeout should be positive")
+		return exitUsage
+	}
+
+	api := client.NewClient(opts.url, opts.token, &http.Client{Timeout: 30 * time.Second})
+	ctx := context.Background()
+	switch {
+	case command == "deploy" && len(positional) == 3:
+		return deploy(ctx, api, opts, positional[0], positional[1], positional[2], stdout, stderr)
+	case command == "status" && len(positional) == 1:
+		return status(ctx, api, opts, positional[0], stdout, stderr)
+	case command == "deploy-all" && len(positional) == 1 && opts.query != "":
+		return deployAll(ctx, api, opts, positional[0], stdout, stderr)
+	}
+
+	fmt.Fprint(stderr, usage)
+	flags.PrintDefaults()
+	return exitUsage
+}
+
+func newFlagSet(command string, opts *options, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet("envctl "+command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&opts.url, "url", os.Getenv("ENVCTL_URL"), "URL of the dashboard")
+	flags.StringVar(&opts.token, "token", os.Getenv("ENVCTL_TOKEN"), "API token of the dashboard")
+	flags.StringVar(&opts.output, "output", outputTable, "output format: table or json")
+	flags.BoolVar(&opts.wait, "wait", false, "wait until started jobs are finished")
+	flags.DurationVar(&opts.interval, "interval", 5*time.Second, "how often jobs are checked while waiting")
+	flags.DurationVar(&opts.timeout, "timeout", 30*time.Minute, "how long to wait for jobs")
+
+	return flags
+}
+
+// parseFlags parses flags which could go after arguments, i.e. `deploy-all qa2 --query release-42`
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func deploy(ctx context.Context, api *client.Client, opts *options, environment string, projectName string, ref string, stdout io.Writer, stderr io.Writer) int {
+	env, err := api.Environment(ctx, environment)
+	if err != nil {
+		return failed(stderr, err)
+	}
+	project := findProject(env, projectName)
+	if project == nil {
+		return failed(stderr, fmt.Errorf("project %s not found in %s", projectName, environment))
+	}
+
+	job, err := api.PlayJob(ctx, environment, project.ID, ref)
+	if err != nil {
+		return failed(stderr, err)
+	}
+	rows := []*deployRow{{ProjectID: project.ID, Project: project.Name, Ref: ref, Job: job}}
+	if opts.wait {
+		err = waitForJobs(ctx, api, opts, environment, rows, stderr)
+	}
+
+	printDeployRows(stdout, opts.output, rows)
+	if err != nil {
+		return failed(stderr, err)
+	}
+	return exitCode(rows, opts.wait)
+}
+
+func deployAll(ctx context.Context, api *client.Client, opts *options, environment string, stdout io.Writer, stderr io.Writer) int {
+	env, err := api.Environment(ctx, environment)
+	if err != nil {
+		return failed(stderr, err)
+	}
+
+	// 207 and 422 of nothing deployed have results of projects
+	result, err := api.PlayJobsWithQuery(ctx, environment, client.PlayJobsRequest{
+		Query:       opts.query,
+		Match:       opts.match,
+		FallbackRef: opts.fallbackRef,
+	})
+	if err != nil && len(result.Projects) == 0 {
+		return failed(stderr, err)
+	}
+	rows := make([]*deployRow, 0, len(result.Projects))
+	for _, project := range result.Projects {
+		row := &deployRow{ProjectID: project.ProjectID, Project: strconv.Itoa(project.ProjectID), Ref: project.Branch, Outcome: project.Outcome, Error: project.Error, Job: project.Job}
+		if found := findProject(env, row.Project); found != nil {
+			row.Project = found.Name
+		}
+		rows = append(rows, row)
+	}
+	if err == nil && opts.wait {
+		err = waitForJobs(ctx, api, opts, environment, rows, stderr)
+	}
+
+	printDeployRows(stdout, opts.output, rows)
+	if err != nil {
+		return failed(stderr, err)
+	}
+	if result.Error != "" {
+		fmt.Fprintln(stderr, result.Error)
+	}
+	return exitCode(rows, opts.wait)
+}
+
+func status(ctx context.Context, api *client.Client, opts *options, environment string, stdout io.Writer, stderr io.Writer) int {
+	env, err := api.Environment(ctx, environment)
+	if err != nil {
+		return failed(stderr, err)
+	}
+	jobs, err := api.Jobs(ctx)
+	if err != nil {
+		return failed(stderr, err)
+	}
+	output := &statusOutput{Environment: env, Jobs: jobs[environment]}
+	if output.Jobs == nil {
+		output.Jobs = map[int]*dto.Job{}
+	}
+
+	if opts.output == outputJSON {
+		printJSON(stdout, output)
+		return exitOK
+	}
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "PROJECT\tREF\tCOMMIT\tDEPLOYED\tBEHIND\tJOB")
+	for _, project := range env.Projects {
+		if project == nil {
+			continue
+		}
+		ref, commit, deployedAt, behind, job := "-", "-", "-", "-", "-"
+		if deployment := project.LastDeployment; deployment != nil {
+			ref = deployment.Ref
+			commit = shortSHA(deployment.SHA)
+			if deployment.UpdatedAt != nil {
+				deployedAt = deployment.UpdatedAt.Local().Format("2006-01-02 15:04")
+			}
+		}
+		if project.BehindBy != nil {
+			behind = strconv.Itoa(*project.BehindBy)
+		}
+		if lastJob := output.Jobs[project.ID]; lastJob != nil {
+			job = fmt.Sprintf("%s %s", lastJob.Ref, lastJob.Status)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", project.Name, ref, commit, deployedAt, behind, job)
+	}
+	table.Flush()
+
+	return exitOK
+}
+
+// waitForJobs replaces jobs of rows by finished ones
+// Jobs are waited one by one, finished jobs are checked only once, so it takes as long as the slowest job
+func waitForJobs(ctx context.Context, api *client.Client, opts *options, environment string, rows []*deployRow, stderr io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+	for _, row := range rows {
+		if row.Job == nil {
+			continue
+		}
+		fmt.Fprintf(stderr, "waiting for %s job %s\n", row.Project, row.Job.WebURL)
+		job, err := api.WaitForJob(ctx, environment, row.ProjectID, row.Job.ID, opts.interval)
+		if job != nil {
+			row.Job = job
+		}
+		if err != nil {
+			return fmt.Errorf("cannot wait for %s job: %w", row.Project, err)
+		}
+	}
+
+	return nil
+}
+
+// exitCode fails when a project failed, nothing was started or waited jobs weren't successful
+func exitCode(rows []*deployRow, waited bool) int {
+	started := 0
+	for _, row := range rows {
+		if row.Outcome == gitlab.QueryDeployError {
+			return exitFailed
+		}
+		if row.Job == nil {
+			continue
+		}
+		started++
+		if waited && row.Job.Status != gitlab.JobStatusSuccess {
+			return exitFailed
+		}
+	}
+	if started == 0 {
+		return exitFailed
+	}
+
+	return exitOK
+}
+
+func printDeployRows(stdout io.Writer, output string, rows []*deployRow) {
+	if output == outputJSON {
+		printJSON(stdout, rows)
+		return
+	}
+	table := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "PROJECT\tREF\tOUTCOME\tSTATUS\tJOB")
+	for _, row := range rows {
+		outcome, status, url := row.Outcome, "-", "-"
+		if outcome == "" {
+			outcome = "played"
+		}
+		if row.Error != "" {
+			outcome += ": " + row.Error
+		}
+		if row.Job != nil {
+			status = row.Job.Status
+			url = row.Job.WebURL
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", row.Project, valueOrDash(row.Ref), outcome, status, url)
+	}
+	table.Flush()
+}
+
+func printJSON(stdout io.Writer, value interface{}) {
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(value)
+}
+
+func failed(stderr io.Writer, err error) int {
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) && apiErr.Code == "unauthorized" {
+		fmt.Fprintln(stderr, "the API token is wrong or revoked")
+	}
+	fmt.Fprintf(stderr, "envctl: %v\n", err)
+	return exitFailed
+}
+
+// findProject finds a project of the environment by ID, name or path with namespace
+func findProject(environment *dto.Environment, nameOrID string) *dto.Project {
+	for _, project := range environment.Projects {
+		if project == nil {
+			continue
+		}
+		if strconv.Itoa(project.ID) == nameOrID ||
+			strings.EqualFold(project.Name, nameOrID) ||
+			strings.EqualFold(project.NameWithNamespace, nameOrID) {
+			return project
+		}
+	}
+
+	return nil
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return valueOrDash(sha)
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
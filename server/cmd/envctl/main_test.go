@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	// The found job 7 is retried, so the dashboard started and tracks job 8
+	// Environments are scenarios: qa2 succeeds, qa3 is partially deployed, qa4 deploys nothing and the job of qa5 fails
+	responses := map[string]struct {
+		code int
+		body string
+	}{
+		"GET /api/v1/environments": {http.StatusOK, `{"environments":[` +
+			`{"name":"qa2","projects":[{"id":42,"name":"api"}]},{"name":"qa3","projects":[{"id":42,"name":"api"},{"id":43,"name":"web"}]},` +
+			`{"name":"qa4","projects":[{"id":42,"name":"api"}]},{"name":"qa5","projects":[{"id":42,"name":"api"}]}]}`},
+		"GET /api/v1/jobs": {http.StatusOK, `{"jobs":{"qa2":{"42":{"id":8,"ref":"master","status":"success"}}}}`},
+		"POST /api/v1/environments/qa2/projects/42/jobs": {http.StatusOK, `{"job":{"id":8,"status":"pending"},"start":{"jobId":8}}`},
+		"GET /api/v1/environments/qa2/projects/42/jobs":  {http.StatusOK, `{"job":{"id":8,"status":"success"},"start":{"jobId":8}}`},
+		"POST /api/v1/environments/qa3/jobs": {http.StatusMultiStatus, `{"error":"some jobs cannot be started","code":"partially_deployed","projects":[` +
+			`{"projectId":42,"branch":"release-42","outcome":"played","job":{"id":9,"status":"pending"}},` +
+			`{"projectId":43,"branch":"release-42","outcome":"error","error":"GitLab is unavailable"}]}`},
+		"POST /api/v1/environments/qa4/jobs": {http.StatusUnprocessableEntity, `{"error":"nothing was run","code":"nothing_deployed","projects":[` +
+			`{"projectId":42,"outcome":"skipped-no-branch"}]}`},
+		"POST /api/v1/environments/qa5/projects/42/jobs": {http.StatusOK, `{"job":{"id":10,"status":"pending"},"start":{"jobId":10}}`},
+		"GET /api/v1/environments/qa5/projects/42/jobs":  {http.StatusOK, `{"job":{"id":10,"status":"failed"},"start":{"jobId":10}}`},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[r.Method+" "+r.URL.Path]
+		if !ok {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(response.code)
+		_, _ = w.Write([]byte(response.body))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"retriedAndWaited", []string{"deploy", "qa2", "api", "master", "--wait", "--interval", "1ms"}, exitOK, "success", "waiting for api job"},
+		{"partiallyDeployed", []string{"deploy-all", "qa3", "--query", "release-42"}, exitFailed, "GitLab is unavailable", "some jobs cannot be started"},
+		{"nothingDeployed", []string{"deploy-all", "qa4", "--query", "release-42"}, exitFailed, "skipped-no-branch", "nothing was run"},
+		{"status", []string{"status", "qa2"}, exitOK, "master success", ""},
+		{"failedJob", []string{"deploy", "qa5", "api", "master", "--wait", "--interval", "1ms"}, exitFailed, "failed", "waiting for api job"},
+		{"zeroInterval", []string{"deploy", "qa2", "api", "master", "--wait", "--interval", "0"}, exitUsage, "", "should be positive"},
+		{"negativeTimeout", []string{"deploy", "qa2", "api", "master", "--wait", "--timeout", "-1s"}, exitUsage, "", "should be positive"},
+		{"unknownCommand", []string{"undeploy", "qa2"}, exitUsage, "", "Usage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			args := append(tt.args, "--url", server.URL, "--token", "gld_secret")
+			if code := run(args, stdout, stderr); code != tt.wantCode {
+				t.Fatalf("run() = %d, want %d, stderr: %s", code, tt.wantCode, stderr)
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("run() stdout = %q, want %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("run() stderr = %q, want %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+}
@@ -3,9 +3,19 @@ package main
 import (
 	"context"
 	"fmt"
+	"gitlab-environment-dashboard/server/pkg/apitoken"
+	"gitlab-environment-dashboard/server/pkg/auth"
+	"gitlab-environment-dashboard/server/pkg/breaker"
+	"gitlab-environment-dashboard/server/pkg/chatops"
 	"gitlab-environment-dashboard/server/pkg/config"
 	"gitlab-environment-dashboard/server/pkg/gitlab"
 	"gitlab-environment-dashboard/server/pkg/handler"
+	"gitlab-environment-dashboard/server/pkg/metrics"
+	"gitlab-environment-dashboard/server/pkg/notification"
+	"gitlab-environment-dashboard/server/pkg/oauth"
+	"gitlab-environment-dashboard/server/pkg/openapi"
+	"gitlab-environment-dashboard/server/pkg/session"
+	"gitlab-environment-dashboard/server/pkg/tracing"
 	"github.com/gorilla/handlers"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
@@ -20,20 +30,67 @@ import (
 
 func main() {
 	cfg := config.CreateConfig()
+	log.AddHook(tracing.LogHook{})
+	shutdownTracing, err := tracing.Setup(cfg.TracingExporter, cfg.TracingServiceName)
+	catchFatalError(err, "cannot setup tracing: %v", err)
+
+	gitLabBreaker := breaker.New(cfg.GitLabBreakerThreshold, cfg.GitLabBreakerCooldown)
+	metrics.ObserveBreaker(gitLabBreaker)
+	gitLabHTTPClient := &http.Client{
+		Timeout: cfg.GitLabRequestTimeout,
+		// Requests rejected by the breaker are traced but aren't measured as GitLab requests
+		Transport: tracing.NewTransport(gitLabBreaker.Transport(metrics.InstrumentTransport(http.DefaultTransport))),
+	}
 	gitLabService, err := gitlab.NewClient(
 		cfg.GitLabToken,
 		cfg.GitLabBaseURL,
 		cfg.ProtectedEnvironments,
 		cfg.GitLabProjectIDs,
+		gitLabHTTPClient,
 	)
 	catchFatalError(err, "cannot create gitlab client: %v", err)
-	userService := gitlab.NewUserService(
-		gitLabService,
-		cfg.GitLabBaseURL,
+	if cfg.SnapshotsFile != "" {
+		err = gitLabService.LoadSnapshots(cfg.SnapshotsFile)
+		catchFatalError(err, "cannot read snapshots: %v", err)
+	}
+	accessPolicy := gitlab.NewAccessPolicy(gitLabService, cfg.AllowedUsers, cfg.AllowedGroups)
+	oauthClient, validateToken, err := createOAuthClient(cfg, gitLabHTTPClient, accessPolicy)
+	catchFatalError(err, "cannot create oauth client: %v", err)
+	sessions, err := session.NewManager(
+		cfg.SessionSecret,
+		cfg.SessionTTL,
+		cfg.SessionIdleTimeout,
+		cfg.SessionRevalidateInterval,
+		validateToken,
+		oauthClient.Refresh,
 	)
+	catchFatalError(err, "cannot create session manager: %v", err)
+	authProvider, err := createAuthProvider(cfg, sessions, accessPolicy)
+	catchFatalError(err, "cannot create auth provider: %v", err)
+	tokens, err := apitoken.NewStore(cfg.APITokensFile)
+	catchFatalError(err, "cannot read api tokens: %v", err)
+	// Users without sessions (API tokens and chat users) are checked by the policy too
+	userAccess := auth.NewAccessCache(accessPolicy.Check, cfg.SessionRevalidateInterval)
+	userService := gitlab.NewUserService(authProvider, tokens, userAccess)
+
+	gitLabService.AddJobListener(metrics.ObserveJobEvent)
+	if cfg.NotificationsConfigFile != "" {
+		notificationsConfig, err := notification.LoadConfig(cfg.NotificationsConfigFile)
+		catchFatalError(err, "cannot read notifications config: %v", err)
+		notifier, err := notification.NewNotifier(notificationsConfig, gitLabService.RunInBackground)
+		catchFatalError(err, "cannot create notifier: %v", err)
+		gitLabService.AddJobListener(notifier.Notify)
+	}
+
+	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C) or SIGTERM
+	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
+	// Background goroutines are stopped with the context
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	scheduleUpdateEnvironments(gitLabService, cfg.UpdateDuration, cfg.GitLabProjectIDs)
-	scheduleUpdateBranches(gitLabService, cfg.UpdateDuration, cfg.GitLabProjectIDs)
+	scheduleUpdateEnvironments(ctx, gitLabService, cfg.UpdateDuration, cfg.GitLabProjectIDs)
+	scheduleUpdateBranches(ctx, gitLabService, cfg.UpdateDuration, cfg.GitLabProjectIDs)
+	sessions.Run(ctx, time.Minute)
 
 	//err = gitLabService.UpdateEnvironments(cfg.GitLabProjectIDs)
 	//catchFatalError(err, "cannot update environments: %v", err)
@@ -41,8 +98,11 @@ func main() {
 	//catchFatalError(err, "cannot update branches: %v", err)
 
 	r := mux.NewRouter()
+	r.Use(tracing.Middleware)
 
-	addRoutes(r, gitLabService, cfg, userService)
+	spec, err := openapi.Load()
+	catchFatalError(err, "cannot load openapi specification: %v", err)
+	addRoutes(r, gitLabService, cfg, userService, userAccess, oauthClient, sessions, tokens, gitLabBreaker, spec)
 	srv := &http.Server{
 		Addr: cfg.ListenAddr,
 		// Good practice to set timeouts to avoid Slowloris attacks.
@@ -50,43 +110,221 @@ func main() {
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
 		Handler:      r, // Pass our instance of gorilla/mux in.
+		// The log follow mode extends the write deadline of its connection
+		ConnContext: handler.SaveConnInContext,
 	}
 
 	log.Printf(fmt.Sprintf("listen on: %s", cfg.ListenAddr))
 
 	go func() {
-		err = srv.ListenAndServe()
-		catchFatalError(err, "cannot start the server: %v", err)
+		// Shutdown makes it return ErrServerClosed, the process exits after the graceful shutdown then
+		err := srv.ListenAndServe()
+		if err != http.ErrServerClosed {
+			catchFatalError(err, "cannot start the server: %v", err)
+		}
 	}()
 
-	c := make(chan os.Signal, 1)
-	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C) or SIGTERM
-	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-
 	// Block until we receive our signal.
-	<-c
+	<-ctx.Done()
+	stop()
 
 	// Create a deadline to wait for.
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
-	err = srv.Shutdown(ctx)
-	catchFatalError(err, "cannot shutdown server: %v", err)
+	// Watchers are stopped and traces are flushed even if some requests didn't finish in time
+	err = srv.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Errorf("cannot shutdown server: %v", err)
+	}
+	gitLabService.Stop()
+	err = shutdownTracing(shutdownCtx)
+	if err != nil {
+		log.Errorf("cannot flush traces: %v", err)
+	}
 	log.Info("graceful shutting down")
 	os.Exit(0)
 }
 
+// createOAuthClient creates a client of the login provider and a validator of its tokens
+// OpenID Connect endpoints are discovered by the issuer
+func createOAuthClient(cfg config.Config, gitLabHTTPClient *http.Client, accessPolicy *gitlab.AccessPolicy) (*oauth.Client, session.ValidateFunc, error) {
+	if cfg.AuthProvider != auth.ProviderOIDC {
+		oauthClient := oauth.NewClient(cfg.GitLabBaseURL, cfg.GitLabAppID, cfg.GitLabAppSecret, gitLabHTTPClient)
+		return oauthClient, gitlab.NewTokenValidator(cfg.GitLabBaseURL, gitLabHTTPClient, accessPolicy), nil
+	}
+
+	// The provider isn't GitLab, so its failures shouldn't open the GitLab circuit breaker
+	httpClient := &http.Client{
+		Timeout:   cfg.GitLabRequestTimeout,
+		Transport: tracing.NewTransport(http.DefaultTransport),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.GitLabRequestTimeout)
+	defer cancel()
+	endpoints, err := oauth.Discover(ctx, cfg.OIDCIssuer, httpClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	oauthClient := oauth.NewClientWithEndpoints(*endpoints, cfg.OIDCScopes, cfg.OIDCClientID, cfg.OIDCClientSecret, httpClient)
+
+	return oauthClient, auth.NewOIDCValidator(endpoints.UserInfo, httpClient, cfg.OIDCUsernameClaim, accessPolicy.Check), nil
+}
+
+// createAuthProvider creates the provider of AUTH_PROVIDER
+func createAuthProvider(cfg config.Config, sessions *session.Manager, accessPolicy *gitlab.AccessPolicy) (auth.Provider, error) {
+	switch cfg.AuthProvider {
+	case auth.ProviderGitLab, auth.ProviderOIDC:
+		return auth.NewSessionProvider(cfg.AuthProvider, sessions), nil
+	case auth.ProviderHeaders:
+		return auth.NewHeadersProvider(auth.HeadersConfig{
+			UserHeader:      cfg.AuthHeaderUser,
+			NameHeader:      cfg.AuthHeaderName,
+			AvatarHeader:    cfg.AuthHeaderAvatar,
+			SecretHeader:    cfg.AuthProxySecretHeader,
+			Secret:          cfg.AuthProxySecret,
+			TrustedNetworks: cfg.AuthProxyTrustedNetworks,
+		}, accessPolicy.Check, cfg.SessionRevalidateInterval)
+	}
+
+	return nil, auth.UnknownProvider
+}
+
 func catchFatalError(err error, format string, args ...interface{}) {
 	if err != nil {
 		log.Fatalf(format, args...)
 	}
 }
 
-func addRoutes(r *mux.Router, gitLabService *gitlab.Service, cfg config.Config, userService *gitlab.UserService) {
-	wrapWithMiddleware := CreateMiddlewareWrapper(userService)
+func addRoutes(
+	r *mux.Router,
+	gitLabService *gitlab.Service,
+	cfg config.Config,
+	userService *gitlab.UserService,
+	userAccess *auth.AccessCache,
+	oauthClient *oauth.Client,
+	sessions *session.Manager,
+	tokens *apitoken.Store,
+	gitLabBreaker *breaker.Breaker,
+	spec *openapi.Document,
+) {
+	wrapWithMiddleware := CreateMiddlewareWrapper(userService, cfg.RequestTimeout)
+
+	// API v1 is described by the specification, its requests are validated against it
+	api := r.PathPrefix(openapi.Prefix).Subrouter()
+	api.Methods("GET").
+		Path("/openapi.json").
+		Handler(wrapWithMiddleware(
+			handler.CreateOpenAPIHandler(spec),
+			false,
+		))
+
+	wrapAPIWithMiddleware := func(handlerFunc http.HandlerFunc, restrictedArea bool) http.Handler {
+		return wrapWithMiddleware(
+			handler.CreateValidationMiddleware(spec, cfg.OpenAPIValidateResponses, handlerFunc),
+			restrictedArea,
+		)
+	}
+	// Legacy routes without the prefix are aliases of v1 for clients which were written before it
+	for _, router := range []*mux.Router{api, r} {
+		addAPIRoutes(router, wrapAPIWithMiddleware, gitLabService, cfg, userService, sessions, tokens)
+	}
+
+	r.Methods("GET").
+		Path("/oauth/logout").
+		Handler(wrapWithMiddleware(
+			handler.CreateLogoutHandler(sessions, cfg.CookieSecured),
+			cfg.OAuthEnabled,
+		))
 
+	// Warning!!!
+	// Public area bellow
+	// It's not restricted area
+	// We don't check token here
+
+	// Users of trusted headers are logged in by the proxy
+	if cfg.AuthProvider != auth.ProviderHeaders {
+		r.Methods("GET").
+			Path("/oauth/login").
+			Handler(wrapWithMiddleware(
+				handler.CreateLoginHandler(oauthClient, cfg.SslEnabled, cfg.CookieSecured),
+				false,
+			))
+
+		r.Methods("GET").
+			Path("/oauth/code").
+			Handler(wrapWithMiddleware(
+				handler.CreateOauthHandler(oauthClient, sessions, cfg.SslEnabled, cfg.CookieSecured),
+				false,
+			))
+	}
+
+	// Slash commands are verified by the signing secret or the token
+	// Chat usernames could be taken by anyone, so commands are run only by users mapped to GitLab users
+	chatOpsEnabled := cfg.ChatOpsSigningSecret != "" || cfg.ChatOpsToken != ""
+	if chatOpsEnabled && len(cfg.ChatOpsUsers) == 0 {
+		log.Error("slash commands are disabled because CHATOPS_USERS is empty")
+	} else if chatOpsEnabled {
+		r.Methods("POST").
+			Path("/chatops/command").
+			Handler(wrapWithMiddleware(
+				handler.CreateChatOpsHandler(
+					chatops.NewExecutor(gitLabService, cfg.ChatOpsUsers, userAccess),
+					cfg.ChatOpsSigningSecret,
+					cfg.ChatOpsToken,
+				),
+				false,
+			))
+	}
+
+	r.Methods("GET").
+		Path("/health").
+		Handler(wrapWithMiddleware(
+			handler.CreateHealthHandler(gitLabBreaker),
+			false,
+		))
+
+	r.Methods("GET").
+		Path("/health/live").
+		Handler(wrapWithMiddleware(
+			handler.CreateLivenessHandler(),
+			false,
+		))
+
+	r.Methods("GET").
+		Path("/health/ready").
+		Handler(wrapWithMiddleware(
+			handler.CreateReadinessHandler(gitLabService, gitLabBreaker, cfg.ReadinessMaxRefreshAge),
+			false,
+		))
+
+	r.Methods("GET", "POST").
+		Path("/error").
+		Handler(wrapWithMiddleware(
+			handler.CreateErrorHandler(),
+			false,
+		))
+
+	r.Path("/metrics").
+		Handler(promhttp.Handler())
+
+	r.PathPrefix("/").
+		Handler(wrapWithMiddleware(
+			handler.CreateSPAHandler(cfg.PublicDir, "index.html"),
+			false,
+		))
+}
+
+// addAPIRoutes registers routes of the API v1 on the router
+func addAPIRoutes(
+	r *mux.Router,
+	wrapWithMiddleware MiddlewareWrapper,
+	gitLabService *gitlab.Service,
+	cfg config.Config,
+	userService *gitlab.UserService,
+	sessions *session.Manager,
+	tokens *apitoken.Store,
+) {
 	// Warning!!!
 	// Private area
 	// Check token first
@@ -97,6 +335,13 @@ func addRoutes(r *mux.Router, gitLabService *gitlab.Service, cfg config.Config,
 			cfg.OAuthEnabled,
 		))
 
+	r.Methods("GET").
+		Path("/environments/compare").
+		Handler(wrapWithMiddleware(
+			handler.CreateCompareEnvironmentsHandler(gitLabService),
+			cfg.OAuthEnabled,
+		))
+
 	r.Methods("GET").
 		Path("/environments/{environment}/projects/{projectID:[0-9]+}/repository/branches").
 		Handler(wrapWithMiddleware(
@@ -107,14 +352,28 @@ func addRoutes(r *mux.Router, gitLabService *gitlab.Service, cfg config.Config,
 	r.Methods("POST").
 		Path("/environments/{environment}/jobs").
 		Handler(wrapWithMiddleware(
-			handler.CreatePlayJobsByQueryHandler(gitLabService),
+			handler.CreatePlayJobsByQueryHandler(gitLabService, userService),
+			cfg.OAuthEnabled,
+		))
+
+	r.Methods("DELETE").
+		Path("/environments/{environment}/jobs").
+		Handler(wrapWithMiddleware(
+			handler.CreateCancelJobsHandler(gitLabService, userService),
 			cfg.OAuthEnabled,
 		))
 
 	r.Methods("POST").
 		Path("/environments/{environment}/projects/{projectID:[0-9]+}/jobs").
 		Handler(wrapWithMiddleware(
-			handler.CreatePlayJobHandler(gitLabService),
+			handler.CreatePlayJobHandler(gitLabService, userService),
+			cfg.OAuthEnabled,
+		))
+
+	r.Methods("DELETE").
+		Path("/environments/{environment}/projects/{projectID:[0-9]+}/jobs").
+		Handler(wrapWithMiddleware(
+			handler.CreateCancelJobHandler(gitLabService, userService),
 			cfg.OAuthEnabled,
 		))
 
@@ -125,6 +384,13 @@ func addRoutes(r *mux.Router, gitLabService *gitlab.Service, cfg config.Config,
 			cfg.OAuthEnabled,
 		))
 
+	r.Methods("GET").
+		Path("/environments/{environment}/projects/{projectID:[0-9]+}/jobs/log").
+		Handler(wrapWithMiddleware(
+			handler.CreateJobLogHandler(gitLabService),
+			cfg.OAuthEnabled,
+		))
+
 	r.Methods("GET").
 		Path("/environments/{environment}/projects/{projectID:[0-9]+}/deployments").
 		Handler(wrapWithMiddleware(
@@ -133,57 +399,142 @@ func addRoutes(r *mux.Router, gitLabService *gitlab.Service, cfg config.Config,
 		))
 
 	r.Methods("GET").
-		Path("/jobs").
+		Path("/environments/{environment}/projects/{projectID:[0-9]+}/commits/missing").
 		Handler(wrapWithMiddleware(
-			handler.CreateListJobsHandler(gitLabService),
+			handler.CreateMissingCommitsHandler(gitLabService),
 			cfg.OAuthEnabled,
 		))
 
 	r.Methods("GET").
-		Path("/oauth/logout").
+		Path("/environments/{environment}/snapshots").
 		Handler(wrapWithMiddleware(
-			handler.CreateLogoutHandler(cfg.CookieSecured),
+			handler.CreateListSnapshotsHandler(gitLabService),
+			cfg.OAuthEnabled,
+		))
+
+	r.Methods("POST").
+		Path("/environments/{environment}/snapshots").
+		Handler(wrapWithMiddleware(
+			handler.CreateSnapshotHandler(gitLabService),
 			cfg.OAuthEnabled,
 		))
 
-	// Warning!!!
-	// Public area bellow
-	// It's not restricted area
-	// We don't check token here
 	r.Methods("GET").
-		Path("/config").
+		Path("/environments/{environment}/snapshots/{name}").
 		Handler(wrapWithMiddleware(
-			handler.CreateConfigHandler(userService, cfg),
-			false,
+			handler.CreateGetSnapshotHandler(gitLabService),
+			cfg.OAuthEnabled,
+		))
+
+	r.Methods("DELETE").
+		Path("/environments/{environment}/snapshots/{name}").
+		Handler(wrapWithMiddleware(
+			handler.CreateDeleteSnapshotHandler(gitLabService),
+			cfg.OAuthEnabled,
 		))
 
 	r.Methods("GET").
-		Path("/oauth/code").
+		Path("/environments/{environment}/snapshots/{name}/diff").
 		Handler(wrapWithMiddleware(
-			handler.CreateOauthHandler(cfg.GitLabBaseURL, cfg.GitLabAppID, cfg.GitLabAppSecret, cfg.SslEnabled, cfg.CookieSecured),
-			false,
+			handler.CreateDiffSnapshotHandler(gitLabService),
+			cfg.OAuthEnabled,
+		))
+
+	r.Methods("POST").
+		Path("/environments/{environment}/snapshots/{name}/restore").
+		Handler(wrapWithMiddleware(
+			handler.CreateRestoreSnapshotHandler(gitLabService, userService),
+			cfg.OAuthEnabled,
 		))
 
 	r.Methods("GET").
-		Path("/health").
+		Path("/environments/{environment}/restore").
+		Handler(wrapWithMiddleware(
+			handler.CreateGetSnapshotRestoreHandler(gitLabService),
+			cfg.OAuthEnabled,
+		))
+
+	r.Methods("DELETE").
+		Path("/environments/{environment}/restore").
+		Handler(wrapWithMiddleware(
+			handler.CreateCancelSnapshotRestoreHandler(gitLabService),
+			cfg.OAuthEnabled,
+		))
+
+	r.Methods("GET").
+		Path("/environments/{environment}/lock").
+		Handler(wrapWithMiddleware(
+			handler.CreateGetLockHandler(gitLabService),
+			cfg.OAuthEnabled,
+		))
+
+	r.Methods("POST").
+		Path("/environments/{environment}/lock").
+		Handler(wrapWithMiddleware(
+			handler.CreateLockHandler(gitLabService, userService),
+			cfg.OAuthEnabled,
+		))
+
+	r.Methods("DELETE").
+		Path("/environments/{environment}/lock").
+		Handler(wrapWithMiddleware(
+			handler.CreateUnlockHandler(gitLabService),
+			cfg.OAuthEnabled,
+		))
+
+	r.Methods("GET").
+		Path("/jobs").
 		Handler(wrapWithMiddleware(
-			handler.CreateHealthHandler(),
+			handler.CreateListJobsHandler(gitLabService),
+			cfg.OAuthEnabled,
+		))
+
+	// API tokens are managed by logged in users, tokens cannot manage tokens
+	r.Methods("GET").
+		Path("/tokens").
+		Handler(wrapWithMiddleware(
+			handler.CreateListTokensHandler(tokens, userService, cfg.AdminUsers),
 			false,
 		))
 
-	r.Methods("GET", "POST").
-		Path("/error").
+	r.Methods("POST").
+		Path("/tokens").
 		Handler(wrapWithMiddleware(
-			handler.CreateErrorHandler(),
+			handler.CreateTokenHandler(tokens, userService, cfg.AdminUsers),
 			false,
 		))
 
-	r.Path("/metrics").
-		Handler(promhttp.Handler())
+	r.Methods("DELETE").
+		Path("/tokens/{id}").
+		Handler(wrapWithMiddleware(
+			handler.CreateRevokeTokenHandler(tokens, userService, cfg.AdminUsers),
+			false,
+		))
 
-	r.PathPrefix("/").
+	// Admin area, only users of ADMIN_USERS are allowed
+	// It is unavailable without OAuth because there are no users
+	r.Methods("GET").
+		Path("/admin/sessions").
 		Handler(wrapWithMiddleware(
-			handler.CreateSPAHandler(cfg.PublicDir, "index.html"),
+			handler.CreateAdminMiddleware(userService, cfg.AdminUsers, handler.CreateListSessionsHandler(sessions)),
+			false,
+		))
+
+	r.Methods("DELETE").
+		Path("/admin/sessions/{id}").
+		Handler(wrapWithMiddleware(
+			handler.CreateAdminMiddleware(userService, cfg.AdminUsers, handler.CreateRevokeSessionHandler(sessions)),
+			false,
+		))
+
+	// Warning!!!
+	// Public area bellow
+	// It's not restricted area
+	// We don't check token here
+	r.Methods("GET").
+		Path("/config").
+		Handler(wrapWithMiddleware(
+			handler.CreateConfigHandler(userService, cfg),
 			false,
 		))
 }
@@ -192,48 +543,64 @@ func addRoutes(r *mux.Router, gitLabService *gitlab.Service, cfg config.Config,
 // `restrictedArea` forbids unauthorized actions
 type MiddlewareWrapper func(handlerFunc http.HandlerFunc, restrictedArea bool) (wrappedHandler http.Handler)
 
-func CreateMiddlewareWrapper(userService *gitlab.UserService) MiddlewareWrapper {
+// CreateMiddlewareWrapper creates a wrapper, `timeout` is a deadline of every request
+func CreateMiddlewareWrapper(userService *gitlab.UserService, timeout time.Duration) MiddlewareWrapper {
 	return func(handlerFunc http.HandlerFunc, restrictedArea bool) (wrappedHandler http.Handler) {
 		wrappedHandler = handlers.CombinedLoggingHandler(os.Stdout, handlerFunc)
 		if restrictedArea {
 			wrappedHandler = handler.CreateAuthMiddleware(userService, wrappedHandler)
 		}
+		wrappedHandler = handler.CreateTimeoutMiddleware(timeout, wrappedHandler)
 		return
 	}
 }
 
-func scheduleUpdateEnvironments(service *gitlab.Service, duration time.Duration, projectIDs []int) {
+// scheduleUpdateEnvironments updates environments until the context is canceled
+func scheduleUpdateEnvironments(ctx context.Context, service *gitlab.Service, duration time.Duration, projectIDs []int) {
 	log.Infof("environments will be updated every: %v\n", duration)
 	go func() {
 		for {
 			log.Info("environments update has been started")
 			start := time.Now()
-			err := service.UpdateEnvironments(projectIDs)
+			err := service.UpdateEnvironments(ctx, projectIDs)
+			metrics.ObserveRefresh(metrics.CacheEnvironments, start, err)
 			status := "environments update has been completed."
 			if err != nil {
 				status = "error occurred"
 				log.Error(err)
+			} else {
+				metrics.ObserveEnvironments(service.GetEnvironments())
 			}
 			log.Infof("%s. elapsed: %v\n", status, time.Since(start))
-			<-time.After(duration)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(duration):
+			}
 		}
 	}()
 }
 
-func scheduleUpdateBranches(service *gitlab.Service, duration time.Duration, projectIDs []int) {
+// scheduleUpdateBranches updates branches until the context is canceled
+func scheduleUpdateBranches(ctx context.Context, service *gitlab.Service, duration time.Duration, projectIDs []int) {
 	log.Infof("branches will be updated every: %v\n", duration)
 	go func() {
 		for {
 			log.Info("branches update has been started")
 			start := time.Now()
-			err := service.UpdateBranches(projectIDs)
+			err := service.UpdateBranches(ctx, projectIDs)
+			metrics.ObserveRefresh(metrics.CacheBranches, start, err)
 			status := "branches update has been completed."
 			if err != nil {
 				status = "error occurred"
 				log.Error(err)
 			}
 			log.Infof("%s. elapsed: %v\n", status, time.Since(start))
-			<-time.After(duration)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(duration):
+			}
 		}
 	}()
 }
@@ -0,0 +1,345 @@
+/*
+Package apitoken keeps API tokens of automation clients
+
+A token is sent in `Authorization: Bearer <secret>` header. Only SHA-256 hashes of secrets are stored,
+the secret is shown once when the token is created. Every token has scopes and could be restricted
+to some environments. Tokens are kept in memory and saved to a JSON file when it's given.
+*/
+package apitoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretPrefix makes secrets of the dashboard recognizable, i.e. by secret scanners
+const SecretPrefix = "gld_"
+
+// lastUsedSaveInterval limits how often usages of tokens are saved to the file
+const lastUsedSaveInterval = time.Minute
+
+// Scope is a permission of a token
+// Every scope includes lower ones, a deploy token could read and an admin token could deploy
+type Scope string
+
+const (
+	ScopeRead   Scope = "read"
+	ScopeDeploy Scope = "deploy"
+	ScopeAdmin  Scope = "admin"
+)
+
+var scopeLevels = map[Scope]int{
+	ScopeRead:   1,
+	ScopeDeploy: 2,
+	ScopeAdmin:  3,
+}
+
+var (
+	TokenNotFound             = errors.New("token not found")
+	InvalidScope              = errors.New("invalid scope, read, deploy or admin is expected")
+	NameIsEmpty               = errors.New("name is empty")
+	InvalidEnvironmentPattern = errors.New("invalid environment pattern")
+)
+
+// Token is an API token without its secret
+type Token struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Owner Owner  `json:"owner"`
+	// Scopes are permissions of the token
+	Scopes []Scope `json:"scopes"`
+	// Environments are names or path.Match patterns of environments, the token could be used for any if it's empty
+	Environments []string   `json:"environments"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastUsedAt   *time.Time `json:"lastUsedAt"`
+}
+
+// Owner is a user who created the token, actions of the token are attributed to the owner
+type Owner struct {
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatarURL"`
+}
+
+// HasScope checks the scope or a higher one
+func (t *Token) HasScope(scope Scope) bool {
+	for _, tokenScope := range t.Scopes {
+		if scopeLevels[tokenScope] >= scopeLevels[scope] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsEnvironment checks environment restrictions of the token
+// An empty environment is a request to all environments, i.e. the list of environments,
+// restricted tokens could only read it
+func (t *Token) AllowsEnvironment(scope Scope, environment string) bool {
+	if len(t.Environments) == 0 {
+		return true
+	}
+	if environment == "" {
+		return scope == ScopeRead
+	}
+	for _, pattern := range t.Environments {
+		if matched, _ := path.Match(pattern, environment); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// storedToken is a token with the hash of its secret, it's only saved to the file
+type storedToken struct {
+	Token
+	Hash string `json:"hash"`
+}
+
+// Store creates, authenticates and revokes tokens
+type Store struct {
+	file string
+
+	mtx    sync.RWMutex
+	tokens map[string]*storedToken
+	// savedAt is the last time tokens were saved to the file
+	savedAt time.Time
+
+	now func() time.Time
+}
+
+// NewStore creates a store of tokens of the file
+// The file is created with the first token, tokens are only kept in memory if `file` is empty
+func NewStore(file string) (*Store, error) {
+	store := &Store{
+		file:   file,
+		tokens: map[string]*storedToken{},
+		now:    time.Now,
+	}
+	if file == "" {
+		return store, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tokens []*storedToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", file, err)
+	}
+	for _, token := range tokens {
+		store.tokens[token.ID] = token
+	}
+
+	return store, nil
+}
+
+// Create creates a token and returns it with its secret
+// The secret cannot be got later, only its hash is stored
+func (s *Store) Create(name string, owner Owner, scopes []Scope, environments []string) (*Token, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", NameIsEmpty
+	}
+	if len(scopes) == 0 {
+		return nil, "", InvalidScope
+	}
+	for _, scope := range scopes {
+		if _, ok := scopeLevels[scope]; !ok {
+			return nil, "", InvalidScope
+		}
+	}
+	for _, pattern := range environments {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, "", fmt.Errorf("%w `%s`", InvalidEnvironmentPattern, pattern)
+		}
+	}
+
+	id, err := randomString(9)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+	secret = SecretPrefix + secret
+
+	token := &storedToken{
+		Token: Token{
+			ID:           id,
+			Name:         name,
+			Owner:        owner,
+			Scopes:       scopes,
+			Environments: environments,
+			CreatedAt:    s.now(),
+		},
+		Hash: hash(secret),
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.tokens[id] = token
+	if err := s.save(); err != nil {
+		delete(s.tokens, id)
+		return nil, "", err
+	}
+
+	copied := token.Token
+	return &copied, secret, nil
+}
+
+// Authenticate returns the token of the secret and records its usage
+// TokenNotFound is returned for unknown and revoked secrets
+func (s *Store) Authenticate(secret string) (*Token, error) {
+	if !strings.HasPrefix(secret, SecretPrefix) {
+		return nil, TokenNotFound
+	}
+	secretHash := hash(secret)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, token := range s.tokens {
+		if token.Hash != secretHash {
+			continue
+		}
+		now := s.now()
+		token.LastUsedAt = &now
+		// Usages aren't worth writing the file on every request
+		if now.Sub(s.savedAt) >= lastUsedSaveInterval {
+			_ = s.save()
+		}
+
+		copied := token.Token
+		return &copied, nil
+	}
+
+	return nil, TokenNotFound
+}
+
+// Get returns the token by ID
+func (s *Store) Get(id string) (*Token, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return nil, TokenNotFound
+	}
+
+	copied := token.Token
+	return &copied, nil
+}
+
+// List returns tokens of the owner, tokens of all owners if `owner` is empty
+// The newest tokens are first
+func (s *Store) List(owner string) []*Token {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	tokens := make([]*Token, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		if owner != "" && token.Owner.Username != owner {
+			continue
+		}
+		copied := token.Token
+		tokens = append(tokens, &copied)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+
+	return tokens
+}
+
+// Revoke removes the token, it stops working immediately
+func (s *Store) Revoke(id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return TokenNotFound
+	}
+	delete(s.tokens, id)
+	if err := s.save(); err != nil {
+		s.tokens[id] = token
+		return err
+	}
+
+	return nil
+}
+
+// save writes tokens to the file, it should be called under the lock
+// The file is replaced atomically, so a crash doesn't leave a broken file
+func (s *Store) save() error {
+	s.savedAt = s.now()
+	if s.file == "" {
+		return nil
+	}
+
+	tokens := make([]*storedToken, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.file), filepath.Base(s.file)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot save tokens: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot save tokens: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot save tokens: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.file); err != nil {
+		return fmt.Errorf("cannot save tokens: %v", err)
+	}
+
+	return nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
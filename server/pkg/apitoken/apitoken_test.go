@@ -0,0 +1,177 @@
+package apitoken
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var owner = Owner{Username: "jdoe", Name: "John Doe"}
+
+func TestCreateAndAuthenticate(t *testing.T) {
+	store, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, secret, err := store.Create("ci", owner, []Scope{ScopeDeploy}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, SecretPrefix) {
+		t.Errorf("Create() secret = %s, want %s prefix", secret, SecretPrefix)
+	}
+	if token.LastUsedAt != nil {
+		t.Errorf("Create() token is used already")
+	}
+
+	authenticated, err := store.Authenticate(secret)
+	if err != nil || authenticated.ID != token.ID {
+		t.Fatalf("Authenticate() = %v, %v, want the token", authenticated, err)
+	}
+	if authenticated.LastUsedAt == nil {
+		t.Errorf("Authenticate() doesn't record the usage")
+	}
+
+	for _, wrongSecret := range []string{"", secret + "x", strings.TrimPrefix(secret, SecretPrefix)} {
+		if _, err := store.Authenticate(wrongSecret); err != TokenNotFound {
+			t.Errorf("Authenticate(%q) error = %v, want %v", wrongSecret, err, TokenNotFound)
+		}
+	}
+
+	if err := store.Revoke(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Authenticate(secret); err != TokenNotFound {
+		t.Errorf("Authenticate() of a revoked token error = %v, want %v", err, TokenNotFound)
+	}
+	if err := store.Revoke(token.ID); err != TokenNotFound {
+		t.Errorf("Revoke() twice error = %v, want %v", err, TokenNotFound)
+	}
+}
+
+func TestCreateValidates(t *testing.T) {
+	tests := []struct {
+		name         string
+		tokenName    string
+		scopes       []Scope
+		environments []string
+		wantErr      error
+	}{
+		{"emptyName", " ", []Scope{ScopeRead}, nil, NameIsEmpty},
+		{"withoutScopes", "ci", nil, nil, InvalidScope},
+		{"unknownScope", "ci", []Scope{"write"}, nil, InvalidScope},
+		{"badPattern", "ci", []Scope{ScopeRead}, []string{"qa["}, InvalidEnvironmentPattern},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewStore("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := store.Create(tt.tokenName, owner, tt.scopes, tt.environments); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Create() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(store.List("")) != 0 {
+				t.Errorf("List() has an invalid token")
+			}
+		})
+	}
+}
+
+func TestScopesAndEnvironments(t *testing.T) {
+	tests := []struct {
+		name         string
+		scopes       []Scope
+		environments []string
+		scope        Scope
+		environment  string
+		want         bool
+	}{
+		{"read", []Scope{ScopeRead}, nil, ScopeRead, "qa", true},
+		{"readCannotDeploy", []Scope{ScopeRead}, nil, ScopeDeploy, "qa", false},
+		{"deployCouldRead", []Scope{ScopeDeploy}, nil, ScopeRead, "qa", true},
+		{"deployIsNotAdmin", []Scope{ScopeDeploy}, nil, ScopeAdmin, "", false},
+		{"adminCouldDeploy", []Scope{ScopeAdmin}, nil, ScopeDeploy, "qa", true},
+		{"sameEnvironment", []Scope{ScopeDeploy}, []string{"qa"}, ScopeDeploy, "qa", true},
+		{"otherEnvironment", []Scope{ScopeDeploy}, []string{"qa"}, ScopeDeploy, "staging", false},
+		{"pattern", []Scope{ScopeDeploy}, []string{"review-*"}, ScopeDeploy, "review-42", true},
+		{"restrictedReadsAll", []Scope{ScopeDeploy}, []string{"qa"}, ScopeRead, "", true},
+		{"restrictedCannotChangeAll", []Scope{ScopeDeploy}, []string{"qa"}, ScopeDeploy, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := &Token{Scopes: tt.scopes, Environments: tt.environments}
+			got := token.HasScope(tt.scope) && token.AllowsEnvironment(tt.scope, tt.environment)
+			if got != tt.want {
+				t.Errorf("token allows %s on %q = %v, want %v", tt.scope, tt.environment, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	store, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	for _, username := range []string{"jdoe", "jane", "jdoe"} {
+		now = now.Add(time.Minute)
+		if _, _, err := store.Create("ci", Owner{Username: username}, []Scope{ScopeRead}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tokens := store.List("jdoe")
+	if len(tokens) != 2 || !tokens[0].CreatedAt.After(tokens[1].CreatedAt) {
+		t.Errorf("List(jdoe) = %v, want 2 tokens, the newest first", tokens)
+	}
+	if len(store.List("")) != 3 {
+		t.Errorf("List() has %d tokens, want 3", len(store.List("")))
+	}
+}
+
+func TestFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	store, err := NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, secret, err := store.Create("ci", owner, []Scope{ScopeDeploy}, []string{"qa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, _, err := store.Create("old", owner, []Scope{ScopeRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secret) {
+		t.Errorf("file has the secret: %s", data)
+	}
+
+	reloaded, err := NewStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticated, err := reloaded.Authenticate(secret)
+	if err != nil || authenticated.ID != token.ID || authenticated.Environments[0] != "qa" {
+		t.Errorf("Authenticate() after reload = %v, %v, want the token", authenticated, err)
+	}
+	if _, err := reloaded.Get(revoked.ID); err != TokenNotFound {
+		t.Errorf("Get() of the revoked token after reload error = %v, want %v", err, TokenNotFound)
+	}
+}
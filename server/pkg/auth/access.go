@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"gitlab-environment-dashboard/server/pkg/session"
+	"sync"
+	"time"
+)
+
+// AccessCache caches decisions of AccessFunc for `ttl` like sessions cache them
+// It checks users who don't have sessions, i.e. users of trusted headers and owners of API tokens
+type AccessCache struct {
+	access AccessFunc
+	ttl    time.Duration
+
+	mtx      sync.Mutex
+	accesses map[string]*cachedAccess
+
+	now func() time.Time
+}
+
+type cachedAccess struct {
+	access    session.Access
+	checkedAt time.Time
+}
+
+// NewAccessCache creates an empty cache of the access function
+func NewAccessCache(access AccessFunc, ttl time.Duration) *AccessCache {
+	return &AccessCache{
+		access:   access,
+		ttl:      ttl,
+		accesses: map[string]*cachedAccess{},
+		now:      time.Now,
+	}
+}
+
+// Check returns the cached decision or checks the user again when it's expired
+// The cached decision is used when the check fails, i.e. GitLab is unavailable
+func (c *AccessCache) Check(ctx context.Context, username string) (session.Access, error) {
+	c.mtx.Lock()
+	cached, ok := c.accesses[username]
+	c.mtx.Unlock()
+	now := c.now()
+	if ok && now.Sub(cached.checkedAt) < c.ttl {
+		return cached.access, nil
+	}
+
+	access, err := c.access(ctx, username)
+	if err != nil {
+		if ok {
+			log.WithContext(ctx).Errorf("cannot recheck access of %s: %v", username, err)
+			return cached.access, nil
+		}
+		return session.Access{}, err
+	}
+
+	c.mtx.Lock()
+	c.accesses[username] = &cachedAccess{access: access, checkedAt: now}
+	c.mtx.Unlock()
+
+	return access, nil
+}
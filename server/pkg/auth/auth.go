@@ -0,0 +1,72 @@
+/*
+Package auth authenticates users of the dashboard
+
+A Provider finds the user of a request. Users of GitLab OAuth and OpenID Connect providers
+log in on the dashboard and are kept in sessions. Users of trusted headers are authenticated
+by a reverse proxy in front of the dashboard, i.e. an SSO gateway.
+*/
+package auth
+
+import (
+	"context"
+	"errors"
+	"gitlab-environment-dashboard/server/pkg/session"
+	"net/http"
+)
+
+// Names of providers
+const (
+	ProviderGitLab  = "gitlab"
+	ProviderOIDC    = "oidc"
+	ProviderHeaders = "headers"
+)
+
+// UnknownProvider is returned for a name which isn't one of Provider* constants
+var UnknownProvider = errors.New("unknown auth provider, gitlab, oidc or headers is expected")
+
+// Provider finds the user of a request
+type Provider interface {
+	// Name is one of Provider* constants
+	Name() string
+	// User returns the user of the request, nil if the request isn't authenticated
+	User(request *http.Request) (*session.User, error)
+}
+
+// AccessFunc decides if the user is allowed to use the dashboard
+type AccessFunc func(ctx context.Context, username string) (session.Access, error)
+
+// SessionProvider finds users by the session cookie
+// Sessions are created by the login of GitLab OAuth or an OpenID Connect provider
+type SessionProvider struct {
+	name     string
+	sessions *session.Manager
+}
+
+// NewSessionProvider creates a provider of sessions, `name` is the provider which users log in with
+func NewSessionProvider(name string, sessions *session.Manager) *SessionProvider {
+	return &SessionProvider{name: name, sessions: sessions}
+}
+
+func (p *SessionProvider) Name() string {
+	return p.name
+}
+
+func (p *SessionProvider) User(request *http.Request) (*session.User, error) {
+	sessionCookie, err := request.Cookie(session.CookieName)
+	if err != nil && err != http.ErrNoCookie {
+		return nil, errors.New("cannot read cookies")
+	}
+	if sessionCookie == nil || p.sessions == nil {
+		return nil, nil
+	}
+
+	userSession, err := p.sessions.Get(request.Context(), sessionCookie.Value)
+	if err == session.SessionNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &userSession.User, nil
+}
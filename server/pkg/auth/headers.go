@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gitlab-environment-dashboard/server/pkg/session"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NotTrustedProxy is returned when trusted headers have neither the secret nor trusted networks
+var NotTrustedProxy = errors.New("trusted headers need a proxy secret or trusted networks")
+
+// HeadersConfig describes headers of the authenticating proxy
+type HeadersConfig struct {
+	// UserHeader has the username, i.e. X-Forwarded-User
+	UserHeader string
+	// NameHeader and AvatarHeader are optional
+	NameHeader   string
+	AvatarHeader string
+	// SecretHeader should have Secret when the secret is given
+	SecretHeader string
+	Secret       string
+	// TrustedNetworks are CIDRs of the proxy, i.e. 10.0.0.0/8
+	TrustedNetworks []string
+}
+
+// HeadersProvider trusts the user of headers which are set by an authenticating proxy
+// Only requests of the proxy are trusted, they should have the shared secret or come from trusted networks
+// Access of users is checked by AccessFunc and cached for `accessTTL`
+type HeadersProvider struct {
+	config   HeadersConfig
+	networks []*net.IPNet
+	access   *AccessCache
+}
+
+// NewHeadersProvider creates a provider of trusted headers
+func NewHeadersProvider(config HeadersConfig, access AccessFunc, accessTTL time.Duration) (*HeadersProvider, error) {
+	if config.UserHeader == "" {
+		return nil, errors.New("user header is empty")
+	}
+	if config.Secret == "" && len(config.TrustedNetworks) == 0 {
+		return nil, NotTrustedProxy
+	}
+	if config.Secret != "" && config.SecretHeader == "" {
+		return nil, errors.New("secret header is empty")
+	}
+
+	networks := make([]*net.IPNet, 0, len(config.TrustedNetworks))
+	for _, cidr := range config.TrustedNetworks {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse trusted network: %v", err)
+		}
+		networks = append(networks, network)
+	}
+
+	return &HeadersProvider{
+		config:   config,
+		networks: networks,
+		access:   NewAccessCache(access, accessTTL),
+	}, nil
+}
+
+func (p *HeadersProvider) Name() string {
+	return ProviderHeaders
+}
+
+func (p *HeadersProvider) User(request *http.Request) (*session.User, error) {
+	username := strings.TrimSpace(request.Header.Get(p.config.UserHeader))
+	if username == "" {
+		return nil, nil
+	}
+	if !p.isTrusted(request) {
+		// Anyone could send the header, so it's ignored but logged to find misconfigured proxies
+		log.WithContext(request.Context()).Warnf("%s header of an untrusted request from %s is ignored", p.config.UserHeader, request.RemoteAddr)
+		return nil, nil
+	}
+
+	access, err := p.access.Check(request.Context(), username)
+	if err != nil {
+		return nil, fmt.Errorf("cannot check access of %s: %w", username, err)
+	}
+
+	user := &session.User{
+		Username: username,
+		Name:     username,
+		Access:   access,
+	}
+	if p.config.NameHeader != "" && request.Header.Get(p.config.NameHeader) != "" {
+		user.Name = request.Header.Get(p.config.NameHeader)
+	}
+	if p.config.AvatarHeader != "" {
+		user.AvatarURL = request.Header.Get(p.config.AvatarHeader)
+	}
+
+	return user, nil
+}
+
+// isTrusted checks the secret and the address of the proxy, both are checked when both are given
+// The address is the one of the connection, X-Forwarded-For could be sent by anyone
+func (p *HeadersProvider) isTrusted(request *http.Request) bool {
+	if p.config.Secret != "" {
+		secret := request.Header.Get(p.config.SecretHeader)
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(p.config.Secret)) != 1 {
+			return false
+		}
+	}
+	if len(p.networks) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
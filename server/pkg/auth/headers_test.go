@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"gitlab-environment-dashboard/server/pkg/session"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func allowAll(_ context.Context, username string) (session.Access, error) {
+	return session.Access{Allowed: true, Reasons: []string{username + " is allowed"}}, nil
+}
+
+func TestNewHeadersProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  HeadersConfig
+		wantErr bool
+	}{
+		{"secret", HeadersConfig{UserHeader: "X-Forwarded-User", SecretHeader: "X-Auth-Proxy-Secret", Secret: "s3cret"}, false},
+		{"networks", HeadersConfig{UserHeader: "X-Forwarded-User", TrustedNetworks: []string{"10.0.0.0/8"}}, false},
+		{"notTrusted", HeadersConfig{UserHeader: "X-Forwarded-User"}, true},
+		{"badNetwork", HeadersConfig{UserHeader: "X-Forwarded-User", TrustedNetworks: []string{"10.0.0.1"}}, true},
+		{"withoutUserHeader", HeadersConfig{TrustedNetworks: []string{"10.0.0.0/8"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewHeadersProvider(tt.config, allowAll, time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewHeadersProvider() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHeadersProviderUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		secret     string
+		networks   []string
+		remoteAddr string
+		headers    map[string]string
+		wantUser   string
+	}{
+		{"secret", "s3cret", nil, "192.0.2.1:1234", map[string]string{"X-Auth-Proxy-Secret": "s3cret"}, "jdoe"},
+		{"wrongSecret", "s3cret", nil, "192.0.2.1:1234", map[string]string{"X-Auth-Proxy-Secret": "guess"}, ""},
+		{"withoutSecret", "s3cret", nil, "192.0.2.1:1234", nil, ""},
+		{"trustedNetwork", "", []string{"10.0.0.0/8"}, "10.1.2.3:1234", nil, "jdoe"},
+		{"untrustedNetwork", "", []string{"10.0.0.0/8"}, "192.0.2.1:1234", nil, ""},
+		// X-Forwarded-For is set by clients, only the address of the connection is trusted
+		{"forwardedFor", "", []string{"10.0.0.0/8"}, "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "10.1.2.3"}, ""},
+		{"secretAndNetwork", "s3cret", []string{"10.0.0.0/8"}, "10.1.2.3:1234", map[string]string{"X-Auth-Proxy-Secret": "s3cret"}, "jdoe"},
+		{"secretFromUntrustedNetwork", "s3cret", []string{"10.0.0.0/8"}, "192.0.2.1:1234", map[string]string{"X-Auth-Proxy-Secret": "s3cret"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := NewHeadersProvider(HeadersConfig{
+				UserHeader:      "X-Forwarded-User",
+				NameHeader:      "X-Forwarded-Name",
+				SecretHeader:    "X-Auth-Proxy-Secret",
+				Secret:          tt.secret,
+				TrustedNetworks: tt.networks,
+			}, allowAll, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request := httptest.NewRequest("GET", "/environments", nil)
+			request.RemoteAddr = tt.remoteAddr
+			request.Header.Set("X-Forwarded-User", "jdoe")
+			request.Header.Set("X-Forwarded-Name", "John Doe")
+			for name, value := range tt.headers {
+				request.Header.Set(name, value)
+			}
+
+			user, err := provider.User(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantUser == "" {
+				if user != nil {
+					t.Errorf("User() = %+v, want no user", user)
+				}
+				return
+			}
+			if user == nil || user.Username != tt.wantUser || user.Name != "John Doe" || !user.Access.Allowed {
+				t.Errorf("User() = %+v, want allowed %s", user, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestHeadersProviderCachesAccess(t *testing.T) {
+	checks := 0
+	var checkErr error
+	access := func(ctx context.Context, username string) (session.Access, error) {
+		checks++
+		if checkErr != nil {
+			return session.Access{}, checkErr
+		}
+		return allowAll(ctx, username)
+	}
+	provider, err := NewHeadersProvider(HeadersConfig{
+		UserHeader:      "X-Forwarded-User",
+		TrustedNetworks: []string{"192.0.2.0/24"},
+	}, access, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	provider.access.now = func() time.Time { return now }
+	request := httptest.NewRequest("GET", "/environments", nil)
+	request.Header.Set("X-Forwarded-User", "jdoe")
+
+	for i := 0; i < 3; i++ {
+		if _, err := provider.User(request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if checks != 1 {
+		t.Errorf("User() checked access %d times, want once", checks)
+	}
+
+	// GitLab is unavailable, the cached decision is used
+	now = now.Add(5 * time.Minute)
+	checkErr = errors.New("connection refused")
+	if user, err := provider.User(request); err != nil || !user.Access.Allowed || checks != 2 {
+		t.Errorf("User() = %+v, %v after %d checks, want the cached access rechecked", user, err, checks)
+	}
+
+	// A new user cannot be checked
+	request.Header.Set("X-Forwarded-User", "jane")
+	if _, err := provider.User(request); err == nil {
+		t.Errorf("User() of an unchecked user error = nil, want the error")
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gitlab-environment-dashboard/server/pkg/session"
+	"net/http"
+)
+
+// DefaultUsernameClaim is a claim of OpenID Connect userinfo with the username
+const DefaultUsernameClaim = "preferred_username"
+
+// NewOIDCValidator creates a session.ValidateFunc which asks the userinfo endpoint for the user of the token
+// The username is taken from `usernameClaim`, it should match GitLab usernames for allow-lists and admins
+// It returns session.Unauthorized when the provider rejects the token
+func NewOIDCValidator(userInfoURL string, httpClient *http.Client, usernameClaim string, access AccessFunc) session.ValidateFunc {
+	return func(ctx context.Context, accessToken string) (*session.User, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, userInfoURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Authorization", "Bearer "+accessToken)
+		request.Header.Set("Accept", "application/json")
+
+		resp, err := httpClient.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return nil, session.Unauthorized
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("userinfo endpoint responded with %d", resp.StatusCode)
+		}
+
+		claims := map[string]interface{}{}
+		if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+			return nil, fmt.Errorf("cannot decode userinfo: %v", err)
+		}
+		username, _ := claims[usernameClaim].(string)
+		if username == "" {
+			return nil, fmt.Errorf("userinfo doesn't have `%s` claim", usernameClaim)
+		}
+
+		userAccess, err := access(ctx, username)
+		if err != nil {
+			return nil, fmt.Errorf("cannot check access of %s: %w", username, err)
+		}
+
+		user := &session.User{
+			Username: username,
+			Name:     username,
+			Access:   userAccess,
+		}
+		if name, ok := claims["name"].(string); ok && name != "" {
+			user.Name = name
+		}
+		if picture, ok := claims["picture"].(string); ok {
+			user.AvatarURL = picture
+		}
+
+		return user, nil
+	}
+}
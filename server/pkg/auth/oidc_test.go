@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"gitlab-environment-dashboard/server/pkg/session"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOIDCValidator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer access":
+			w.Write([]byte(`{"sub":"42","preferred_username":"jdoe","name":"John Doe","picture":"https://sso/jdoe.png"}`))
+		case "Bearer withoutUsername":
+			w.Write([]byte(`{"sub":"43"}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		accessToken string
+		wantUser    *session.User
+		wantErr     error
+	}{
+		{"ok", "access", &session.User{Username: "jdoe", Name: "John Doe", AvatarURL: "https://sso/jdoe.png"}, nil},
+		{"revoked", "revoked", nil, session.Unauthorized},
+		{"withoutUsername", "withoutUsername", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validate := NewOIDCValidator(server.URL, server.Client(), DefaultUsernameClaim, allowAll)
+			user, err := validate(context.Background(), tt.accessToken)
+			if tt.wantUser == nil {
+				if err == nil || (tt.wantErr != nil && err != tt.wantErr) {
+					t.Errorf("validate() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Username != tt.wantUser.Username || user.Name != tt.wantUser.Name || user.AvatarURL != tt.wantUser.AvatarURL {
+				t.Errorf("validate() = %+v, want %+v", user, tt.wantUser)
+			}
+			if !user.Access.Allowed {
+				t.Errorf("validate() doesn't check access")
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Package breaker is a circuit breaker of outgoing HTTP requests
+
+When a server fails `threshold` times in a row the breaker opens and rejects requests
+without sending them. After `cooldown` it lets one probe request through (half-open state),
+a successful probe closes the breaker, a failed one opens it again.
+*/
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var CircuitIsOpen = errors.New("circuit breaker is open")
+
+// States of a breaker
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+// Breaker tracks failures of requests sent through its transport
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mtx      sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	// probing is set while the probe request of the half-open breaker is in flight
+	probing bool
+
+	now func() time.Time
+}
+
+// New creates a closed breaker
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     StateClosed,
+		now:       time.Now,
+	}
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.state == StateOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		return StateHalfOpen
+	}
+
+	return b.state
+}
+
+// allow checks if a request could be sent
+func (b *Breaker) allow() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return CircuitIsOpen
+		}
+		b.state = StateHalfOpen
+		b.probing = true
+	case StateHalfOpen:
+		// Only one probe at the same time
+		if b.probing {
+			return CircuitIsOpen
+		}
+		b.probing = true
+	}
+
+	return nil
+}
+
+// record counts the result of a sent request
+func (b *Breaker) record(success bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.probing = false
+	if success {
+		b.state = StateClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.threshold {
+		b.state = StateOpen
+		b.openedAt = b.now()
+	}
+}
+
+// release lets the next probe through without counting the result of the request
+func (b *Breaker) release() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.probing = false
+}
+
+// isFailure checks if the server is failing
+// Client errors (including 429) mean the server is alive
+func isFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented
+}
+
+// isCanceled checks if the caller canceled the request before its deadline
+// Exceeded deadlines (`http.Client.Timeout` is a deadline of the request context too) mean the server is too slow
+func isCanceled(request *http.Request) bool {
+	ctx := request.Context()
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		return false
+	}
+	// The client cancels the request when its timeout fires, which may happen before the context deadline
+	deadline, ok := ctx.Deadline()
+
+	return !ok || time.Now().Before(deadline)
+}
+
+type transport struct {
+	breaker *Breaker
+	next    http.RoundTripper
+}
+
+func (t *transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		// The transport should close the body even on errors
+		if request.Body != nil {
+			request.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(request)
+	// Requests canceled by our side say nothing about the server
+	if err != nil && isCanceled(request) {
+		t.breaker.release()
+	} else {
+		t.breaker.record(!isFailure(resp, err))
+	}
+
+	return resp, err
+}
+
+// Transport sends requests through `next` while the breaker isn't open
+func (b *Breaker) Transport(next http.RoundTripper) http.RoundTripper {
+	return &transport{breaker: b, next: next}
+}
@@ -0,0 +1,101 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Now()
+	b := New(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	status := http.StatusBadGateway
+	sent := 0
+	transport := b.Transport(roundTripFunc(func(request *http.Request) (*http.Response, error) {
+		sent++
+		if status == 0 {
+			// The server hangs until the request is canceled
+			<-request.Context().Done()
+			return nil, request.Context().Err()
+		}
+		return &http.Response{StatusCode: status}, nil
+	}))
+	send := func(ctx context.Context) error {
+		_, err := transport.RoundTrip(httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+		return err
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	withTimeout := func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), time.Millisecond)
+	}
+
+	steps := []struct {
+		name    string
+		status  int
+		ctx     func() (context.Context, context.CancelFunc)
+		advance time.Duration
+		err     error
+		state   string
+		sent    int
+	}{
+		{"first failure", http.StatusBadGateway, nil, 0, nil, StateClosed, 1},
+		{"threshold reached", http.StatusServiceUnavailable, nil, 0, nil, StateOpen, 2},
+		{"rejected while open", http.StatusOK, nil, time.Second, CircuitIsOpen, StateOpen, 2},
+		{"failed probe", http.StatusBadGateway, nil, time.Minute, nil, StateOpen, 3},
+		{"successful probe", http.StatusOK, nil, time.Minute, nil, StateClosed, 4},
+		{"client errors are not failures", http.StatusTooManyRequests, nil, 0, nil, StateClosed, 5},
+		{"canceled by the caller", 0, func() (context.Context, context.CancelFunc) { return canceled, cancel }, 0, context.Canceled, StateClosed, 6},
+		{"deadline exceeded", 0, withTimeout, 0, context.DeadlineExceeded, StateClosed, 7},
+		{"deadline exceeded again", 0, withTimeout, 0, context.DeadlineExceeded, StateOpen, 8},
+	}
+	for _, step := range steps {
+		status = step.status
+		now = now.Add(step.advance)
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if step.ctx != nil {
+			ctx, cancel = step.ctx()
+		}
+		err := send(ctx)
+		cancel()
+		if !errors.Is(err, step.err) {
+			t.Errorf("%s: err = %v, want %v", step.name, err, step.err)
+		}
+		if state := b.State(); state != step.state {
+			t.Errorf("%s: state = %s, want %s", step.name, state, step.state)
+		}
+		if sent != step.sent {
+			t.Errorf("%s: sent = %d, want %d", step.name, sent, step.sent)
+		}
+	}
+}
+
+func TestBreakerCountsClientTimeouts(t *testing.T) {
+	b := New(1, time.Minute)
+	client := &http.Client{
+		Timeout: 10 * time.Millisecond,
+		Transport: b.Transport(roundTripFunc(func(request *http.Request) (*http.Response, error) {
+			<-request.Context().Done()
+			return nil, request.Context().Err()
+		})),
+	}
+
+	if _, err := client.Get("http://gitlab.example.com"); err == nil {
+		t.Fatal("Get() succeeded, want timeout")
+	}
+	if state := b.State(); state != StateOpen {
+		t.Errorf("state = %s, want %s", state, StateOpen)
+	}
+}
@@ -0,0 +1,378 @@
+/*
+Package chatops runs dashboard commands from Slack/Mattermost slash commands
+*/
+package chatops
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gitlab-environment-dashboard/server/pkg/auth"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Commands which could be run from a chat
+const (
+	CommandDeploy      = "deploy"
+	CommandQueryDeploy = "query-deploy"
+	CommandStatus      = "status"
+	CommandLock        = "lock"
+	CommandUnlock      = "unlock"
+	CommandHelp        = "help"
+)
+
+// Response types of Slack/Mattermost
+const (
+	ResponseInChannel = "in_channel"
+	ResponseEphemeral = "ephemeral"
+)
+
+// Slack rejects requests older than 5 minutes to prevent replay attacks, so do we
+const maxRequestAge = 5 * time.Minute
+
+const helpText = "Usage:\n" +
+	"`deploy <environment> <project> <ref>` - deploy the ref of the project\n" +
+	"`query-deploy <environment> <branch prefix> [fallback ref]` - deploy matched branches of all projects\n" +
+	"`status <environment>` - show deployed refs\n" +
+	"`lock <environment> [reason]` - forbid deploys to the environment\n" +
+	"`unlock <environment>` - allow deploys to the environment"
+
+var (
+	WrongSignature = errors.New("wrong signature")
+	UnknownUser    = errors.New("unknown chat user")
+)
+
+// Command is a parsed slash command
+type Command struct {
+	Name string
+	Args []string
+}
+
+// Response is a reply to a slash command, it's compatible with Slack and Mattermost
+type Response struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// VerifySlackSignature checks `X-Slack-Signature` and `X-Slack-Request-Timestamp` headers
+// https://api.slack.com/authentication/verifying-requests-from-slack
+func VerifySlackSignature(secret string, timestamp string, signature string, body []byte, now time.Time) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return WrongSignature
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > maxRequestAge || age < -maxRequestAge {
+		return WrongSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return WrongSignature
+	}
+
+	return nil
+}
+
+// ParseCommand parses a slash command
+// The command could be the slash command itself (`/deploy qa2 api master`)
+// or the first word of a generic slash command (`/dashboard deploy qa2 api master`)
+func ParseCommand(slashCommand string, text string) (*Command, error) {
+	args := strings.Fields(text)
+	name := strings.TrimPrefix(slashCommand, "/")
+	if !isCommand(name) {
+		if len(args) == 0 {
+			return &Command{Name: CommandHelp}, nil
+		}
+		name, args = args[0], args[1:]
+	}
+	if !isCommand(name) {
+		return nil, fmt.Errorf("unknown command `%s`", name)
+	}
+
+	command := &Command{Name: name, Args: args}
+	switch name {
+	case CommandDeploy:
+		if len(args) != 3 {
+			return nil, errors.New("usage: `deploy <environment> <project> <ref>`")
+		}
+	case CommandQueryDeploy:
+		if len(args) != 2 && len(args) != 3 {
+			return nil, errors.New("usage: `query-deploy <environment> <branch prefix> [fallback ref]`")
+		}
+	case CommandStatus, CommandUnlock:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("usage: `%s <environment>`", name)
+		}
+	case CommandLock:
+		if len(args) < 1 {
+			return nil, errors.New("usage: `lock <environment> [reason]`")
+		}
+	}
+
+	return command, nil
+}
+
+func isCommand(name string) bool {
+	switch name {
+	case CommandDeploy, CommandQueryDeploy, CommandStatus, CommandLock, CommandUnlock, CommandHelp:
+		return true
+	}
+
+	return false
+}
+
+// Changes checks if the command deploys, locks or unlocks an environment
+func (c *Command) Changes() bool {
+	return c.Name != CommandStatus && c.Name != CommandHelp
+}
+
+// Slow checks if the command talks to GitLab and could be longer than a chat waits for a reply
+func (c *Command) Slow() bool {
+	return c.Name == CommandDeploy || c.Name == CommandQueryDeploy
+}
+
+// Executor runs commands with the same gitlab.Service methods as the REST handlers
+type Executor struct {
+	git *gitlab.Service
+	// users maps chat user IDs to GitLab usernames
+	users map[string]string
+	// access checks mapped users by allow-lists of the dashboard
+	access *auth.AccessCache
+	client *http.Client
+}
+
+// NewExecutor creates an Executor
+// Only mapped chat users are allowed, users are mapped by IDs because chat usernames could be changed by their users
+func NewExecutor(git *gitlab.Service, users map[string]string, access *auth.AccessCache) *Executor {
+	return &Executor{
+		git:    git,
+		users:  users,
+		access: access,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// User maps a chat user ID (`user_id` of a slash command) to a dashboard user
+func (e *Executor) User(chatUserID string) (*gitlab.ProjectUser, error) {
+	username, ok := e.users[chatUserID]
+	if !ok || username == "" {
+		return nil, UnknownUser
+	}
+
+	return &gitlab.ProjectUser{Username: username, Name: username}, nil
+}
+
+// checkAccess checks that the user is allowed to use the dashboard like users of the GUI and API tokens
+func (e *Executor) checkAccess(ctx context.Context, user *gitlab.ProjectUser) error {
+	if e.access == nil {
+		return nil
+	}
+	access, err := e.access.Check(ctx, user.Username)
+	if err != nil {
+		return fmt.Errorf("cannot check access of %s: %w", user.Username, err)
+	}
+	if !access.Allowed {
+		if len(access.Reasons) == 0 {
+			return gitlab.UserIsNotAllowed
+		}
+		return fmt.Errorf("%w: %s", gitlab.UserIsNotAllowed, strings.Join(access.Reasons, "; "))
+	}
+
+	return nil
+}
+
+// Execute runs the command and formats the result
+// Commands which change environments are run only for allowed users
+func (e *Executor) Execute(ctx context.Context, command *Command, user *gitlab.ProjectUser) Response {
+	if command.Changes() {
+		if err := e.checkAccess(ctx, user); err != nil {
+			return Response{ResponseType: ResponseEphemeral, Text: fmt.Sprintf("%s failed: %v", command.Name, err)}
+		}
+	}
+
+	var text string
+	var err error
+	switch command.Name {
+	case CommandDeploy:
+		text, err = e.deploy(ctx, command.Args[0], command.Args[1], command.Args[2], user)
+	case CommandQueryDeploy:
+		fallbackRef := ""
+		if len(command.Args) == 3 {
+			fallbackRef = command.Args[2]
+		}
+		text, err = e.queryDeploy(ctx, command.Args[0], command.Args[1], fallbackRef, user)
+	case CommandStatus:
+		text, err = e.status(command.Args[0])
+	case CommandLock:
+		text, err = e.lock(command.Args[0], user, strings.Join(command.Args[1:], " "))
+	case CommandUnlock:
+		text, err = e.unlock(command.Args[0])
+	default:
+		return Response{ResponseType: ResponseEphemeral, Text: helpText}
+	}
+	if err != nil {
+		return Response{ResponseType: ResponseEphemeral, Text: fmt.Sprintf("%s failed: %v", command.Name, err)}
+	}
+
+	return Response{ResponseType: ResponseInChannel, Text: fmt.Sprintf("@%s: %s", user.Username, text)}
+}
+
+// Respond posts a delayed response to `response_url` of a slash command
+func (e *Executor) Respond(ctx context.Context, responseURL string, response Response) error {
+	body, err := json.Marshal(&response)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, responseURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("response url responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// findProject finds a project of the environment by ID, name or path with namespace
+func (e *Executor) findProject(environment string, nameOrID string) (*gitlab.Project, error) {
+	for _, env := range e.git.GetEnvironments() {
+		if env.Name != environment {
+			continue
+		}
+		for _, project := range env.Projects {
+			if project == nil {
+				continue
+			}
+			if strconv.Itoa(project.ID) == nameOrID ||
+				strings.EqualFold(project.Name, nameOrID) ||
+				strings.EqualFold(project.NameWithNamespace, nameOrID) {
+				return project, nil
+			}
+		}
+		return nil, fmt.Errorf("project `%s` not found in `%s`", nameOrID, environment)
+	}
+
+	return nil, gitlab.EnvironmentNotFound
+}
+
+func (e *Executor) deploy(ctx context.Context, environment string, projectName string, ref string, user *gitlab.ProjectUser) (string, error) {
+	project, err := e.findProject(environment, projectName)
+	if err != nil {
+		return "", err
+	}
+	_, err = e.git.PlayOrRetryJob(ctx, project.ID, environment, ref, user)
+	if err != nil {
+		return "", err
+	}
+
+	text := fmt.Sprintf("deploying `%s` of %s to `%s`", ref, project.Name, environment)
+	if job, ok := e.git.GetJob(environment, project.ID); ok && job != nil {
+		text += " " + job.WebURL
+	}
+
+	return text, nil
+}
+
+func (e *Executor) queryDeploy(ctx context.Context, environment string, query string, fallbackRef string, user *gitlab.ProjectUser) (string, error) {
+	results, err := e.git.PlayOrRetryJobsWithQuery(ctx, environment, gitlab.QueryDeployOptions{
+		Query:       query,
+		FallbackRef: fallbackRef,
+	}, user)
+	if err != nil {
+		return "", err
+	}
+
+	lines := []string{fmt.Sprintf("deploying `%s` to `%s`: %d deployed, %d failed", query, environment, results.Deployed(), results.Failed())}
+	for _, result := range results {
+		line := fmt.Sprintf("• %d `%s` %s", result.ProjectID, result.Branch, result.Outcome)
+		if result.Error != "" {
+			line += ": " + result.Error
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func (e *Executor) status(environment string) (string, error) {
+	for _, env := range e.git.GetEnvironments() {
+		if env.Name != environment {
+			continue
+		}
+
+		lines := []string{fmt.Sprintf("`%s`:", environment)}
+		if lock, ok := e.git.GetEnvironmentLock(environment); ok {
+			lines[0] += " " + formatLock(lock)
+		}
+		for _, project := range env.Projects {
+			if project == nil {
+				continue
+			}
+			line := fmt.Sprintf("• %s", project.Name)
+			if project.LastDeployment != nil {
+				line += fmt.Sprintf(" `%s`", project.LastDeployment.Ref)
+			}
+			if project.BehindBy != nil && *project.BehindBy > 0 {
+				line += fmt.Sprintf(" (%d commits behind)", *project.BehindBy)
+			}
+			if job, ok := e.git.GetJob(environment, project.ID); ok && job != nil {
+				line += fmt.Sprintf(" job: %s", job.Status)
+			}
+			lines = append(lines, line)
+		}
+
+		return strings.Join(lines, "\n"), nil
+	}
+
+	return "", gitlab.EnvironmentNotFound
+}
+
+func (e *Executor) lock(environment string, user *gitlab.ProjectUser, reason string) (string, error) {
+	lock, err := e.git.LockEnvironment(environment, user, reason)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("`%s` %s", environment, formatLock(lock)), nil
+}
+
+func (e *Executor) unlock(environment string) (string, error) {
+	err := e.git.UnlockEnvironment(environment)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("`%s` is unlocked", environment), nil
+}
+
+func formatLock(lock *gitlab.EnvironmentLock) string {
+	text := "is locked"
+	if lock.User != nil {
+		text += " by " + lock.User.Username
+	}
+	if lock.Reason != "" {
+		text += ": " + lock.Reason
+	}
+
+	return text
+}
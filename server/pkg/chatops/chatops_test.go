@@ -0,0 +1,145 @@
+package chatops
+
+import (
+	"context"
+	"gitlab-environment-dashboard/server/pkg/auth"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"gitlab-environment-dashboard/server/pkg/gitlab/gitlabtest"
+	"gitlab-environment-dashboard/server/pkg/session"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySlackSignature(t *testing.T) {
+	// The example from https://api.slack.com/authentication/verifying-requests-from-slack
+	secret := "8f742231b10e8888abcd99yyyzzz85a5"
+	timestamp := "1531420618"
+	body := []byte("token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c")
+	signature := "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+	now := time.Unix(1531420618, 0).Add(time.Minute)
+
+	tests := []struct {
+		name      string
+		signature string
+		now       time.Time
+		wantErr   bool
+	}{
+		{"valid", signature, now, false},
+		{"wrongSignature", "v0=00", now, true},
+		{"tooOld", signature, now.Add(time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySlackSignature(secret, timestamp, tt.signature, body, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifySlackSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name         string
+		slashCommand string
+		text         string
+		want         *Command
+		wantErr      bool
+	}{
+		{"deploy", "/deploy", "qa2 api feature/login", &Command{CommandDeploy, []string{"qa2", "api", "feature/login"}}, false},
+		{"generic", "/dashboard", "status qa2", &Command{CommandStatus, []string{"qa2"}}, false},
+		{"genericEmpty", "/dashboard", "", &Command{Name: CommandHelp}, false},
+		{"queryDeploy", "/dashboard", "query-deploy qa2 release-42 master", &Command{CommandQueryDeploy, []string{"qa2", "release-42", "master"}}, false},
+		{"lockWithReason", "/lock", "qa2 testing payments", &Command{CommandLock, []string{"qa2", "testing", "payments"}}, false},
+		{"deployWithoutRef", "/deploy", "qa2 api", nil, true},
+		{"unknown", "/dashboard", "destroy qa2", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCommand(tt.slashCommand, tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCommand() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecutorUser(t *testing.T) {
+	tests := []struct {
+		name         string
+		users        map[string]string
+		chatUserID   string
+		wantUsername string
+	}{
+		{"mapped", map[string]string{"U2CERLKJA": "jdoe"}, "U2CERLKJA", "jdoe"},
+		{"notMapped", map[string]string{"U2CERLKJA": "jdoe"}, "U024BE7LH", ""},
+		// A chat username could be changed to a mapped ID, but it's never sent as `user_id`
+		{"username", map[string]string{"U2CERLKJA": "jdoe"}, "jdoe", ""},
+		{"withoutMapping", map[string]string{}, "U2CERLKJA", ""},
+		{"emptyGitLabUser", map[string]string{"U2CERLKJA": ""}, "U2CERLKJA", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := NewExecutor(nil, tt.users, nil).User(tt.chatUserID)
+			if tt.wantUsername == "" {
+				if err != UnknownUser {
+					t.Errorf("User() = %+v, %v, want UnknownUser", user, err)
+				}
+				return
+			}
+			if err != nil || user.Username != tt.wantUsername {
+				t.Errorf("User() = %+v, %v, want %s", user, err, tt.wantUsername)
+			}
+		})
+	}
+}
+
+func TestExecuteChecksAccess(t *testing.T) {
+	server := gitlabtest.NewServer()
+	defer server.Close()
+	git, err := gitlab.NewClient("token", server.URL, nil, nil, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer git.Stop()
+	access := auth.NewAccessCache(func(_ context.Context, username string) (session.Access, error) {
+		if username == "contractor" {
+			return session.Access{Allowed: false, Reasons: []string{"not in allowed users"}}, nil
+		}
+		return session.Access{Allowed: true}, nil
+	}, time.Minute)
+	executor := NewExecutor(git, map[string]string{"U2CERLKJA": "jdoe", "U024BE7LH": "contractor"}, access)
+
+	tests := []struct {
+		name       string
+		username   string
+		command    *Command
+		wantLocked bool
+		wantText   string
+	}{
+		{"deniedLock", "contractor", &Command{Name: CommandLock, Args: []string{"qa2"}}, false, "user is not allowed to use the dashboard: not in allowed users"},
+		{"allowedLock", "jdoe", &Command{Name: CommandLock, Args: []string{"qa2", "testing"}}, true, "is locked by jdoe"},
+		{"deniedUnlock", "contractor", &Command{Name: CommandUnlock, Args: []string{"qa2"}}, true, "unlock failed: user is not allowed"},
+		{"deniedDeploy", "contractor", &Command{Name: CommandDeploy, Args: []string{"qa2", "api", "master"}}, true, "deploy failed: user is not allowed"},
+		{"deniedQueryDeploy", "contractor", &Command{Name: CommandQueryDeploy, Args: []string{"qa2", "release"}}, true, "query-deploy failed: user is not allowed"},
+		{"allowedUnlock", "jdoe", &Command{Name: CommandUnlock, Args: []string{"qa2"}}, false, "is unlocked"},
+		{"shortQuery", "jdoe", &Command{Name: CommandQueryDeploy, Args: []string{"qa2", "r"}}, false, "query-deploy failed: query is too short"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &gitlab.ProjectUser{Username: tt.username, Name: tt.username}
+			response := executor.Execute(context.Background(), tt.command, user)
+			if !strings.Contains(response.Text, tt.wantText) {
+				t.Errorf("Execute() = %q, want %q", response.Text, tt.wantText)
+			}
+			if _, locked := git.GetEnvironmentLock("qa2"); locked != tt.wantLocked {
+				t.Errorf("Execute() locked = %v, want %v", locked, tt.wantLocked)
+			}
+		})
+	}
+}
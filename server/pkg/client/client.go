@@ -0,0 +1,241 @@
+/*
+Package client calls the dashboard API v1 with an API token, it's used by envctl
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gitlab-environment-dashboard/server/pkg/dto"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"gitlab-environment-dashboard/server/pkg/openapi"
+	"gitlab-environment-dashboard/server/pkg/utils"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var finishedJobStatus = []string{
+	gitlab.JobStatusFailed,
+	gitlab.JobStatusSuccess,
+	gitlab.JobStatusCanceled,
+	gitlab.JobStatusSkipped,
+}
+
+// Error is an error response of the API
+type Error struct {
+	StatusCode int
+	Message    string `json:"error"`
+	// Code is a stable code of the error, i.e. `environment_locked`
+	Code    string                 `json:"code"`
+	Details map[string]interface{} `json:"details"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// PlayJobsRequest deploys matched branches of all projects of an environment
+type PlayJobsRequest struct {
+	Query string `json:"query"`
+	// Match is `prefix` by default
+	Match       string `json:"match,omitempty"`
+	FallbackRef string `json:"fallbackRef,omitempty"`
+}
+
+// QueryDeploy is an outcome of a query deployment
+// Error and Code are set when some or all projects weren't deployed
+type QueryDeploy struct {
+	Error    string                   `json:"error"`
+	Code     string                   `json:"code"`
+	Projects []*dto.QueryDeployResult `json:"projects"`
+}
+
+// Failed returns results of projects which failed with an unexpected error
+func (q *QueryDeploy) Failed() []*dto.QueryDeployResult {
+	var failed []*dto.QueryDeployResult
+	for _, result := range q.Projects {
+		if result.Outcome == gitlab.QueryDeployError {
+			failed = append(failed, result)
+		}
+	}
+
+	return failed
+}
+
+// Client calls the API of a dashboard
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a client of the dashboard at baseURL, i.e. `https://dashboard.example.com`
+func NewClient(baseURL string, token string, httpClient *http.Client) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + openapi.Prefix,
+		token:      token,
+		httpClient: httpClient,
+	}
+}
+
+// Environments returns all environments with their projects
+func (c *Client) Environments(ctx context.Context) ([]*dto.Environment, error) {
+	response := struct {
+		Environments []*dto.Environment `json:"environments"`
+	}{}
+	err := c.do(ctx, "GET", "/environments", nil, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Environments, nil
+}
+
+// Environment returns the environment by its name
+func (c *Client) Environment(ctx context.Context, name string) (*dto.Environment, error) {
+	environments, err := c.Environments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, environment := range environments {
+		if environment.Name == name {
+			return environment, nil
+		}
+	}
+
+	return nil, fmt.Errorf("environment %s not found", name)
+}
+
+// Jobs returns the last jobs started by the dashboard, by environment and project ID
+func (c *Client) Jobs(ctx context.Context) (map[string]map[int]*dto.Job, error) {
+	response := struct {
+		Jobs map[string]map[int]*dto.Job `json:"jobs"`
+	}{}
+	err := c.do(ctx, "GET", "/jobs", nil, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Jobs, nil
+}
+
+// Job returns the last job of the project in the environment, it's nil when nothing was started
+func (c *Client) Job(ctx context.Context, environment string, projectID int) (*dto.Job, error) {
+	response := struct {
+		Job *dto.Job `json:"job"`
+	}{}
+	err := c.do(ctx, "GET", projectPath(environment, projectID)+"/jobs", nil, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Job, nil
+}
+
+// PlayJob plays or retries the deploy job of the ref
+func (c *Client) PlayJob(ctx context.Context, environment string, projectID int, ref string) (*dto.Job, error) {
+	response := struct {
+		Job *dto.Job `json:"job"`
+	}{}
+	err := c.do(ctx, "POST", projectPath(environment, projectID)+"/jobs", map[string]string{"ref": ref}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Job, nil
+}
+
+// PlayJobsWithQuery deploys matched branches of all projects
+// Results are returned with the error when nothing was deployed
+func (c *Client) PlayJobsWithQuery(ctx context.Context, environment string, request PlayJobsRequest) (*QueryDeploy, error) {
+	response := &QueryDeploy{}
+	err := c.do(ctx, "POST", "/environments/"+url.PathEscape(environment)+"/jobs", request, response)
+
+	return response, err
+}
+
+// WaitForJob polls the job until it's finished
+// The dashboard tracks jobs it started, so the job is followed until another one replaces it
+func (c *Client) WaitForJob(ctx context.Context, environment string, projectID int, jobID int, interval time.Duration) (*dto.Job, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		job, err := c.Job(ctx, environment, projectID)
+		if err != nil {
+			return nil, err
+		}
+		if job == nil || job.ID != jobID {
+			return nil, fmt.Errorf("job %d of project %d isn't tracked by the dashboard anymore", jobID, projectID)
+		}
+		if IsJobFinished(job) {
+			return job, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// IsJobFinished checks that the job won't change its status anymore
+func IsJobFinished(job *dto.Job) bool {
+	return utils.StringsContainString(finishedJobStatus, job.Status)
+}
+
+func projectPath(environment string, projectID int) string {
+	return "/environments/" + url.PathEscape(environment) + "/projects/" + strconv.Itoa(projectID)
+}
+
+// do sends the request and decodes the response into result
+// Bodies of error responses are decoded into result too, because some of them have results (i.e. 422 of query deployment)
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	var requestBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		requestBody = bytes.NewReader(data)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, requestBody)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+c.token)
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode >= 300 {
+		apiErr := &Error{StatusCode: response.StatusCode}
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		_ = json.Unmarshal(data, result)
+		return apiErr
+	}
+
+	return json.Unmarshal(data, result)
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPlayJob(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		wantJob  int
+		wantCode string
+	}{
+		{"played", 200, `{"job":{"id":7,"status":"pending"}}`, 7, ""},
+		{"locked", 409, `{"error":"cannot create job: environment is locked","code":"environment_locked"}`, 0, "environment_locked"},
+		{"notJSON", 502, `Bad Gateway`, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := map[string]string{}
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				if r.Method != "POST" || r.URL.Path != "/api/v1/environments/qa2/projects/42/jobs" ||
+					r.Header.Get("Authorization") != "Bearer gld_secret" || body["ref"] != "feature/x" {
+					t.Errorf("unexpected request %s %s %v", r.Method, r.URL.Path, body)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			job, err := NewClient(server.URL+"/", "gld_secret", server.Client()).PlayJob(context.Background(), "qa2", 42, "feature/x")
+			if tt.status != 200 {
+				var apiErr *Error
+				if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status || apiErr.Code != tt.wantCode || apiErr.Message == "" {
+					t.Fatalf("PlayJob() error = %v, want %d %s", err, tt.status, tt.wantCode)
+				}
+				return
+			}
+			if err != nil || job == nil || job.ID != tt.wantJob {
+				t.Errorf("PlayJob() = %+v, %v, want job %d", job, err, tt.wantJob)
+			}
+		})
+	}
+}
+
+func TestPlayJobsWithQuery(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		response     string
+		wantProjects int
+		wantFailed   int
+		wantErr      bool
+	}{
+		{"deployed", 200, `{"projects":[{"projectId":1,"outcome":"played","job":{"id":7}}]}`, 1, 0, false},
+		{"partially", 207, `{"error":"some jobs cannot be started","code":"partially_deployed","projects":[{"projectId":1,"outcome":"played"},{"projectId":2,"outcome":"error","error":"forbidden"}]}`, 2, 1, false},
+		{"nothing", 422, `{"error":"nothing was run","code":"nothing_deployed","projects":[{"projectId":1,"outcome":"skipped-no-branch"}]}`, 1, 0, true},
+		{"invalid", 422, `{"error":"cannot start jobs: wrong regex","code":"invalid_argument"}`, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			result, err := NewClient(server.URL, "gld_secret", server.Client()).PlayJobsWithQuery(context.Background(), "qa2", PlayJobsRequest{Query: "release-42"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PlayJobsWithQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(result.Projects) != tt.wantProjects || len(result.Failed()) != tt.wantFailed {
+				t.Errorf("PlayJobsWithQuery() = %d projects, %d failed, want %d, %d", len(result.Projects), len(result.Failed()), tt.wantProjects, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestWaitForJob(t *testing.T) {
+	tests := []struct {
+		name       string
+		responses  []string
+		wantStatus string
+		wantErr    bool
+	}{
+		{"finished", []string{`{"job":{"id":7,"status":"pending"}}`, `{"job":{"id":7,"status":"running"}}`, `{"job":{"id":7,"status":"failed"}}`}, "failed", false},
+		{"replaced", []string{`{"job":{"id":7,"status":"running"}}`, `{"job":{"id":8,"status":"pending"}}`}, "", true},
+		{"forgotten", []string{`{"job":null}`}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				response := tt.responses[len(tt.responses)-1]
+				if requests < len(tt.responses) {
+					response = tt.responses[requests]
+				}
+				requests++
+				_, _ = w.Write([]byte(response))
+			}))
+			defer server.Close()
+
+			job, err := NewClient(server.URL, "gld_secret", server.Client()).WaitForJob(context.Background(), "qa2", 42, 7, time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WaitForJob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && job.Status != tt.wantStatus {
+				t.Errorf("WaitForJob() status = %s, want %s", job.Status, tt.wantStatus)
+			}
+		})
+	}
+}
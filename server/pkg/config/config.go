@@ -25,6 +25,58 @@ type Config struct {
 	CookieSecured         bool
 	SslEnabled            bool
 	OAuthEnabled          bool
+	// NotificationsConfigFile is a JSON file with outgoing webhooks, notifications are disabled if empty
+	NotificationsConfigFile string
+	// Slash commands are enabled when ChatOpsSigningSecret or ChatOpsToken is set
+	ChatOpsSigningSecret string
+	ChatOpsToken         string
+	// ChatOpsUsers maps chat user IDs to GitLab usernames
+	ChatOpsUsers map[string]string
+	// TracingExporter is "otlp" or "stdout", tracing is disabled if empty
+	TracingExporter    string
+	TracingServiceName string
+	// GitLabRequestTimeout is a deadline of every GitLab API request
+	GitLabRequestTimeout time.Duration
+	// RequestTimeout is a deadline of every dashboard request, it should be less than the server write timeout
+	RequestTimeout time.Duration
+	// OpenAPIValidateResponses logs API responses which don't match the OpenAPI specification
+	OpenAPIValidateResponses bool
+	// GitLab requests are not sent for GitLabBreakerCooldown after GitLabBreakerThreshold failures in a row
+	GitLabBreakerThreshold int
+	GitLabBreakerCooldown  time.Duration
+	// The dashboard isn't ready when caches weren't refreshed for ReadinessMaxRefreshAge
+	ReadinessMaxRefreshAge time.Duration
+	// SessionSecret signs session IDs, a random secret is used if it's empty
+	SessionSecret string
+	// Sessions expire SessionTTL after the login or SessionIdleTimeout after the last request
+	SessionTTL         time.Duration
+	SessionIdleTimeout time.Duration
+	// Users of sessions are checked in GitLab every SessionRevalidateInterval
+	SessionRevalidateInterval time.Duration
+	// AdminUsers are GitLab usernames which could manage sessions
+	AdminUsers []string
+	// Only AllowedUsers and members of AllowedGroups could use the dashboard, everyone could if both are empty
+	AllowedUsers  []string
+	AllowedGroups []string
+	// APITokensFile keeps hashes of API tokens, tokens are only kept in memory if it's empty
+	APITokensFile string
+	// SnapshotsFile keeps environment snapshots, snapshots are only kept in memory if it's empty
+	SnapshotsFile string
+	// AuthProvider is "gitlab", "oidc" or "headers"
+	AuthProvider string
+	// OpenID Connect provider is discovered by OIDCIssuer
+	OIDCIssuer        string
+	OIDCClientID      string
+	OIDCClientSecret  string
+	OIDCScopes        string
+	OIDCUsernameClaim string
+	// Headers of the authenticating proxy, the proxy is trusted by AuthProxySecret or AuthProxyTrustedNetworks
+	AuthHeaderUser           string
+	AuthHeaderName           string
+	AuthHeaderAvatar         string
+	AuthProxySecretHeader    string
+	AuthProxySecret          string
+	AuthProxyTrustedNetworks []string
 }
 
 // CreateConfig creates the application configuration
@@ -45,6 +97,50 @@ func CreateConfig() Config {
 	config.CookieSecured = os.Getenv("COOKIE_SECURED") == "1"
 	config.SslEnabled = os.Getenv("SSL_ENABLED") == "1"
 	config.OAuthEnabled = os.Getenv("OAUTH_ENABLED") == "1"
+	config.OpenAPIValidateResponses = os.Getenv("OPENAPI_VALIDATE_RESPONSES") == "1"
+	config.NotificationsConfigFile = os.Getenv("NOTIFICATIONS_CONFIG_FILE")
+	config.ChatOpsSigningSecret = os.Getenv("CHATOPS_SIGNING_SECRET")
+	config.ChatOpsToken = os.Getenv("CHATOPS_TOKEN")
+	config.SessionSecret = os.Getenv("SESSION_SECRET")
+	config.APITokensFile = os.Getenv("API_TOKENS_FILE")
+	config.SnapshotsFile = os.Getenv("SNAPSHOTS_FILE")
+	config.AuthProvider = os.Getenv("AUTH_PROVIDER")
+	if config.AuthProvider == "" {
+		config.AuthProvider = "gitlab"
+	}
+	if config.AuthProvider != "gitlab" && config.AuthProvider != "oidc" && config.AuthProvider != "headers" {
+		log.Fatalf("AUTH_PROVIDER should be gitlab, oidc or headers. %s given", config.AuthProvider)
+	}
+	config.OIDCIssuer = os.Getenv("OIDC_ISSUER")
+	config.OIDCClientID = os.Getenv("OIDC_CLIENT_ID")
+	config.OIDCClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	config.OIDCScopes = os.Getenv("OIDC_SCOPES")
+	if config.OIDCScopes == "" {
+		config.OIDCScopes = "openid profile email"
+	}
+	config.OIDCUsernameClaim = os.Getenv("OIDC_USERNAME_CLAIM")
+	if config.OIDCUsernameClaim == "" {
+		config.OIDCUsernameClaim = "preferred_username"
+	}
+	config.AuthHeaderUser = os.Getenv("AUTH_HEADER_USER")
+	if config.AuthHeaderUser == "" {
+		config.AuthHeaderUser = "X-Forwarded-User"
+	}
+	config.AuthHeaderName = os.Getenv("AUTH_HEADER_NAME")
+	config.AuthHeaderAvatar = os.Getenv("AUTH_HEADER_AVATAR")
+	config.AuthProxySecretHeader = os.Getenv("AUTH_PROXY_SECRET_HEADER")
+	if config.AuthProxySecretHeader == "" {
+		config.AuthProxySecretHeader = "X-Auth-Proxy-Secret"
+	}
+	config.AuthProxySecret = os.Getenv("AUTH_PROXY_SECRET")
+	if os.Getenv("AUTH_PROXY_TRUSTED_NETWORKS") != "" {
+		config.AuthProxyTrustedNetworks = strings.Split(os.Getenv("AUTH_PROXY_TRUSTED_NETWORKS"), ",")
+	}
+	config.TracingExporter = os.Getenv("TRACING_EXPORTER")
+	config.TracingServiceName = os.Getenv("TRACING_SERVICE_NAME")
+	if config.TracingServiceName == "" {
+		config.TracingServiceName = "gitlab-dashboard"
+	}
 
 	if os.Getenv("GITLAB_PROJECT_IDS") == "" {
 		log.Fatalln("GITLAB_PROJECT_IDS should have at least one ID")
@@ -75,12 +171,61 @@ func CreateConfig() Config {
 		config.UpdateDuration = updateEach
 	}
 
+	config.GitLabRequestTimeout = parseDuration("GITLAB_REQUEST_TIMEOUT", 10*time.Second)
+	config.RequestTimeout = parseDuration("REQUEST_TIMEOUT", 14*time.Second)
+	config.GitLabBreakerCooldown = parseDuration("GITLAB_BREAKER_COOLDOWN", 30*time.Second)
+	config.ReadinessMaxRefreshAge = parseDuration("READINESS_MAX_REFRESH_AGE", 3*config.UpdateDuration)
+	config.SessionTTL = parseDuration("SESSION_TTL", 7*24*time.Hour)
+	config.SessionIdleTimeout = parseDuration("SESSION_IDLE_TIMEOUT", 24*time.Hour)
+	config.SessionRevalidateInterval = parseDuration("SESSION_REVALIDATE_INTERVAL", 5*time.Minute)
+	config.GitLabBreakerThreshold = 5
+	if os.Getenv("GITLAB_BREAKER_THRESHOLD") != "" {
+		config.GitLabBreakerThreshold, err = strconv.Atoi(os.Getenv("GITLAB_BREAKER_THRESHOLD"))
+		if err != nil || config.GitLabBreakerThreshold < 1 {
+			log.Fatalf("GITLAB_BREAKER_THRESHOLD should be a positive integer. %s given", os.Getenv("GITLAB_BREAKER_THRESHOLD"))
+		}
+	}
+
 	// Set default public DIR
 	if config.PublicDir == "/" {
 		config.PublicDir = "/public"
 	}
 
 	config.ProtectedEnvironments = strings.Split(os.Getenv("PROTECTED_ENVIRONMENTS"), ",")
+	if os.Getenv("ADMIN_USERS") != "" {
+		config.AdminUsers = strings.Split(os.Getenv("ADMIN_USERS"), ",")
+	}
+	if os.Getenv("ALLOWED_USERS") != "" {
+		config.AllowedUsers = strings.Split(os.Getenv("ALLOWED_USERS"), ",")
+	}
+	if os.Getenv("ALLOWED_GROUPS") != "" {
+		config.AllowedGroups = strings.Split(os.Getenv("ALLOWED_GROUPS"), ",")
+	}
+
+	config.ChatOpsUsers = map[string]string{}
+	if os.Getenv("CHATOPS_USERS") != "" {
+		for _, pair := range strings.Split(os.Getenv("CHATOPS_USERS"), ",") {
+			users := strings.SplitN(pair, ":", 2)
+			if len(users) != 2 {
+				log.Fatalf("CHATOPS_USERS should have chat:gitlab pairs. %s given", pair)
+			}
+			config.ChatOpsUsers[users[0]] = users[1]
+		}
+	}
 
 	return config
 }
+
+// parseDuration reads a duration from the env variable or returns the default one
+func parseDuration(name string, defaultDuration time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultDuration
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s has wrong duration format: %v", name, err)
+	}
+
+	return duration
+}
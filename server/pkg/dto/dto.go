@@ -0,0 +1,34 @@
+/*
+Package dto has data transfer objects of the dashboard API v1
+
+Handlers don't respond with models of go-gitlab, they change with GitLab and use
+snake_case. Models are converted to DTOs which are described by the OpenAPI
+specification of pkg/openapi, a DTO should be changed together with its schema.
+*/
+package dto
+
+import (
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+)
+
+// User is a GitLab user or a user of the dashboard
+type User struct {
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatarURL"`
+	// Token is a name of the API token which the user acted with
+	Token string `json:"token,omitempty"`
+}
+
+// NewUser converts a user of the service
+func NewUser(user *gitlab.ProjectUser) *User {
+	if user == nil {
+		return nil
+	}
+	return &User{
+		Username:  user.Username,
+		Name:      user.Name,
+		AvatarURL: user.AvatarURL,
+		Token:     user.Token,
+	}
+}
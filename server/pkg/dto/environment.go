@@ -0,0 +1,159 @@
+package dto
+
+import (
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"time"
+)
+
+// Environment is a GitLab environment with projects deployed to it
+type Environment struct {
+	Name     string     `json:"name"`
+	Projects []*Project `json:"projects"`
+}
+
+// Project is a project of an environment
+type Project struct {
+	ID                int         `json:"id"`
+	Name              string      `json:"name"`
+	NameWithNamespace string      `json:"nameWithNamespace"`
+	AvatarURL         string      `json:"avatarURL"`
+	WebURL            string      `json:"webURL"`
+	LastDeployment    *Deployment `json:"lastDeployment"`
+	// BehindBy is a number of commits of the default branch which aren't deployed
+	BehindBy *int `json:"behindBy"`
+}
+
+// Deployment is a deployment of a project to an environment
+type Deployment struct {
+	ID         int         `json:"id"`
+	Ref        string      `json:"ref"`
+	SHA        string      `json:"sha"`
+	User       *User       `json:"user"`
+	UpdatedAt  *time.Time  `json:"updatedAt"`
+	Deployable *Deployable `json:"deployable"`
+}
+
+// Deployable is a job of a deployment
+type Deployable struct {
+	Name string `json:"name"`
+	// Duration is in seconds
+	Duration float64  `json:"duration"`
+	Pipeline Pipeline `json:"pipeline"`
+}
+
+// SnapshotRestore tracks progress of a snapshot restore
+type SnapshotRestore struct {
+	Snapshot    string                    `json:"snapshot"`
+	Environment string                    `json:"environment"`
+	User        *User                     `json:"user"`
+	StartedAt   time.Time                 `json:"startedAt"`
+	Finished    bool                      `json:"finished"`
+	Error       string                    `json:"error,omitempty"`
+	Projects    []*SnapshotRestoreProject `json:"projects"`
+}
+
+// SnapshotRestoreProject tracks progress of a project restore
+type SnapshotRestoreProject struct {
+	gitlab.SnapshotProject
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Job    *Job   `json:"job,omitempty"`
+}
+
+// NewEnvironments converts environments of the service
+func NewEnvironments(environments []*gitlab.Environment) []*Environment {
+	result := make([]*Environment, 0, len(environments))
+	for _, environment := range environments {
+		projects := make([]*Project, 0, len(environment.Projects))
+		for _, project := range environment.Projects {
+			projects = append(projects, newProject(project))
+		}
+		result = append(result, &Environment{Name: environment.Name, Projects: projects})
+	}
+
+	return result
+}
+
+func newProject(project *gitlab.Project) *Project {
+	if project == nil {
+		return nil
+	}
+	return &Project{
+		ID:                project.ID,
+		Name:              project.Name,
+		NameWithNamespace: project.NameWithNamespace,
+		AvatarURL:         project.AvatarURL,
+		WebURL:            project.WebURL,
+		LastDeployment:    NewDeployment(project.LastDeployment),
+		BehindBy:          project.BehindBy,
+	}
+}
+
+// NewDeployment converts a deployment of the service
+func NewDeployment(deployment *gitlab.Deployment) *Deployment {
+	if deployment == nil {
+		return nil
+	}
+	result := &Deployment{
+		ID:        deployment.ID,
+		Ref:       deployment.Ref,
+		SHA:       deployment.SHA,
+		User:      NewUser(deployment.User),
+		UpdatedAt: deployment.UpdatedAt,
+	}
+	if deployment.Deployable != nil {
+		result.Deployable = &Deployable{
+			Name:     deployment.Deployable.Name,
+			Duration: deployment.Deployable.Duration,
+			Pipeline: Pipeline{
+				ID:     deployment.Deployable.Pipeline.ID,
+				Status: deployment.Deployable.Pipeline.Status,
+			},
+		}
+		if user := deployment.Deployable.Pipeline.User; user != nil && user.Username != "" {
+			result.Deployable.Pipeline.User = &User{
+				Username:  user.Username,
+				Name:      user.Name,
+				AvatarURL: user.AvatarURL,
+			}
+		}
+	}
+
+	return result
+}
+
+// NewDeployments converts deployments of the service
+func NewDeployments(deployments []*gitlab.Deployment) []*Deployment {
+	result := make([]*Deployment, 0, len(deployments))
+	for _, deployment := range deployments {
+		result = append(result, NewDeployment(deployment))
+	}
+
+	return result
+}
+
+// NewSnapshotRestore converts a restore of the service
+func NewSnapshotRestore(restore *gitlab.SnapshotRestore) *SnapshotRestore {
+	if restore == nil {
+		return nil
+	}
+	projects := make([]*SnapshotRestoreProject, 0, len(restore.Projects))
+	for _, project := range restore.Projects {
+		projects = append(projects, &SnapshotRestoreProject{
+			SnapshotProject: project.SnapshotProject,
+			Status:          project.Status,
+			Error:           project.Error,
+			Job:             NewJob(project.Job),
+		})
+	}
+
+	return &SnapshotRestore{
+		Snapshot:    restore.Snapshot,
+		Environment: restore.Environment,
+		User:        NewUser(restore.User),
+		StartedAt:   restore.StartedAt,
+		Finished:    restore.Finished,
+		Error:       restore.Error,
+		Projects:    projects,
+	}
+}
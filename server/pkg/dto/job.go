@@ -0,0 +1,198 @@
+package dto
+
+import (
+	wrappedGitlab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"time"
+)
+
+// Job is a deploy job of a project
+type Job struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Stage      string     `json:"stage"`
+	Status     string     `json:"status"`
+	Ref        string     `json:"ref"`
+	Tag        bool       `json:"tag"`
+	WebURL     string     `json:"webURL"`
+	CreatedAt  *time.Time `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+	// Duration is in seconds
+	Duration float64  `json:"duration"`
+	Pipeline Pipeline `json:"pipeline"`
+	Commit   *Commit  `json:"commit"`
+	User     *User    `json:"user"`
+}
+
+// Pipeline is a pipeline of a job or a deployment
+type Pipeline struct {
+	ID     int    `json:"id"`
+	Ref    string `json:"ref,omitempty"`
+	SHA    string `json:"sha,omitempty"`
+	Status string `json:"status"`
+	User   *User  `json:"user,omitempty"`
+}
+
+// Commit is a commit of a branch or a job
+type Commit struct {
+	ID          string     `json:"id"`
+	ShortID     string     `json:"shortId"`
+	Title       string     `json:"title"`
+	Message     string     `json:"message"`
+	AuthorName  string     `json:"authorName"`
+	AuthorEmail string     `json:"authorEmail"`
+	CreatedAt   *time.Time `json:"createdAt"`
+	WebURL      string     `json:"webURL"`
+}
+
+// Branch is a branch which could be deployed
+type Branch struct {
+	Name      string  `json:"name"`
+	Protected bool    `json:"protected"`
+	Merged    bool    `json:"merged"`
+	Default   bool    `json:"default"`
+	WebURL    string  `json:"webURL"`
+	Commit    *Commit `json:"commit"`
+}
+
+// QueryDeployResult is a result of a query deployment for a project
+type QueryDeployResult struct {
+	ProjectID int    `json:"projectId"`
+	Branch    string `json:"branch,omitempty"`
+	Fallback  bool   `json:"fallback,omitempty"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error,omitempty"`
+	Job       *Job   `json:"job,omitempty"`
+}
+
+// JobCancelResult is a result of a job cancellation for a project
+type JobCancelResult struct {
+	ProjectID int    `json:"projectId"`
+	Job       *Job   `json:"job,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NewJob converts a job of go-gitlab
+func NewJob(job *wrappedGitlab.Job) *Job {
+	if job == nil {
+		return nil
+	}
+	result := &Job{
+		ID:         job.ID,
+		Name:       job.Name,
+		Stage:      job.Stage,
+		Status:     job.Status,
+		Ref:        job.Ref,
+		Tag:        job.Tag,
+		WebURL:     job.WebURL,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+		Duration:   job.Duration,
+		Pipeline: Pipeline{
+			ID:     job.Pipeline.ID,
+			Ref:    job.Pipeline.Ref,
+			SHA:    job.Pipeline.Sha,
+			Status: job.Pipeline.Status,
+		},
+		Commit: NewCommit(job.Commit),
+	}
+	if job.User != nil {
+		result.User = &User{
+			Username:  job.User.Username,
+			Name:      job.User.Name,
+			AvatarURL: job.User.AvatarURL,
+		}
+	}
+
+	return result
+}
+
+// NewJobs converts jobs of projects in environments
+func NewJobs(jobs map[string]map[int]*wrappedGitlab.Job) map[string]map[int]*Job {
+	result := make(map[string]map[int]*Job, len(jobs))
+	for environment, projectJobs := range jobs {
+		result[environment] = make(map[int]*Job, len(projectJobs))
+		for projectID, job := range projectJobs {
+			result[environment][projectID] = NewJob(job)
+		}
+	}
+
+	return result
+}
+
+// NewCommit converts a commit of go-gitlab
+func NewCommit(commit *wrappedGitlab.Commit) *Commit {
+	if commit == nil {
+		return nil
+	}
+	return &Commit{
+		ID:          commit.ID,
+		ShortID:     commit.ShortID,
+		Title:       commit.Title,
+		Message:     commit.Message,
+		AuthorName:  commit.AuthorName,
+		AuthorEmail: commit.AuthorEmail,
+		CreatedAt:   commit.CreatedAt,
+		WebURL:      commit.WebURL,
+	}
+}
+
+// NewCommits converts commits of go-gitlab
+func NewCommits(commits []*wrappedGitlab.Commit) []*Commit {
+	result := make([]*Commit, 0, len(commits))
+	for _, commit := range commits {
+		result = append(result, NewCommit(commit))
+	}
+
+	return result
+}
+
+// NewBranches converts branches of go-gitlab
+func NewBranches(branches []*wrappedGitlab.Branch) []*Branch {
+	result := make([]*Branch, 0, len(branches))
+	for _, branch := range branches {
+		result = append(result, &Branch{
+			Name:      branch.Name,
+			Protected: branch.Protected,
+			Merged:    branch.Merged,
+			Default:   branch.Default,
+			WebURL:    branch.WebURL,
+			Commit:    NewCommit(branch.Commit),
+		})
+	}
+
+	return result
+}
+
+// NewQueryDeployResults converts results of gitlab.Service.PlayOrRetryJobsWithQuery
+func NewQueryDeployResults(results gitlab.QueryDeployResults) []*QueryDeployResult {
+	converted := make([]*QueryDeployResult, 0, len(results))
+	for _, result := range results {
+		converted = append(converted, &QueryDeployResult{
+			ProjectID: result.ProjectID,
+			Branch:    result.Branch,
+			Fallback:  result.Fallback,
+			Outcome:   result.Outcome,
+			Error:     result.Error,
+			Job:       NewJob(result.Job),
+		})
+	}
+
+	return converted
+}
+
+// NewJobCancelResults converts results of gitlab.Service.CancelJobs
+func NewJobCancelResults(results []*gitlab.JobCancelResult) []*JobCancelResult {
+	converted := make([]*JobCancelResult, 0, len(results))
+	for _, result := range results {
+		converted = append(converted, &JobCancelResult{
+			ProjectID: result.ProjectID,
+			Job:       NewJob(result.Job),
+			Error:     result.Error,
+		})
+	}
+
+	return converted
+}
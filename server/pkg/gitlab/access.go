@@ -0,0 +1,90 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/session"
+	"gitlab-environment-dashboard/server/pkg/utils"
+	"net/http"
+	"strings"
+)
+
+// AccessPolicy allows only users from allow-lists to use the dashboard
+// Everyone is allowed if both lists are empty
+type AccessPolicy struct {
+	service *Service
+	users   []string
+	groups  []string
+}
+
+// NewAccessPolicy creates a policy of usernames and full paths of groups (i.e. `platform/backend`)
+// Group members are checked by the token of the service, so it should see the groups
+func NewAccessPolicy(service *Service, users []string, groups []string) *AccessPolicy {
+	return &AccessPolicy{
+		service: service,
+		users:   users,
+		groups:  groups,
+	}
+}
+
+// Check decides if the user is allowed
+// Members of subgroups are allowed too because inherited members are included
+func (p *AccessPolicy) Check(ctx context.Context, username string) (session.Access, error) {
+	if len(p.users) == 0 && len(p.groups) == 0 {
+		return session.Access{Allowed: true, Reasons: []string{"allow-lists are not configured"}}, nil
+	}
+	if utils.StringsContainString(p.users, username) {
+		return session.Access{Allowed: true, Reasons: []string{"user is allowed"}}, nil
+	}
+
+	ctx, span := startSpan(ctx, "AccessPolicy.Check")
+	defer span.End()
+
+	var reasons []string
+	for _, group := range p.groups {
+		isMember, err := p.isMember(ctx, group, username)
+		if err != nil {
+			return session.Access{}, err
+		}
+		if isMember {
+			return session.Access{Allowed: true, Reasons: []string{fmt.Sprintf("member of %s", group)}}, nil
+		}
+	}
+
+	if len(p.groups) > 0 {
+		reasons = append(reasons, fmt.Sprintf("not a member of allowed groups: %s", strings.Join(p.groups, ", ")))
+	}
+	if len(p.users) > 0 {
+		reasons = append(reasons, "not in allowed users")
+	}
+
+	return session.Access{Allowed: false, Reasons: reasons}, nil
+}
+
+func (p *AccessPolicy) isMember(ctx context.Context, group string, username string) (bool, error) {
+	members, resp, err := p.service.git.Groups.ListAllGroupMembers(
+		group,
+		&wrappedGitLab.ListGroupMembersOptions{
+			ListOptions: wrappedGitLab.ListOptions{PerPage: 100},
+			Query:       &username,
+		},
+		wrappedGitLab.WithContext(ctx),
+	)
+	if err != nil {
+		// The group is removed or the token doesn't see it, nobody is a member
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	// The query matches names and usernames partially
+	for _, member := range members {
+		if member.Username == username && member.State != "blocked" {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,51 @@
+package gitlab
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestAccessPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		users       []string
+		groups      []string
+		username    string
+		wantAllowed bool
+		wantReasons []string
+	}{
+		{"withoutLists", nil, nil, "jdoe", true, []string{"allow-lists are not configured"}},
+		{"allowedUser", []string{"jdoe"}, []string{"platform"}, "jdoe", true, []string{"user is allowed"}},
+		{"member", nil, []string{"other", "platform/backend"}, "jdoe", true, []string{"member of platform/backend"}},
+		{"partialUsername", nil, []string{"platform/backend"}, "jdo", false, []string{"not a member of allowed groups: platform/backend"}},
+		{"blocked", nil, []string{"platform/backend"}, "blocked", false, []string{"not a member of allowed groups: platform/backend"}},
+		{"unknownGroup", []string{"admin"}, []string{"unknown"}, "jdoe", false, []string{"not a member of allowed groups: unknown", "not in allowed users"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, server := newTestService(t)
+			server.AddGroupMember("other", "contractor", "active")
+			server.AddGroupMember("platform/backend", "jdoe", "active")
+			server.AddGroupMember("platform/backend", "blocked", "blocked")
+
+			access, err := NewAccessPolicy(service, tt.users, tt.groups).Check(context.Background(), tt.username)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if access.Allowed != tt.wantAllowed || !reflect.DeepEqual(access.Reasons, tt.wantReasons) {
+				t.Errorf("Check() = %+v, want allowed %v with %v", access, tt.wantAllowed, tt.wantReasons)
+			}
+		})
+	}
+}
+
+func TestAccessPolicyCheckFails(t *testing.T) {
+	service, server := newTestService(t)
+	server.RevokeToken()
+
+	_, err := NewAccessPolicy(service, nil, []string{"platform"}).Check(context.Background(), "jdoe")
+	if err == nil {
+		t.Error("Check() error = nil, want an error when GitLab rejects the token of the dashboard")
+	}
+}
@@ -0,0 +1,72 @@
+package gitlab
+
+import (
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"io"
+)
+
+// API is a part of GitLab API which the Service uses
+// Services of *wrappedGitLab.Client implement all interfaces
+type API struct {
+	Branches     BranchesAPI
+	Deployments  DeploymentsAPI
+	Environments EnvironmentsAPI
+	Groups       GroupsAPI
+	Jobs         JobsAPI
+	Pipelines    PipelinesAPI
+	Repositories RepositoriesAPI
+	Users        UsersAPI
+}
+
+// NewAPI takes services of the client
+func NewAPI(client *wrappedGitLab.Client) *API {
+	return &API{
+		Branches:     client.Branches,
+		Deployments:  client.Deployments,
+		Environments: client.Environments,
+		Groups:       client.Groups,
+		Jobs:         client.Jobs,
+		Pipelines:    client.Pipelines,
+		Repositories: client.Repositories,
+		Users:        client.Users,
+	}
+}
+
+type BranchesAPI interface {
+	ListBranches(pid interface{}, opts *wrappedGitLab.ListBranchesOptions, options ...wrappedGitLab.RequestOptionFunc) ([]*wrappedGitLab.Branch, *wrappedGitLab.Response, error)
+}
+
+type DeploymentsAPI interface {
+	ListProjectDeployments(pid interface{}, opts *wrappedGitLab.ListProjectDeploymentsOptions, options ...wrappedGitLab.RequestOptionFunc) ([]*wrappedGitLab.Deployment, *wrappedGitLab.Response, error)
+	GetProjectDeployment(pid interface{}, deployment int, options ...wrappedGitLab.RequestOptionFunc) (*wrappedGitLab.Deployment, *wrappedGitLab.Response, error)
+}
+
+type EnvironmentsAPI interface {
+	ListEnvironments(pid interface{}, opts *wrappedGitLab.ListEnvironmentsOptions, options ...wrappedGitLab.RequestOptionFunc) ([]*wrappedGitLab.Environment, *wrappedGitLab.Response, error)
+	GetEnvironment(pid interface{}, environment int, options ...wrappedGitLab.RequestOptionFunc) (*wrappedGitLab.Environment, *wrappedGitLab.Response, error)
+}
+
+type GroupsAPI interface {
+	ListAllGroupMembers(gid interface{}, opt *wrappedGitLab.ListGroupMembersOptions, options ...wrappedGitLab.RequestOptionFunc) ([]*wrappedGitLab.GroupMember, *wrappedGitLab.Response, error)
+}
+
+type JobsAPI interface {
+	ListPipelineJobs(pid interface{}, pipelineID int, opts *wrappedGitLab.ListJobsOptions, options ...wrappedGitLab.RequestOptionFunc) ([]*wrappedGitLab.Job, *wrappedGitLab.Response, error)
+	GetJob(pid interface{}, jobID int, options ...wrappedGitLab.RequestOptionFunc) (*wrappedGitLab.Job, *wrappedGitLab.Response, error)
+	GetTraceFile(pid interface{}, jobID int, options ...wrappedGitLab.RequestOptionFunc) (io.Reader, *wrappedGitLab.Response, error)
+	PlayJob(pid interface{}, jobID int, options ...wrappedGitLab.RequestOptionFunc) (*wrappedGitLab.Job, *wrappedGitLab.Response, error)
+	RetryJob(pid interface{}, jobID int, options ...wrappedGitLab.RequestOptionFunc) (*wrappedGitLab.Job, *wrappedGitLab.Response, error)
+	CancelJob(pid interface{}, jobID int, options ...wrappedGitLab.RequestOptionFunc) (*wrappedGitLab.Job, *wrappedGitLab.Response, error)
+}
+
+type PipelinesAPI interface {
+	ListProjectPipelines(pid interface{}, opt *wrappedGitLab.ListProjectPipelinesOptions, options ...wrappedGitLab.RequestOptionFunc) ([]*wrappedGitLab.PipelineInfo, *wrappedGitLab.Response, error)
+}
+
+type RepositoriesAPI interface {
+	Compare(pid interface{}, opt *wrappedGitLab.CompareOptions, options ...wrappedGitLab.RequestOptionFunc) (*wrappedGitLab.Compare, *wrappedGitLab.Response, error)
+}
+
+type UsersAPI interface {
+	CurrentUser(options ...wrappedGitLab.RequestOptionFunc) (*wrappedGitLab.User, *wrappedGitLab.Response, error)
+}
@@ -0,0 +1,121 @@
+package gitlab
+
+import (
+	"context"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/utils"
+	"sort"
+	"time"
+)
+
+// JobCancellation keeps who and when canceled a job run from the dashboard
+// User is nil when OAuth is disabled
+type JobCancellation struct {
+	JobID      int          `json:"jobId"`
+	User       *ProjectUser `json:"user"`
+	CanceledAt time.Time    `json:"canceledAt"`
+}
+
+// JobCancelResult describes a cancellation of a job of a project
+type JobCancelResult struct {
+	ProjectID int                `json:"projectId"`
+	Job       *wrappedGitLab.Job `json:"job,omitempty"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// CancelJob cancels the running job which was run from the dashboard
+// The canceled job replaces the tracked one, so its watcher stops
+func (c *Service) CancelJob(ctx context.Context, environment string, projectID int, user *ProjectUser) (canceledJob *wrappedGitLab.Job, err error) {
+	ctx, span := startSpan(ctx, "Service.CancelJob",
+		projectIDKey.Int(projectID),
+		environmentKey.String(environment),
+	)
+	defer func() { endSpan(span, err) }()
+
+	if utils.StringsContainString(c.protectedEnvironments, environment) {
+		return nil, DeniedForProtectedEnvironment
+	}
+
+	job, ok := c.GetJob(environment, projectID)
+	if !ok {
+		return nil, JobNotFound
+	}
+	if !utils.StringsContainString(inProcessJobStatus, job.Status) {
+		return nil, JobIsNotRunning
+	}
+
+	canceledJob, _, err = c.git.Jobs.CancelJob(projectID, job.ID, wrappedGitLab.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	c.jobsMtx.Lock()
+	// The job could be replaced while we were waiting for GitLab
+	if c.jobs[environment][projectID].ID == canceledJob.ID {
+		c.jobs[environment][projectID] = canceledJob
+	}
+	if _, ok := c.cancellations[environment]; !ok {
+		c.cancellations[environment] = map[int]*JobCancellation{}
+	}
+	c.cancellations[environment][projectID] = &JobCancellation{
+		JobID:      canceledJob.ID,
+		User:       user,
+		CanceledAt: time.Now(),
+	}
+	c.jobsMtx.Unlock()
+
+	// The watcher is stopped by the canceled status, so we notify listeners here
+	c.emitJobEvent(JobEventCanceled, environment, projectID, canceledJob, user)
+
+	return canceledJob, nil
+}
+
+// CancelJobs cancels all running jobs of the environment which were run from the dashboard
+func (c *Service) CancelJobs(ctx context.Context, environment string, user *ProjectUser) ([]*JobCancelResult, error) {
+	ctx, span := startSpan(ctx, "Service.CancelJobs", environmentKey.String(environment))
+	defer span.End()
+
+	if utils.StringsContainString(c.protectedEnvironments, environment) {
+		return nil, DeniedForProtectedEnvironment
+	}
+
+	var projectIDs []int
+	for projectID, job := range c.GetJobs()[environment] {
+		if utils.StringsContainString(inProcessJobStatus, job.Status) {
+			projectIDs = append(projectIDs, projectID)
+		}
+	}
+	sort.Ints(projectIDs)
+
+	results := make([]*JobCancelResult, 0, len(projectIDs))
+	for _, projectID := range projectIDs {
+		result := &JobCancelResult{ProjectID: projectID}
+		results = append(results, result)
+
+		job, err := c.CancelJob(ctx, environment, projectID, user)
+		if err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		result.Job = job
+	}
+
+	return results, nil
+}
+
+// GetJobCancellation returns the cancellation of the tracked job if it was canceled from the dashboard
+func (c *Service) GetJobCancellation(environment string, projectID int) (*JobCancellation, bool) {
+	c.jobsMtx.RLock()
+	defer c.jobsMtx.RUnlock()
+
+	job, ok := c.jobs[environment][projectID]
+	if !ok {
+		return nil, false
+	}
+	cancellation, ok := c.cancellations[environment][projectID]
+	if !ok || cancellation.JobID != job.ID {
+		return nil, false
+	}
+
+	return cancellation, true
+}
@@ -0,0 +1,126 @@
+package gitlab
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForWatchers waits until job watchers are stopped without stopping the service
+func waitForWatchers(t *testing.T, service *Service) {
+	done := make(chan struct{})
+	go func() {
+		service.backgroundWg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * jobWatcherInterval):
+		t.Fatal("job watchers are still running")
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment string
+		started     bool
+		// finished replaces the tracked job status before the cancellation
+		finished bool
+		wantErr  error
+	}{
+		{"running", "qa", true, false, nil},
+		{"notStarted", "qa", false, false, JobNotFound},
+		{"finished", "qa", true, true, JobIsNotRunning},
+		{"protected", "production", false, false, DeniedForProtectedEnvironment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, server := newTestService(t, 1)
+			server.AddProject(1, "api")
+			server.AddJob(1, server.AddPipeline(1, "master", "a1"), tt.environment, JobStatusManual)
+			var events []JobEvent
+			var eventsMtx sync.Mutex
+			service.AddJobListener(func(event JobEvent) {
+				eventsMtx.Lock()
+				defer eventsMtx.Unlock()
+				events = append(events, event)
+			})
+
+			user := &ProjectUser{Username: "jdoe"}
+			if tt.started {
+				if _, err := service.PlayOrRetryJob(context.Background(), 1, tt.environment, "master", user); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.finished {
+				service.jobsMtx.Lock()
+				finishedJob := *service.jobs[tt.environment][1]
+				finishedJob.Status = JobStatusSuccess
+				service.jobs[tt.environment][1] = &finishedJob
+				service.jobsMtx.Unlock()
+			}
+
+			job, err := service.CancelJob(context.Background(), tt.environment, 1, user)
+			if err != tt.wantErr {
+				t.Fatalf("CancelJob() error = %v, want %v", err, tt.wantErr)
+			}
+			// The watcher of a canceled or finished job stops by itself
+			waitForWatchers(t, service)
+			if tt.wantErr != nil {
+				return
+			}
+
+			if job.Status != JobStatusCanceled {
+				t.Errorf("CancelJob() status = %s, want %s", job.Status, JobStatusCanceled)
+			}
+			if remoteJob, _ := server.Job(1, job.ID); remoteJob.Status != JobStatusCanceled {
+				t.Errorf("CancelJob() GitLab job status = %s, want %s", remoteJob.Status, JobStatusCanceled)
+			}
+			if trackedJob, _ := service.GetJob(tt.environment, 1); trackedJob.ID != job.ID || trackedJob.Status != JobStatusCanceled {
+				t.Errorf("CancelJob() tracked job = %d %s, want canceled job %d", trackedJob.ID, trackedJob.Status, job.ID)
+			}
+			if cancellation, ok := service.GetJobCancellation(tt.environment, 1); !ok || cancellation.JobID != job.ID || cancellation.User != user {
+				t.Errorf("CancelJob() cancellation = %+v, want job %d canceled by the user", cancellation, job.ID)
+			}
+			eventsMtx.Lock()
+			defer eventsMtx.Unlock()
+			if last := events[len(events)-1]; last.Event != JobEventCanceled || last.Job.ID != job.ID {
+				t.Errorf("CancelJob() last event = %s of job %d, want %s of job %d", last.Event, last.Job.ID, JobEventCanceled, job.ID)
+			}
+		})
+	}
+}
+
+func TestCancelJobs(t *testing.T) {
+	service, server := newTestService(t, 1, 2, 3)
+	for projectID := 1; projectID <= 3; projectID++ {
+		server.AddProject(projectID, "project")
+		server.AddJob(projectID, server.AddPipeline(projectID, "master", "a1"), "qa", JobStatusManual)
+	}
+	// Project 3 isn't deployed from the dashboard, so it's not canceled
+	for _, projectID := range []int{2, 1} {
+		if _, err := service.PlayOrRetryJob(context.Background(), projectID, "qa", "master", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := service.CancelJobs(context.Background(), "qa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForWatchers(t, service)
+
+	if len(results) != 2 {
+		t.Fatalf("CancelJobs() got %d results, want 2", len(results))
+	}
+	for i, result := range results {
+		if result.ProjectID != i+1 || result.Error != "" || result.Job == nil || result.Job.Status != JobStatusCanceled {
+			t.Errorf("CancelJobs() result %d = %+v, want canceled job of project %d", i, *result, i+1)
+		}
+	}
+	if _, err := service.CancelJobs(context.Background(), "production", nil); err != DeniedForProtectedEnvironment {
+		t.Errorf("CancelJobs() of a protected environment error = %v, want %v", err, DeniedForProtectedEnvironment)
+	}
+}
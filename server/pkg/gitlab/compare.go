@@ -0,0 +1,149 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+)
+
+// EnvironmentComparison shows a difference of a project between two environments
+// Ahead is amount of commits deployed on the left environment but not on the right one
+// Behind is amount of commits deployed on the right environment but not on the left one
+type EnvironmentComparison struct {
+	SnapshotDiff
+	Ahead  int    `json:"ahead"`
+	Behind int    `json:"behind"`
+	Error  string `json:"error,omitempty"`
+}
+
+// CompareEnvironments lines up last deployments of projects in two environments
+// Commit counts are fetched from GitLab only for different projects
+func (c *Service) CompareEnvironments(ctx context.Context, left string, right string) (comparisons []*EnvironmentComparison, err error) {
+	ctx, span := startSpan(ctx, "Service.CompareEnvironments")
+	defer func() { endSpan(span, err) }()
+
+	leftSnapshot, err := c.takeSnapshot(left, "")
+	if err != nil {
+		return nil, err
+	}
+	rightSnapshot, err := c.takeSnapshot(right, "")
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := diffSnapshotProjects(leftSnapshot.Projects, rightSnapshot.Projects)
+	comparisons = make([]*EnvironmentComparison, len(diffs))
+	for i, diff := range diffs {
+		comparison := &EnvironmentComparison{SnapshotDiff: *diff}
+		comparisons[i] = comparison
+		if diff.Status != DiffDifferent {
+			continue
+		}
+
+		comparison.Ahead, err = c.countCommitsBetween(ctx, diff.ProjectID, diff.Right.SHA, diff.Left.SHA)
+		if err != nil {
+			comparison.Error = err.Error()
+			continue
+		}
+		comparison.Behind, err = c.countCommitsBetween(ctx, diff.ProjectID, diff.Left.SHA, diff.Right.SHA)
+		if err != nil {
+			comparison.Error = err.Error()
+		}
+	}
+
+	return comparisons, nil
+}
+
+// countCommitsBetween returns amount of commits which `to` has but `from` doesn't
+func (c *Service) countCommitsBetween(ctx context.Context, projectID int, from string, to string) (int, error) {
+	compare, err := c.compareCommits(ctx, projectID, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(compare.Commits), nil
+}
+
+// compareCommits uses GitLab compare API, commits are compared from the merge base
+func (c *Service) compareCommits(ctx context.Context, projectID int, from string, to string) (*wrappedGitLab.Compare, error) {
+	compare, _, err := c.git.Repositories.Compare(projectID, &wrappedGitLab.CompareOptions{
+		From: wrappedGitLab.String(from),
+		To:   wrappedGitLab.String(to),
+	}, wrappedGitLab.WithContext(ctx))
+
+	return compare, err
+}
+
+// getBranch returns cached branch by name
+func (c *Service) getBranch(projectID int, name string) (*wrappedGitLab.Branch, bool) {
+	c.branchesMtx.RLock()
+	defer c.branchesMtx.RUnlock()
+
+	for _, branch := range c.branches[projectID] {
+		if branch.Name == name {
+			return branch, true
+		}
+	}
+
+	return nil, false
+}
+
+// updateBehindBy counts commits between deployed commits and heads of their branches
+// Only counts for commits of the current update are kept in the cache
+func (c *Service) updateBehindBy(ctx context.Context, environments map[string]*Environment) {
+	c.behindByMtx.Lock()
+	defer c.behindByMtx.Unlock()
+
+	behindBy := map[string]int{}
+	for _, environment := range environments {
+		for _, project := range environment.Projects {
+			if project == nil || project.LastDeployment == nil {
+				continue
+			}
+			branch, ok := c.getBranch(project.ID, project.LastDeployment.Ref)
+			if !ok || branch.Commit == nil {
+				continue
+			}
+
+			key := fmt.Sprintf("%d:%s:%s", project.ID, project.LastDeployment.SHA, branch.Commit.ID)
+			count, ok := c.behindBy[key]
+			if !ok {
+				var err error
+				count, err = c.countCommitsBetween(ctx, project.ID, project.LastDeployment.SHA, branch.Commit.ID)
+				if err != nil {
+					log.WithContext(ctx).Errorf("cannot compare commits of project %d: %v", project.ID, err)
+					continue
+				}
+			}
+			behindBy[key] = count
+			project.BehindBy = &count
+		}
+	}
+	c.behindBy = behindBy
+}
+
+// GetMissingCommits returns commits of the deployed branch which are not deployed to the environment
+func (c *Service) GetMissingCommits(ctx context.Context, environment string, projectID int) (commits []*wrappedGitLab.Commit, err error) {
+	ctx, span := startSpan(ctx, "Service.GetMissingCommits",
+		projectIDKey.Int(projectID),
+		environmentKey.String(environment),
+	)
+	defer func() { endSpan(span, err) }()
+
+	project, ok := c.getProject(environment, projectID)
+	if !ok || project.LastDeployment == nil {
+		return nil, DeploymentNotFound
+	}
+	branch, ok := c.getBranch(projectID, project.LastDeployment.Ref)
+	if !ok || branch.Commit == nil {
+		return nil, BranchNotFound
+	}
+
+	compare, err := c.compareCommits(ctx, projectID, project.LastDeployment.SHA, branch.Commit.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return compare.Commits, nil
+}
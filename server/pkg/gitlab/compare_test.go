@@ -0,0 +1,140 @@
+package gitlab
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCompareEnvironments(t *testing.T) {
+	service, server := newTestService(t, 1, 2, 3, 4, 5)
+	// Ahead, qa has two commits on top of staging
+	server.AddProject(1, "api")
+	server.AddCommit(1, "a1")
+	server.AddCommit(1, "a2", "a1")
+	server.AddCommit(1, "a3", "a2")
+	server.AddEnvironment(1, "qa", "master", "a3")
+	server.AddEnvironment(1, "staging", "master", "a1")
+	// Identical
+	server.AddProject(2, "web")
+	server.AddCommit(2, "b1")
+	server.AddEnvironment(2, "qa", "master", "b1")
+	server.AddEnvironment(2, "staging", "master", "b1")
+	// Missing on staging
+	server.AddProject(3, "worker")
+	server.AddCommit(3, "c1")
+	server.AddEnvironment(3, "qa", "master", "c1")
+	// Diverged, both environments have a commit on top of d1
+	server.AddProject(4, "docs")
+	server.AddCommit(4, "d1")
+	server.AddCommit(4, "d2", "d1")
+	server.AddCommit(4, "d3", "d1")
+	server.AddEnvironment(4, "qa", "feature/x", "d2")
+	server.AddEnvironment(4, "staging", "master", "d3")
+	// Commits are unknown to GitLab, i.e. after a force push
+	server.AddProject(5, "admin")
+	server.AddEnvironment(5, "qa", "master", "e1")
+	server.AddEnvironment(5, "staging", "master", "e2")
+	if err := service.UpdateEnvironments(context.Background(), []int{1, 2, 3, 4, 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	comparisons, err := service.CompareEnvironments(context.Background(), "qa", "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		status   string
+		ahead    int
+		behind   int
+		hasError bool
+	}{
+		{DiffDifferent, 2, 0, false},
+		{DiffIdentical, 0, 0, false},
+		{DiffMissing, 0, 0, false},
+		{DiffDifferent, 1, 1, false},
+		{DiffDifferent, 0, 0, true},
+	}
+	if len(comparisons) != len(want) {
+		t.Fatalf("CompareEnvironments() got %d comparisons, want %d", len(comparisons), len(want))
+	}
+	for i, comparison := range comparisons {
+		if comparison.Status != want[i].status || comparison.Ahead != want[i].ahead || comparison.Behind != want[i].behind || (comparison.Error != "") != want[i].hasError {
+			t.Errorf("CompareEnvironments() project %d = %+v, want %+v", comparison.ProjectID, *comparison, want[i])
+		}
+	}
+
+	if _, err := service.CompareEnvironments(context.Background(), "qa", "production-eu"); err != EnvironmentNotFound {
+		t.Errorf("CompareEnvironments() of an unknown environment error = %v, want %v", err, EnvironmentNotFound)
+	}
+}
+
+func TestUpdateBehindBy(t *testing.T) {
+	service, server := newTestService(t, 1)
+	now := time.Now()
+	server.AddProject(1, "api")
+	server.AddCommit(1, "a1")
+	server.AddCommit(1, "a2", "a1")
+	server.AddCommit(1, "a3", "a2")
+	server.AddBranch(1, "master", "a3", now)
+	server.AddEnvironment(1, "qa", "master", "a1")
+	server.AddEnvironment(1, "staging", "master", "a3")
+	// The deleted branch isn't cached, so the deployment is never behind
+	server.AddEnvironment(1, "review", "feature/x", "a2")
+
+	behindBy := func(environment string) *int {
+		project, ok := service.getProject(environment, 1)
+		if !ok {
+			t.Fatalf("getProject(%s) didn't find the project", environment)
+		}
+		return project.BehindBy
+	}
+
+	// Branches are not cached before the first update
+	if err := service.UpdateEnvironments(context.Background(), []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if count := behindBy("qa"); count != nil {
+		t.Errorf("BehindBy before the branches update = %d, want nil", *count)
+	}
+	if _, err := service.GetMissingCommits(context.Background(), "qa", 1); err != BranchNotFound {
+		t.Errorf("GetMissingCommits() before the branches update error = %v, want %v", err, BranchNotFound)
+	}
+
+	if err := service.UpdateBranches(context.Background(), []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.UpdateEnvironments(context.Background(), []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		environment string
+		want        *int
+	}{
+		{"qa", intPtr(2)},
+		{"staging", intPtr(0)},
+		{"review", nil},
+	}
+	for _, tt := range tests {
+		got := behindBy(tt.environment)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("BehindBy of %s = %v, want %v", tt.environment, got, tt.want)
+		}
+	}
+
+	commits, err := service.GetMissingCommits(context.Background(), "qa", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 || commits[0].ID != "a2" || commits[1].ID != "a3" {
+		t.Errorf("GetMissingCommits() = %v, want a2 and a3", commits)
+	}
+	if _, err := service.GetMissingCommits(context.Background(), "production-eu", 1); err != DeploymentNotFound {
+		t.Errorf("GetMissingCommits() of an unknown environment error = %v, want %v", err, DeploymentNotFound)
+	}
+}
+
+func intPtr(value int) *int {
+	return &value
+}
@@ -32,6 +32,7 @@ func convertWrappedDeployment(deployment *wrappedGitlab.Deployment) *Deployment
 	return &Deployment{
 		ID:         deployment.ID,
 		Ref:        deployment.Ref,
+		SHA:        deployment.SHA,
 		User:       convertWrappedProjectUser(deployment.User),
 		UpdatedAt:  deployment.UpdatedAt,
 		Deployable: convertWrappedDeployable(deployment),
@@ -40,6 +41,8 @@ func convertWrappedDeployment(deployment *wrappedGitlab.Deployment) *Deployment
 
 func convertWrappedDeployable(deployment *wrappedGitlab.Deployment) *Deployable {
 	return &Deployable{
+		Name:     deployment.Deployable.Name,
+		Duration: deployment.Deployable.Duration,
 		Pipeline: Pipeline{
 			ID:     deployment.Deployable.Pipeline.ID,
 			Status: deployment.Deployable.Status,
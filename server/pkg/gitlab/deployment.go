@@ -0,0 +1,156 @@
+package gitlab
+
+import (
+	"context"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/utils"
+	"time"
+)
+
+// Deployment statuses which could be used in DeploymentsFilter
+var deploymentStatuses = []string{
+	JobStatusCreated,
+	JobStatusRunning,
+	JobStatusSuccess,
+	JobStatusFailed,
+	JobStatusCanceled,
+}
+
+const (
+	defaultDeploymentsPerPage = 5
+	maxDeploymentsPerPage     = 100
+	// GitLab pages are always of the same size, so pages of the cursor don't depend on `PerPage`
+	scannedDeploymentsPerPage = 100
+	// Filters are applied on our side
+	// so we limit amount of GitLab pages scanned by one request
+	maxScannedDeploymentPages = 10
+)
+
+// DeploymentsFilter describes which deployments should be listed
+// Empty fields are not used for filtering
+type DeploymentsFilter struct {
+	// Page is a page of GitLab deployments to start from, 1 by default
+	Page int
+	// BeforeID skips deployments of the page which were listed already, see DeploymentsCursor
+	BeforeID int
+	// PerPage is 5 by default
+	PerPage int
+	// Statuses are statuses of deploy jobs (Deployable.Status), they are shown with deployments
+	Statuses      []string
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	Username      string
+	Ref           string
+}
+
+// validate checks the filter and sets defaults
+func (f *DeploymentsFilter) validate() error {
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	if f.PerPage < 1 {
+		f.PerPage = defaultDeploymentsPerPage
+	}
+	if f.PerPage > maxDeploymentsPerPage {
+		f.PerPage = maxDeploymentsPerPage
+	}
+	for _, status := range f.Statuses {
+		if !utils.StringsContainString(deploymentStatuses, status) {
+			return invalidArgument("unknown deployment status: %s", status)
+		}
+	}
+
+	return nil
+}
+
+// DeploymentsCursor is a position to continue listing from
+// Page is 0 when there are no more deployments, BeforeID is the last scanned deployment
+// New deployments shift older ones to next pages, so deployments which were scanned already are skipped by their IDs
+type DeploymentsCursor struct {
+	Page     int
+	BeforeID int
+}
+
+// match checks the filters on our side
+// GitLab filters by the status of deployments, which differs from the status of the job, so statuses are checked here too
+func (f *DeploymentsFilter) match(deployment *wrappedGitLab.Deployment) bool {
+	if f.BeforeID != 0 && deployment.ID >= f.BeforeID {
+		return false
+	}
+	if len(f.Statuses) > 0 && !utils.StringsContainString(f.Statuses, deployment.Deployable.Status) {
+		return false
+	}
+	if f.Ref != "" && deployment.Ref != f.Ref {
+		return false
+	}
+	if f.Username != "" && (deployment.User == nil || deployment.User.Username != f.Username) {
+		return false
+	}
+	if f.UpdatedAfter != nil && (deployment.UpdatedAt == nil || deployment.UpdatedAt.Before(*f.UpdatedAfter)) {
+		return false
+	}
+	if f.UpdatedBefore != nil && (deployment.UpdatedAt == nil || deployment.UpdatedAt.After(*f.UpdatedBefore)) {
+		return false
+	}
+
+	return true
+}
+
+// ListProjectDeployments returns at most `PerPage` deployments of the project in the environment, newest first
+// It scans GitLab pages until `PerPage` deployments match the filter or `maxScannedDeploymentPages` are scanned,
+// so fewer deployments with a next page mean that the scan limit is reached.
+// `next` is a position to continue from
+func (c *Service) ListProjectDeployments(ctx context.Context, environment string, projectID int, filter DeploymentsFilter) (deployments []*Deployment, next DeploymentsCursor, err error) {
+	ctx, span := startSpan(ctx, "Service.ListProjectDeployments",
+		projectIDKey.Int(projectID),
+		environmentKey.String(environment),
+	)
+	defer func() { endSpan(span, err) }()
+
+	err = filter.validate()
+	if err != nil {
+		return nil, DeploymentsCursor{}, err
+	}
+
+	options := &wrappedGitLab.ListProjectDeploymentsOptions{
+		Environment: wrappedGitLab.String(environment),
+		OrderBy:     wrappedGitLab.String("id"),
+		Sort:        wrappedGitLab.String("desc"),
+		ListOptions: wrappedGitLab.ListOptions{
+			PerPage: scannedDeploymentsPerPage,
+			Page:    filter.Page,
+		},
+	}
+
+	deployments = []*Deployment{}
+	for scanned := 0; scanned < maxScannedDeploymentPages; scanned++ {
+		remoteDeployments, resp, err := c.git.Deployments.ListProjectDeployments(projectID, options, wrappedGitLab.WithContext(ctx))
+		if err != nil {
+			return nil, DeploymentsCursor{}, err
+		}
+
+		for i, deployment := range remoteDeployments {
+			next.BeforeID = deployment.ID
+			if !filter.match(deployment) {
+				continue
+			}
+			deployments = append(deployments, convertWrappedDeployment(deployment))
+			if len(deployments) == filter.PerPage && (i < len(remoteDeployments)-1 || resp.NextPage != 0) {
+				// The rest of the page is listed by the next request
+				next.Page = options.Page
+				if i == len(remoteDeployments)-1 {
+					next.Page = resp.NextPage
+				}
+				return deployments, next, nil
+			}
+		}
+
+		if resp.NextPage == 0 {
+			return deployments, DeploymentsCursor{}, nil
+		}
+		options.Page = resp.NextPage
+	}
+
+	next.Page = options.Page
+	return deployments, next, nil
+}
@@ -0,0 +1,125 @@
+package gitlab
+
+import (
+	"context"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"testing"
+	"time"
+)
+
+func TestDeploymentsFilterMatch(t *testing.T) {
+	updatedAt := time.Date(2020, 8, 21, 10, 0, 0, 0, time.UTC)
+	before := updatedAt.Add(-time.Hour)
+	after := updatedAt.Add(time.Hour)
+
+	deployment := &wrappedGitLab.Deployment{
+		ID:        10,
+		Ref:       "master",
+		UpdatedAt: &updatedAt,
+		User:      &wrappedGitLab.ProjectUser{Username: "admit133"},
+	}
+	deployment.Deployable.Status = JobStatusFailed
+
+	tests := []struct {
+		name   string
+		filter DeploymentsFilter
+		want   bool
+	}{
+		{"empty", DeploymentsFilter{}, true},
+		{"status", DeploymentsFilter{Statuses: []string{JobStatusFailed}}, true},
+		{"otherStatus", DeploymentsFilter{Statuses: []string{JobStatusSuccess}}, false},
+		{"statuses", DeploymentsFilter{Statuses: []string{JobStatusSuccess, JobStatusFailed}}, true},
+		{"otherStatuses", DeploymentsFilter{Statuses: []string{JobStatusSuccess, JobStatusCanceled}}, false},
+		{"ref", DeploymentsFilter{Ref: "master"}, true},
+		{"otherRef", DeploymentsFilter{Ref: "develop"}, false},
+		{"user", DeploymentsFilter{Username: "admit133"}, true},
+		{"otherUser", DeploymentsFilter{Username: "root"}, false},
+		{"dateRange", DeploymentsFilter{UpdatedAfter: &before, UpdatedBefore: &after}, true},
+		{"updatedAfter", DeploymentsFilter{UpdatedAfter: &after}, false},
+		{"updatedBefore", DeploymentsFilter{UpdatedBefore: &before}, false},
+		{"beforeID", DeploymentsFilter{BeforeID: 11}, true},
+		{"scannedAlready", DeploymentsFilter{BeforeID: 10}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.match(deployment); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListProjectDeployments(t *testing.T) {
+	service, server := newTestService(t, 1)
+	server.AddProject(1, "api")
+	var successful []int
+	for i := 0; i < 150; i++ {
+		status := JobStatusFailed
+		if i%2 == 0 {
+			status = JobStatusSuccess
+		}
+		id := server.AddDeployment(1, "qa", "master", status)
+		if status == JobStatusSuccess {
+			successful = append([]int{id}, successful...)
+		}
+		server.AddDeployment(1, "review", "master", JobStatusSuccess)
+	}
+
+	var listed []int
+	filter := DeploymentsFilter{PerPage: 30, Statuses: []string{JobStatusSuccess}}
+	for requests := 1; ; requests++ {
+		deployments, next, err := service.ListProjectDeployments(context.Background(), "qa", 1, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deployments) > filter.PerPage {
+			t.Fatalf("ListProjectDeployments() returned %d deployments, want at most %d", len(deployments), filter.PerPage)
+		}
+		for _, deployment := range deployments {
+			listed = append(listed, deployment.ID)
+		}
+		if next.Page == 0 {
+			break
+		}
+		if requests > 10 {
+			t.Fatalf("ListProjectDeployments() doesn't stop, the last cursor is %+v", next)
+		}
+		filter.Page, filter.BeforeID = next.Page, next.BeforeID
+	}
+
+	if len(listed) != len(successful) {
+		t.Fatalf("ListProjectDeployments() listed %d deployments, want %d", len(listed), len(successful))
+	}
+	for i := range successful {
+		if listed[i] != successful[i] {
+			t.Fatalf("ListProjectDeployments() listed %d at %d, want %d", listed[i], i, successful[i])
+		}
+	}
+}
+
+func TestListProjectDeploymentsScanLimit(t *testing.T) {
+	service, server := newTestService(t, 1)
+	server.AddProject(1, "api")
+	oldest := server.AddDeployment(1, "qa", "master", JobStatusSuccess)
+	for i := 0; i < maxScannedDeploymentPages*scannedDeploymentsPerPage; i++ {
+		server.AddDeployment(1, "qa", "master", JobStatusFailed)
+	}
+
+	filter := DeploymentsFilter{Statuses: []string{JobStatusSuccess}}
+	deployments, next, err := service.ListProjectDeployments(context.Background(), "qa", 1, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployments) != 0 || next.Page != maxScannedDeploymentPages+1 || next.BeforeID != oldest+1 {
+		t.Fatalf("ListProjectDeployments() = %d deployments, next %+v, want none and the next page after the limit", len(deployments), next)
+	}
+
+	filter.Page, filter.BeforeID = next.Page, next.BeforeID
+	deployments, next, err = service.ListProjectDeployments(context.Background(), "qa", 1, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployments) != 1 || deployments[0].ID != oldest || next.Page != 0 {
+		t.Errorf("ListProjectDeployments() = %d deployments, next %+v, want the oldest deployment on the last page", len(deployments), next)
+	}
+}
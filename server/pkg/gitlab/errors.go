@@ -0,0 +1,88 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/breaker"
+	"net"
+	"net/http"
+)
+
+// ErrorKind is a class of errors, the API responds with a status code of the kind
+type ErrorKind string
+
+const (
+	KindForbidden ErrorKind = "forbidden"
+	KindNotFound  ErrorKind = "notFound"
+	// KindConflict means the action conflicts with the state, i.e. the job is running or the environment is locked
+	KindConflict ErrorKind = "conflict"
+	// KindInvalid means the arguments are understood but wrong, i.e. a regex which doesn't compile
+	KindInvalid ErrorKind = "invalid"
+	// KindUpstream means GitLab responded with an error
+	KindUpstream ErrorKind = "upstream"
+	// KindUnavailable means GitLab cannot be reached or the circuit breaker is open
+	KindUnavailable ErrorKind = "unavailable"
+)
+
+// Error is an error of the service
+// Code is stable and machine-readable, clients should rely on it instead of the message
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+	// Details are optional facts of the error, i.e. the status code of GitLab
+	Details map[string]interface{}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(kind ErrorKind, code string, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+// CodeInvalidArgument is a code of invalid arguments, i.e. an unknown match mode
+const CodeInvalidArgument = "invalid_argument"
+
+func invalidArgument(format string, args ...interface{}) *Error {
+	return newError(KindInvalid, CodeInvalidArgument, fmt.Sprintf(format, args...))
+}
+
+// ClassifyError returns the Error of the service or the kind of a GitLab failure
+// It returns nil when the error is unknown
+func ClassifyError(err error) *Error {
+	var serviceErr *Error
+	if errors.As(err, &serviceErr) {
+		return serviceErr
+	}
+	if errors.Is(err, breaker.CircuitIsOpen) {
+		return newError(KindUnavailable, "gitlab_unavailable", err.Error())
+	}
+
+	var responseErr *wrappedGitLab.ErrorResponse
+	if errors.As(err, &responseErr) && responseErr.Response != nil {
+		status := responseErr.Response.StatusCode
+		classified := newError(KindUpstream, "gitlab_error", err.Error())
+		switch {
+		case status == http.StatusNotFound:
+			classified = newError(KindNotFound, "gitlab_not_found", err.Error())
+		case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+			classified = newError(KindUnavailable, "gitlab_unavailable", err.Error())
+		}
+		classified.Details = map[string]interface{}{"gitlabStatus": status}
+		return classified
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newError(KindUnavailable, "gitlab_timeout", err.Error())
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return newError(KindUnavailable, "gitlab_unavailable", err.Error())
+	}
+
+	return nil
+}
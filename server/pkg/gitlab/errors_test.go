@@ -0,0 +1,59 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/breaker"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	gitLabError := func(status int) error {
+		return &wrappedGitLab.ErrorResponse{
+			Response: &http.Response{StatusCode: status, Request: &http.Request{Method: "GET", URL: &url.URL{}}},
+			Message:  http.StatusText(status),
+		}
+	}
+
+	tests := []struct {
+		name         string
+		err          error
+		wantKind     ErrorKind
+		wantCode     string
+		wantGitLabSC int
+	}{
+		{"service", EnvironmentIsLocked, KindConflict, "environment_locked", 0},
+		{"wrapped", fmt.Errorf("cannot deploy: %w", DeniedForProtectedEnvironment), KindForbidden, "protected_environment", 0},
+		{"invalidArgument", invalidArgument("wrong regex: %s", "("), KindInvalid, CodeInvalidArgument, 0},
+		{"breaker", fmt.Errorf("GET /projects: %w", breaker.CircuitIsOpen), KindUnavailable, "gitlab_unavailable", 0},
+		{"gitLabNotFound", gitLabError(http.StatusNotFound), KindNotFound, "gitlab_not_found", http.StatusNotFound},
+		{"gitLabFailed", gitLabError(http.StatusInternalServerError), KindUpstream, "gitlab_error", http.StatusInternalServerError},
+		{"gitLabForbidden", gitLabError(http.StatusForbidden), KindUpstream, "gitlab_error", http.StatusForbidden},
+		{"gitLabRateLimited", gitLabError(http.StatusTooManyRequests), KindUnavailable, "gitlab_unavailable", http.StatusTooManyRequests},
+		{"timeout", fmt.Errorf("GET /projects: %w", context.DeadlineExceeded), KindUnavailable, "gitlab_timeout", 0},
+		{"network", &url.Error{Op: "Get", URL: "https://gitlab.com", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, KindUnavailable, "gitlab_unavailable", 0},
+		{"unknown", errors.New("something went wrong"), "", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			classified := ClassifyError(tt.err)
+			if tt.wantCode == "" {
+				if classified != nil {
+					t.Errorf("ClassifyError() = %+v, want nil", classified)
+				}
+				return
+			}
+			if classified == nil || classified.Kind != tt.wantKind || classified.Code != tt.wantCode {
+				t.Fatalf("ClassifyError() = %+v, want %s %s", classified, tt.wantKind, tt.wantCode)
+			}
+			if tt.wantGitLabSC != 0 && classified.Details["gitlabStatus"] != tt.wantGitLabSC {
+				t.Errorf("ClassifyError() details = %v, want gitlabStatus %d", classified.Details, tt.wantGitLabSC)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package gitlab
+
+import (
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+)
+
+// Job events which are sent to job listeners
+const (
+	JobEventStarted   = "started"
+	JobEventSucceeded = "succeeded"
+	JobEventFailed    = "failed"
+	JobEventCanceled  = "canceled"
+)
+
+// JobEvent describes a status change of a job run from the dashboard
+// Project is nil when the project isn't in the environments cache yet
+// User started or canceled the job, it's nil when OAuth is disabled
+type JobEvent struct {
+	Event       string
+	Environment string
+	ProjectID   int
+	Project     *Project
+	Job         *wrappedGitLab.Job
+	User        *ProjectUser
+}
+
+// JobListener receives job events, it shouldn't block the caller
+type JobListener func(event JobEvent)
+
+// AddJobListener subscribes the listener to job events
+func (c *Service) AddJobListener(listener JobListener) {
+	c.jobListenersMtx.Lock()
+	defer c.jobListenersMtx.Unlock()
+
+	c.jobListeners = append(c.jobListeners, listener)
+}
+
+// emitJobEvent sends the event to all job listeners
+func (c *Service) emitJobEvent(event string, environment string, projectID int, job *wrappedGitLab.Job, user *ProjectUser) {
+	project, _ := c.getProject(environment, projectID)
+	jobEvent := JobEvent{
+		Event:       event,
+		Environment: environment,
+		ProjectID:   projectID,
+		Project:     project,
+		Job:         job,
+		User:        user,
+	}
+
+	c.jobListenersMtx.RLock()
+	defer c.jobListenersMtx.RUnlock()
+
+	for _, listener := range c.jobListeners {
+		listener(jobEvent)
+	}
+}
+
+// getJobEvent returns an event for a finished job status
+func getJobEvent(status string) (string, bool) {
+	switch status {
+	case JobStatusSuccess:
+		return JobEventSucceeded, true
+	case JobStatusFailed:
+		return JobEventFailed, true
+	case JobStatusCanceled:
+		return JobEventCanceled, true
+	}
+
+	return "", false
+}
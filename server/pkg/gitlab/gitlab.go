@@ -4,31 +4,42 @@ Package gitlab is an application abstraction upon github.com/xanzy/go-gitlab
 package gitlab
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	wrappedGitLab "github.com/xanzy/go-gitlab"
 	"gitlab-environment-dashboard/server/pkg/utils"
+	"net/http"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
 var (
-	DeniedForProtectedEnvironment = errors.New("cannot perform the action for an protected environment")
-	JobNotFound                   = errors.New("job not found")
-	JobIsNotReady                 = errors.New("job is not ready")
+	DeniedForProtectedEnvironment = newError(KindForbidden, "protected_environment", "cannot perform the action for an protected environment")
+	JobNotFound                   = newError(KindNotFound, "job_not_found", "job not found")
+	JobIsNotReady                 = newError(KindConflict, "job_not_ready", "job is not ready")
+	JobIsAlreadyRunning           = newError(KindConflict, "job_already_running", "job already running")
+	BranchNotFound                = newError(KindNotFound, "branch_not_found", "branch not found")
+	EnvironmentNotFound           = newError(KindNotFound, "environment_not_found", "environment not found")
+	DeploymentNotFound            = newError(KindNotFound, "deployment_not_found", "deployment not found")
+	JobIsNotRunning               = newError(KindConflict, "job_not_running", "job is not running")
 )
 
 // Service operates with gitlab API
 type Service struct {
-	git             *wrappedGitLab.Client
+	git             *API
 	environments    map[string]*Environment
 	environmentsMtx sync.RWMutex
+	// Time of the last successful update, guarded by environmentsMtx
+	environmentsUpdatedAt time.Time
 
 	// branches by project ID
 	branches    map[int][]*wrappedGitLab.Branch
 	branchesMtx sync.RWMutex
+	// Time of the last update, guarded by branchesMtx
+	branchesUpdatedAt time.Time
 
 	// We store jobs which was run from the dashboard
 	jobs                  map[string]map[int]*wrappedGitLab.Job
@@ -38,6 +49,40 @@ type Service struct {
 	// Sometimes could have scheduled pipeline which doesn't have environments
 	// We we try to run a job it finds first pipeline with the expected environment
 	jobRecursiveSearchLimit int
+
+	// Snapshots and the last restore by environment name
+	snapshots    map[string]map[string]*Snapshot
+	restores     map[string]*SnapshotRestore
+	snapshotsMtx sync.RWMutex
+	// Snapshots are saved to this file when it's set by LoadSnapshots
+	snapshotsFile string
+
+	jobListeners    []JobListener
+	jobListenersMtx sync.RWMutex
+
+	locks    map[string]*EnvironmentLock
+	locksMtx sync.RWMutex
+
+	// Starts and cancellations of tracked jobs, guarded by jobsMtx
+	starts        map[string]map[int]*JobStart
+	cancellations map[string]map[int]*JobCancellation
+
+	// Amount of commits between two commits by "projectID:from:to"
+	// It prevents comparing the same commits on every environments update
+	behindBy    map[string]int
+	behindByMtx sync.Mutex
+
+	// Job watchers, snapshot restores and background tasks outlive requests
+	// They are run with this context and stopped by Stop
+	background     context.Context
+	stopBackground context.CancelFunc
+	backgroundWg   sync.WaitGroup
+	// watchInterval is how often watched jobs are checked, jobWatcherInterval by default
+	watchInterval time.Duration
+
+	// The last result of CheckGitLab
+	gitLabStatus    GitLabStatus
+	gitLabStatusMtx sync.Mutex
 }
 
 // Environment represents a wrapper for wrappedGitLab.Environment
@@ -54,12 +99,16 @@ type Project struct {
 	WebURL            string      `json:"webURL"`
 	NameWithNamespace string      `json:"nameWithNamespace"`
 	LastDeployment    *Deployment `json:"lastDeployment"`
+	// BehindBy is amount of commits the deployed commit is behind the head of its branch
+	// It's nil when the branch is unknown (i.e. a tag or a removed branch)
+	BehindBy *int `json:"behindBy"`
 }
 
 // Deployment represents a wrapper for wrappedGitLab.Deployment
 type Deployment struct {
 	ID         int          `json:"id"`
 	Ref        string       `json:"ref"`
+	SHA        string       `json:"sha"`
 	User       *ProjectUser `json:"user"`
 	UpdatedAt  *time.Time   `json:"updatedAt"`
 	Deployable *Deployable  `json:"deployable"`
@@ -67,6 +116,10 @@ type Deployment struct {
 
 // Deployable represents a status of a deploy launch
 type Deployable struct {
+	// Name is a name of the deploy job
+	Name string `json:"name"`
+	// Duration of the deploy job in seconds
+	Duration float64  `json:"duration"`
 	Pipeline Pipeline `json:"pipeline"`
 }
 
@@ -88,6 +141,8 @@ type ProjectUser struct {
 	Name      string `json:"name"`
 	Username  string `json:"username"`
 	AvatarURL string `json:"avatarURL"`
+	// Token is a name of the API token when the user acts by the token
+	Token string `json:"token,omitempty"`
 }
 
 // Sorting branches by committed_date desc
@@ -98,6 +153,10 @@ func (s ByCommitDateDesc) Len() int {
 }
 
 func (s ByCommitDateDesc) Less(i, j int) bool {
+	// Branches with the same commit date are sorted by name to keep the order stable
+	if s[i].Commit.CommittedDate.Equal(*s[j].Commit.CommittedDate) {
+		return s[i].Name < s[j].Name
+	}
 	return s[i].Commit.CommittedDate.After(*s[j].Commit.CommittedDate)
 }
 
@@ -119,45 +178,81 @@ func (c *Service) GetBranches(projectID int) ([]*wrappedGitLab.Branch, error) {
 	return branches, nil
 }
 
-func (c *Service) UpdateBranches(projectIDs []int) error {
+// UpdateBranches updates branches cache
+// A project which failed keeps its previous branches and the error of every failed project is returned
+// The update time is changed only when all projects are updated
+func (c *Service) UpdateBranches(ctx context.Context, projectIDs []int) (err error) {
+	ctx, span := startSpan(ctx, "Service.UpdateBranches")
+	defer func() { endSpan(span, err) }()
+
 	branchesByProjectID := make(map[int][]*wrappedGitLab.Branch, len(projectIDs))
+	var failures []string
 	for _, projectID := range projectIDs {
-		var branches []*wrappedGitLab.Branch
-		page := 1
-		for {
-			remoteBranches, resp, err := c.git.Branches.ListBranches(
-				projectID,
-				&wrappedGitLab.ListBranchesOptions{
-					ListOptions: wrappedGitLab.ListOptions{PerPage: 100, Page: page},
-				},
-			)
-
-			if err != nil {
-				log.Println("Error when getting branches list, ", err)
-				break
-			}
-
-			for _, branch := range remoteBranches {
-				branches = append(branches, branch)
-			}
-
-			if page >= resp.TotalPages {
-				break
-			}
-
-			page++
+		branches, err := c.listBranches(ctx, projectID, nil)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("project %d: %v", projectID, err))
+			continue
 		}
-		sort.Sort(ByCommitDateDesc(branches))
 		branchesByProjectID[projectID] = branches
 	}
+	// Don't replace the cache with branches of a canceled update
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	c.branchesMtx.Lock()
+	defer c.branchesMtx.Unlock()
+	for _, projectID := range projectIDs {
+		if _, ok := branchesByProjectID[projectID]; !ok {
+			if branches, ok := c.branches[projectID]; ok {
+				branchesByProjectID[projectID] = branches
+			}
+		}
+	}
 	c.branches = branchesByProjectID
-	c.branchesMtx.Unlock()
+	if len(failures) > 0 {
+		return fmt.Errorf("cannot update branches of %d of %d projects: %s", len(failures), len(projectIDs), strings.Join(failures, "; "))
+	}
+	c.branchesUpdatedAt = time.Now()
 
 	return nil
 }
 
+// listBranches fetches all pages of branches from GitLab sorted by committed_date desc
+// `search` is passed to GitLab as is (i.e. `^prefix`)
+// In case of an error it returns branches fetched before the error
+func (c *Service) listBranches(ctx context.Context, projectID int, search *string) ([]*wrappedGitLab.Branch, error) {
+	var branches []*wrappedGitLab.Branch
+	var err error
+	page := 1
+	for {
+		var remoteBranches []*wrappedGitLab.Branch
+		var resp *wrappedGitLab.Response
+		remoteBranches, resp, err = c.git.Branches.ListBranches(
+			projectID,
+			&wrappedGitLab.ListBranchesOptions{
+				ListOptions: wrappedGitLab.ListOptions{PerPage: 100, Page: page},
+				Search:      search,
+			},
+			wrappedGitLab.WithContext(ctx),
+		)
+		if err != nil {
+			break
+		}
+
+		branches = append(branches, remoteBranches...)
+
+		if page >= resp.TotalPages {
+			break
+		}
+
+		page++
+	}
+	sort.Sort(ByCommitDateDesc(branches))
+
+	return branches, err
+}
+
 const (
 	JobStatusPreparing = "preparing"
 	JobStatusCreated   = "created"
@@ -189,33 +284,83 @@ var neverStartedJobStatus = []string{
 	JobStatusManual,
 }
 
+// Actions which PlayOrRetryJob performs on a found job
+const (
+	JobActionPlay  = "play"
+	JobActionRetry = "retry"
+)
+
 // PlayOrRetryJob play a job or retries a job for given criteria
 // Affected job will be tracker by a watcher until finished status
 // Affected job will be places in job list (Service.jobs) forever
-func (c *Service) PlayOrRetryJob(projectID int, environment string, ref string) (*wrappedGitLab.Job, error) {
+// The user is recorded as the one who started the job
+// It returns the started job, a retry creates a new job instead of the found one
+func (c *Service) PlayOrRetryJob(ctx context.Context, projectID int, environment string, ref string, user *ProjectUser) (*wrappedGitLab.Job, error) {
+	_, runJob, _, err := c.playOrRetryJob(ctx, projectID, environment, ref, "", user)
+	return runJob, err
+}
+
+// JobStart keeps who and when started a job from the dashboard
+// User is nil when OAuth is disabled
+type JobStart struct {
+	JobID     int          `json:"jobId"`
+	User      *ProjectUser `json:"user"`
+	StartedAt time.Time    `json:"startedAt"`
+}
+
+// GetJobStart returns the start of the tracked job
+func (c *Service) GetJobStart(environment string, projectID int) (*JobStart, bool) {
+	c.jobsMtx.RLock()
+	defer c.jobsMtx.RUnlock()
+
+	job, ok := c.jobs[environment][projectID]
+	if !ok {
+		return nil, false
+	}
+	start, ok := c.starts[environment][projectID]
+	if !ok || start.JobID != job.ID {
+		return nil, false
+	}
+
+	return start, true
+}
+
+// playOrRetryJob does the same as PlayOrRetryJob
+// but also returns the started job and the performed action
+// If `sha` is given the job is searched only in pipelines of this commit
+func (c *Service) playOrRetryJob(ctx context.Context, projectID int, environment string, ref string, sha string, user *ProjectUser) (job *wrappedGitLab.Job, runJob *wrappedGitLab.Job, action string, err error) {
+	ctx, span := startSpan(ctx, "Service.PlayOrRetryJob",
+		projectIDKey.Int(projectID),
+		environmentKey.String(environment),
+		refKey.String(ref),
+	)
+	defer func() { endSpan(span, err) }()
+
 	if utils.StringsContainString(c.protectedEnvironments, environment) {
-		return nil, DeniedForProtectedEnvironment
+		return nil, nil, "", DeniedForProtectedEnvironment
+	}
+	if _, ok := c.GetEnvironmentLock(environment); ok {
+		return nil, nil, "", EnvironmentIsLocked
 	}
 
-	job, err := c.findJobForGivenCriteriaRecursive(projectID, environment, ref)
+	job, err = c.findJobForGivenCriteriaRecursive(ctx, projectID, environment, ref, sha)
 	if err != nil {
-		return nil, err
+		return nil, nil, "", err
 	}
 
-	// Skip if it's already running
-	if utils.StringsContainString(inProcessJobStatus, job.Status) {
-		return nil, errors.New("job already running")
+	action, err = getJobAction(job)
+	if err != nil {
+		return nil, nil, "", err
 	}
 
 	// Play or Retry jobs depends on current status
-	var runJob *wrappedGitLab.Job
-	if utils.StringsContainString(neverStartedJobStatus, job.Status) {
-		runJob, _, err = c.git.Jobs.PlayJob(projectID, job.ID)
+	if action == JobActionPlay {
+		runJob, _, err = c.git.Jobs.PlayJob(projectID, job.ID, wrappedGitLab.WithContext(ctx))
 	} else {
-		runJob, _, err = c.git.Jobs.RetryJob(projectID, job.ID)
+		runJob, _, err = c.git.Jobs.RetryJob(projectID, job.ID, wrappedGitLab.WithContext(ctx))
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, "", err
 	}
 
 	// Store job to the job list
@@ -224,46 +369,138 @@ func (c *Service) PlayOrRetryJob(projectID int, environment string, ref string)
 		c.jobs[environment] = map[int]*wrappedGitLab.Job{}
 	}
 	c.jobs[environment][projectID] = runJob
+	if _, ok := c.starts[environment]; !ok {
+		c.starts[environment] = map[int]*JobStart{}
+	}
+	c.starts[environment][projectID] = &JobStart{
+		JobID:     runJob.ID,
+		User:      user,
+		StartedAt: time.Now(),
+	}
 	c.jobsMtx.Unlock()
 
+	c.emitJobEvent(JobEventStarted, environment, projectID, runJob, user)
+
 	// Run watcher
-	c.runJobWatcher(environment, projectID, runJob)
+	c.runJobWatcher(ctx, environment, projectID, runJob, user)
+
+	return job, runJob, action, nil
+}
+
+// getJobAction decides whether the job should be played or retried
+// Running jobs cannot be touched
+func getJobAction(job *wrappedGitLab.Job) (string, error) {
+	if utils.StringsContainString(inProcessJobStatus, job.Status) {
+		return "", JobIsAlreadyRunning
+	}
+	if utils.StringsContainString(neverStartedJobStatus, job.Status) {
+		return JobActionPlay, nil
+	}
 
-	return job, nil
+	return JobActionRetry, nil
 }
 
+const (
+	jobWatcherInterval = time.Second * 3
+	// The job keeps running when GitLab is unavailable for a while
+	// so the watcher gives up only after a minute of failures
+	jobWatcherMaxFailures = 20
+)
+
 // runJobWatcher checks the job and replace in jobs map in case of status changing
-// It checks every 3 seconds
+// It checks every watchInterval
 // When status became on of finished we stop the watcher
-func (c *Service) runJobWatcher(environment string, projectId int, runJob *wrappedGitLab.Job) {
+// The watcher is stopped as well when the job is canceled or replaced from the dashboard
+// The watcher outlives the request, so it has its own trace linked to the request one
+func (c *Service) runJobWatcher(ctx context.Context, environment string, projectId int, runJob *wrappedGitLab.Job, user *ProjectUser) {
+	c.backgroundWg.Add(1)
 	go func() {
+		defer c.backgroundWg.Done()
+		ctx, span := startLinkedSpan(c.background, ctx, "Service.watchJob",
+			projectIDKey.Int(projectId),
+			environmentKey.String(environment),
+		)
+		defer span.End()
+
+		failures := 0
 		for {
-			watchedJob, _, err := c.git.Jobs.GetJob(projectId, runJob.ID)
-			if err != nil {
-				log.Println(err)
+			if !c.isJobWatched(environment, projectId, runJob.ID) {
 				return
 			}
 
+			watchedJob, _, err := c.git.Jobs.GetJob(projectId, runJob.ID, wrappedGitLab.WithContext(ctx))
+			if err != nil {
+				// The service is stopped
+				if ctx.Err() != nil {
+					return
+				}
+				failures++
+				log.WithContext(ctx).Errorf("cannot check job %d of project %d: %v", runJob.ID, projectId, err)
+				if failures >= jobWatcherMaxFailures {
+					endSpan(span, err)
+					return
+				}
+				if !sleep(ctx, c.watchInterval) {
+					return
+				}
+				continue
+			}
+			failures = 0
+
 			// If the jobs changed the status we need to replace with a new one
 			if runJob.Status != watchedJob.Status {
+				statusChanged := false
 				c.jobsMtx.Lock()
-				c.jobs[environment][projectId] = watchedJob
+				if trackedJob := c.jobs[environment][projectId]; trackedJob.ID == runJob.ID {
+					// The job canceled from the dashboard already has the final status
+					statusChanged = trackedJob.Status != watchedJob.Status
+					c.jobs[environment][projectId] = watchedJob
+				}
 				c.jobsMtx.Unlock()
+
+				if event, ok := getJobEvent(watchedJob.Status); ok && statusChanged {
+					c.emitJobEvent(event, environment, projectId, watchedJob, user)
+				}
 			}
 			if utils.StringsContainString(finishedJobStatus, watchedJob.Status) {
 				return
 			}
-			<-time.After(time.Second * 3)
+			if !sleep(ctx, c.watchInterval) {
+				return
+			}
 		}
 	}()
 }
 
-func (c *Service) GetDeployment(projectId, deploymentId int) (*Deployment, error) {
-	deployment, _, err := c.git.Deployments.GetProjectDeployment(
+// sleep waits for the duration, it returns false if the context is done earlier
+func sleep(ctx context.Context, duration time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(duration):
+		return true
+	}
+}
+
+// isJobWatched checks if the job is still tracked and isn't finished
+func (c *Service) isJobWatched(environment string, projectID int, jobID int) bool {
+	c.jobsMtx.RLock()
+	defer c.jobsMtx.RUnlock()
+
+	job, ok := c.jobs[environment][projectID]
+	return ok && job.ID == jobID && !utils.StringsContainString(finishedJobStatus, job.Status)
+}
+
+func (c *Service) GetDeployment(ctx context.Context, projectId, deploymentId int) (deployment *Deployment, err error) {
+	ctx, span := startSpan(ctx, "Service.GetDeployment", projectIDKey.Int(projectId))
+	defer func() { endSpan(span, err) }()
+
+	remoteDeployment, _, err := c.git.Deployments.GetProjectDeployment(
 		projectId,
 		deploymentId,
+		wrappedGitLab.WithContext(ctx),
 	)
-	return convertWrappedDeployment(deployment), err
+	return convertWrappedDeployment(remoteDeployment), err
 }
 
 func (c *Service) GetJob(environment string, projectID int) (*wrappedGitLab.Job, bool) {
@@ -278,37 +515,17 @@ func (c *Service) GetJob(environment string, projectID int) (*wrappedGitLab.Job,
 	return job, ok
 }
 
-func (c *Service) ListProjectDeployments(environment string, projectID int) ([]*Deployment, error) {
-	deployments, _, err := c.git.Deployments.ListProjectDeployments(
-		projectID,
-		&wrappedGitLab.ListProjectDeploymentsOptions{
-			Environment: wrappedGitLab.String(environment),
-			OrderBy:     wrappedGitLab.String("id"),
-			Sort:        wrappedGitLab.String("desc"),
-			Status:      wrappedGitLab.String(JobStatusSuccess),
-			ListOptions: wrappedGitLab.ListOptions{
-				PerPage: 5,
-			},
-		},
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	wrappedDeployments := make([]*Deployment, len(deployments))
-	for i, deployment := range deployments {
-		wrappedDeployments[i] = convertWrappedDeployment(deployment)
-	}
-	return wrappedDeployments, nil
-}
-
 // UpdateEnvironments updates environments cache
-func (c *Service) UpdateEnvironments(projectIds []int) error {
+func (c *Service) UpdateEnvironments(ctx context.Context, projectIds []int) (err error) {
+	ctx, span := startSpan(ctx, "Service.UpdateEnvironments")
+	defer func() { endSpan(span, err) }()
+
 	environments := map[string]*Environment{}
 	for _, projectId := range projectIds {
 		remoteEnvironments, _, err := c.git.Environments.ListEnvironments(
 			projectId,
 			&wrappedGitLab.ListEnvironmentsOptions{PerPage: 100},
+			wrappedGitLab.WithContext(ctx),
 		)
 		if err != nil {
 			return err
@@ -325,7 +542,7 @@ func (c *Service) UpdateEnvironments(projectIds []int) error {
 
 			// We need to fetch env by ID to get last deployment
 			// because ListEnvironments doesn't return it
-			remoteEnvironment, _, err = c.git.Environments.GetEnvironment(projectId, remoteEnvironment.ID)
+			remoteEnvironment, _, err = c.git.Environments.GetEnvironment(projectId, remoteEnvironment.ID, wrappedGitLab.WithContext(ctx))
 			fmt.Println(remoteEnvironment.Name)
 			fmt.Println(projectId)
 			if err != nil {
@@ -344,13 +561,40 @@ func (c *Service) UpdateEnvironments(projectIds []int) error {
 
 		}
 	}
+	c.updateBehindBy(ctx, environments)
+
 	c.environmentsMtx.Lock()
 	c.environments = environments
+	c.environmentsUpdatedAt = time.Now()
 	c.environmentsMtx.Unlock()
 
 	return nil
 }
 
+// getEnvironment returns cached environment by name
+func (c *Service) getEnvironment(name string) (*Environment, bool) {
+	c.environmentsMtx.RLock()
+	defer c.environmentsMtx.RUnlock()
+
+	environment, ok := c.environments[name]
+	return environment, ok
+}
+
+// getProject returns cached project of the environment
+func (c *Service) getProject(environment string, projectID int) (*Project, bool) {
+	env, ok := c.getEnvironment(environment)
+	if !ok {
+		return nil, false
+	}
+	for _, project := range env.Projects {
+		if project != nil && project.ID == projectID {
+			return project, true
+		}
+	}
+
+	return nil, false
+}
+
 // GetEnvironments returns cached environments by UpdateEnvironments function
 func (c *Service) GetEnvironments() []*Environment {
 	var environments []*Environment
@@ -382,8 +626,15 @@ func (c *Service) GetJobs() map[string]map[int]*wrappedGitLab.Job {
 	return jobs
 }
 
-func (c *Service) findJobForGivenCriteriaRecursive(projectId int, environment string, ref string) (*wrappedGitLab.Job, error) {
-	job, err := c.findJobForGivenCriteria(projectId, environment, ref, 1, 0)
+func (c *Service) findJobForGivenCriteriaRecursive(ctx context.Context, projectId int, environment string, ref string, sha string) (job *wrappedGitLab.Job, err error) {
+	ctx, span := startSpan(ctx, "Service.findJobForGivenCriteriaRecursive",
+		projectIDKey.Int(projectId),
+		environmentKey.String(environment),
+		refKey.String(ref),
+	)
+	defer func() { endSpan(span, err) }()
+
+	job, err = c.findJobForGivenCriteria(ctx, projectId, environment, ref, sha, 1, 0)
 
 	// If we didn't find a job
 	// Let's try to find it in previous pipelines
@@ -394,7 +645,7 @@ func (c *Service) findJobForGivenCriteriaRecursive(projectId int, environment st
 		perPage := 10
 		for page <= limit {
 			limit -= 1
-			job, err := c.findJobForGivenCriteria(projectId, environment, ref, perPage, page)
+			job, err := c.findJobForGivenCriteria(ctx, projectId, environment, ref, sha, perPage, page)
 			if err == JobNotFound {
 				page += 1
 				continue
@@ -407,16 +658,21 @@ func (c *Service) findJobForGivenCriteriaRecursive(projectId int, environment st
 	return job, err
 }
 
-func (c *Service) findJobForGivenCriteria(projectId int, environment string, ref string, perPage int, page int) (*wrappedGitLab.Job, error) {
+func (c *Service) findJobForGivenCriteria(ctx context.Context, projectId int, environment string, ref string, sha string, perPage int, page int) (*wrappedGitLab.Job, error) {
+	var shaFilter *string
+	if sha != "" {
+		shaFilter = &sha
+	}
 	pipelines, _, err := c.git.Pipelines.ListProjectPipelines(projectId, &wrappedGitLab.ListProjectPipelinesOptions{
 		Ref: &ref,
+		SHA: shaFilter,
 		ListOptions: wrappedGitLab.ListOptions{
 			PerPage: perPage,
 			Page:    page,
 		},
 		OrderBy: wrappedGitLab.String("id"),
 		Sort:    wrappedGitLab.String("desc"),
-	})
+	}, wrappedGitLab.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -430,7 +686,7 @@ func (c *Service) findJobForGivenCriteria(projectId int, environment string, ref
 				wrappedGitLab.Created,
 				wrappedGitLab.Failed,
 			},
-		})
+		}, wrappedGitLab.WithContext(ctx))
 		if err != nil {
 			return nil, err
 		}
@@ -447,48 +703,21 @@ func (c *Service) findJobForGivenCriteria(projectId int, environment string, ref
 	return nil, JobNotFound
 }
 
-func (c *Service) PlayOrRetryJobsWithQuery(environment string, query string) error {
-	count := 0
-	for _, projectId := range c.projectIDs {
-		branches, _, err := c.git.Branches.ListBranches(projectId, &wrappedGitLab.ListBranchesOptions{
-			ListOptions: wrappedGitLab.ListOptions{PerPage: 1},
-			Search:      wrappedGitLab.String(fmt.Sprintf("^%s", query)),
-		})
-		if err != nil {
-			return err
-		}
-		// We did't find a branch go next
-		if len(branches) == 0 {
-			continue
-		}
-
-		_, err = c.PlayOrRetryJob(projectId, environment, branches[0].Name)
-
-		// It's a normal behavior If job is not ready
-		// We just skip this project for query deployment
-		// If jobs not found we skipp it as well
-		// Because project couldn't have environment
-		if err != nil && err != JobIsNotReady && err != JobNotFound {
-			return err
-		}
-		if err == nil {
-			count += 1
-		}
-	}
-	if count == 0 {
-		return errors.New("nothing was run")
-	}
-
-	return nil
-}
-
 // NewClient creates a new Service
-func NewClient(gitLabToken, gitLabBaseURL string, protectedEnvironments []string, projectIDs []int) (*Service, error) {
-	git, err := wrappedGitLab.NewClient(gitLabToken, wrappedGitLab.WithBaseURL(gitLabBaseURL))
+// `httpClient` is used for all GitLab requests, its timeout is a deadline of every GitLab request
+func NewClient(gitLabToken, gitLabBaseURL string, protectedEnvironments []string, projectIDs []int, httpClient *http.Client) (*Service, error) {
+	git, err := wrappedGitLab.NewClient(gitLabToken, clientOptions(gitLabBaseURL, httpClient)...)
 	if err != nil {
 		return nil, err
 	}
 
+	return NewService(NewAPI(git), protectedEnvironments, projectIDs), nil
+}
+
+// NewService creates a new Service which uses given GitLab API
+func NewService(git *API, protectedEnvironments []string, projectIDs []int) *Service {
+	background, stopBackground := context.WithCancel(context.Background())
+
 	return &Service{
 		git:                     git,
 		environments:            map[string]*Environment{},
@@ -497,8 +726,38 @@ func NewClient(gitLabToken, gitLabBaseURL string, protectedEnvironments []string
 		branchesMtx:             sync.RWMutex{},
 		jobs:                    map[string]map[int]*wrappedGitLab.Job{},
 		jobsMtx:                 sync.RWMutex{},
+		snapshots:               map[string]map[string]*Snapshot{},
+		restores:                map[string]*SnapshotRestore{},
+		snapshotsMtx:            sync.RWMutex{},
+		jobListenersMtx:         sync.RWMutex{},
+		locks:                   map[string]*EnvironmentLock{},
+		locksMtx:                sync.RWMutex{},
+		starts:                  map[string]map[int]*JobStart{},
+		cancellations:           map[string]map[int]*JobCancellation{},
+		behindBy:                map[string]int{},
+		behindByMtx:             sync.Mutex{},
 		protectedEnvironments:   protectedEnvironments,
 		projectIDs:              projectIDs,
 		jobRecursiveSearchLimit: 10,
-	}, nil
+		background:              background,
+		stopBackground:          stopBackground,
+		watchInterval:           jobWatcherInterval,
+	}
+}
+
+// RunInBackground runs the task outside of requests, i.e. job listeners which shouldn't block the caller
+// The task's context is canceled by Stop, which waits for the task to finish
+func (c *Service) RunInBackground(task func(ctx context.Context)) {
+	c.backgroundWg.Add(1)
+	go func() {
+		defer c.backgroundWg.Done()
+		task(c.background)
+	}()
+}
+
+// Stop stops job watchers, snapshot restores and background tasks and waits for them
+// Stopped jobs keep running in GitLab, but the dashboard doesn't track them anymore
+func (c *Service) Stop() {
+	c.stopBackground()
+	c.backgroundWg.Wait()
 }
@@ -0,0 +1,425 @@
+package gitlab
+
+import (
+	"context"
+	"gitlab-environment-dashboard/server/pkg/gitlab/gitlabtest"
+	"sort"
+	"testing"
+	"time"
+)
+
+// newTestService creates a Service which talks to a new fake GitLab
+// "production" is a protected environment
+func newTestService(t *testing.T, projectIDs ...int) (*Service, *gitlabtest.Server) {
+	server := gitlabtest.NewServer()
+	t.Cleanup(server.Close)
+
+	service, err := NewClient("token", server.URL, []string{"production"}, projectIDs, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stop job watchers before the server is closed
+	t.Cleanup(service.Stop)
+	// Watched jobs are checked often to not slow tests down
+	service.watchInterval = 10 * time.Millisecond
+
+	return service, server
+}
+
+func TestUpdateEnvironments(t *testing.T) {
+	service, server := newTestService(t, 1, 2)
+	server.AddProject(1, "api")
+	server.AddEnvironment(1, "qa", "master", "a1")
+	server.AddEnvironment(1, "production", "master", "a1")
+	server.AddEnvironment(1, "review", "", "")
+	server.AddProject(2, "web")
+	server.AddEnvironment(2, "qa", "feature/x", "b1")
+
+	err := service.UpdateEnvironments(context.Background(), []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	environments := service.GetEnvironments()
+	sort.Slice(environments, func(i, j int) bool {
+		return environments[i].Name < environments[j].Name
+	})
+	if len(environments) != 2 {
+		t.Fatalf("UpdateEnvironments() got %d environments, want qa and review", len(environments))
+	}
+
+	qa := environments[0]
+	if qa.Name != "qa" || len(qa.Projects) != 2 {
+		t.Fatalf("UpdateEnvironments() got %s with %d projects, want qa with 2 projects", qa.Name, len(qa.Projects))
+	}
+	want := map[int]string{1: "master", 2: "feature/x"}
+	for _, project := range qa.Projects {
+		if project.LastDeployment == nil || project.LastDeployment.Ref != want[project.ID] {
+			t.Errorf("UpdateEnvironments() project %d of qa has deployment %+v, want ref %s", project.ID, project.LastDeployment, want[project.ID])
+		}
+	}
+
+	review := environments[1]
+	if review.Name != "review" || len(review.Projects) != 1 || review.Projects[0].LastDeployment != nil {
+		t.Errorf("UpdateEnvironments() got %+v, want review without deployments", review)
+	}
+}
+
+func TestUpdateBranches(t *testing.T) {
+	service, server := newTestService(t, 1, 2)
+	server.AddProject(1, "api")
+	server.AddBranch(1, "master", "a1", time.Now())
+
+	// Project 2 doesn't exist yet, so the update fails but project 1 is cached
+	if err := service.UpdateBranches(context.Background(), []int{1, 2}); err == nil {
+		t.Fatal("UpdateBranches() with a missing project succeeded, want error")
+	}
+	if branches, _ := service.GetBranches(1); len(branches) != 1 {
+		t.Errorf("GetBranches() after a partial update = %v, want master", branches)
+	}
+	if _, updatedAt := service.LastUpdates(); !updatedAt.IsZero() {
+		t.Errorf("LastUpdates() after a failed update = %v, want zero time", updatedAt)
+	}
+
+	server.AddProject(2, "web")
+	if err := service.UpdateBranches(context.Background(), []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	_, updatedAt := service.LastUpdates()
+
+	// Failed projects keep their branches and the update time isn't changed
+	server.RevokeToken()
+	if err := service.UpdateBranches(context.Background(), []int{1, 2}); err == nil {
+		t.Fatal("UpdateBranches() with a revoked token succeeded, want error")
+	}
+	if branches, _ := service.GetBranches(1); len(branches) != 1 {
+		t.Errorf("GetBranches() after a failed update = %v, want master", branches)
+	}
+	if _, failedAt := service.LastUpdates(); !failedAt.Equal(updatedAt) {
+		t.Errorf("LastUpdates() after a failed update = %v, want %v", failedAt, updatedAt)
+	}
+}
+
+func TestFindJobForGivenCriteriaRecursive(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup fills project 1 and returns ID of the expected job
+		setup   func(server *gitlabtest.Server) int
+		ref     string
+		sha     string
+		wantErr error
+	}{
+		{
+			name: "lastPipeline",
+			setup: func(server *gitlabtest.Server) int {
+				server.AddJob(1, server.AddPipeline(1, "master", "s1"), "qa", JobStatusSuccess)
+				return server.AddJob(1, server.AddPipeline(1, "master", "s2"), "qa", JobStatusManual)
+			},
+			ref: "master",
+		},
+		{
+			name: "previousPipeline",
+			setup: func(server *gitlabtest.Server) int {
+				jobID := server.AddJob(1, server.AddPipeline(1, "master", "s1"), "qa", JobStatusSuccess)
+				// A scheduled pipeline without environments
+				server.AddJob(1, server.AddPipeline(1, "master", "s2"), "tests", JobStatusSuccess)
+				return jobID
+			},
+			ref: "master",
+		},
+		{
+			name: "bySHA",
+			setup: func(server *gitlabtest.Server) int {
+				jobID := server.AddJob(1, server.AddPipeline(1, "master", "s1"), "qa", JobStatusSuccess)
+				server.AddJob(1, server.AddPipeline(1, "master", "s2"), "qa", JobStatusSuccess)
+				return jobID
+			},
+			ref: "master",
+			sha: "s1",
+		},
+		{
+			name: "notReady",
+			setup: func(server *gitlabtest.Server) int {
+				server.AddJob(1, server.AddPipeline(1, "master", "s1"), "qa", JobStatusCreated)
+				return 0
+			},
+			ref:     "master",
+			wantErr: JobIsNotReady,
+		},
+		{
+			name: "otherEnvironment",
+			setup: func(server *gitlabtest.Server) int {
+				server.AddJob(1, server.AddPipeline(1, "master", "s1"), "staging", JobStatusSuccess)
+				return 0
+			},
+			ref:     "master",
+			wantErr: JobNotFound,
+		},
+		{
+			name: "otherRef",
+			setup: func(server *gitlabtest.Server) int {
+				server.AddJob(1, server.AddPipeline(1, "develop", "s1"), "qa", JobStatusSuccess)
+				return 0
+			},
+			ref:     "master",
+			wantErr: JobNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, server := newTestService(t, 1)
+			server.AddProject(1, "api")
+			wantJobID := tt.setup(server)
+
+			job, err := service.findJobForGivenCriteriaRecursive(context.Background(), 1, "qa", tt.ref, tt.sha)
+			if err != tt.wantErr {
+				t.Fatalf("findJobForGivenCriteriaRecursive() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && job.ID != wantJobID {
+				t.Errorf("findJobForGivenCriteriaRecursive() job = %d, want %d", job.ID, wantJobID)
+			}
+		})
+	}
+}
+
+func TestPlayOrRetryJob(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		environment string
+		locked      bool
+		// wantSameJob is true when the job is played and false when it's retried
+		wantSameJob bool
+		wantErr     error
+	}{
+		{"play", JobStatusManual, "qa", false, true, nil},
+		{"retry", JobStatusSuccess, "qa", false, false, nil},
+		{"retryFailed", JobStatusFailed, "qa", false, false, nil},
+		// Running jobs are not searched, so the job of a running pipeline is not found
+		{"running", JobStatusRunning, "qa", false, false, JobNotFound},
+		{"protected", JobStatusManual, "production", false, false, DeniedForProtectedEnvironment},
+		{"locked", JobStatusManual, "qa", true, false, EnvironmentIsLocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, server := newTestService(t, 1)
+			server.AddProject(1, "api")
+			jobID := server.AddJob(1, server.AddPipeline(1, "master", "s1"), tt.environment, tt.status)
+			if tt.locked {
+				if _, err := service.LockEnvironment(tt.environment, nil, "testing"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			user := &ProjectUser{Username: "jdoe", Token: "ci"}
+			job, err := service.PlayOrRetryJob(context.Background(), 1, tt.environment, "master", user)
+			if err != tt.wantErr {
+				t.Fatalf("PlayOrRetryJob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if job, _ := service.GetJob(tt.environment, 1); job != nil {
+					t.Error("PlayOrRetryJob() failed but the job is tracked")
+				}
+				return
+			}
+			trackedJob, ok := service.GetJob(tt.environment, 1)
+			if !ok {
+				t.Fatal("PlayOrRetryJob() doesn't track the started job")
+			}
+			if job.ID != trackedJob.ID {
+				t.Errorf("PlayOrRetryJob() returned job %d, want the started job %d", job.ID, trackedJob.ID)
+			}
+			if (trackedJob.ID == jobID) != tt.wantSameJob {
+				t.Errorf("PlayOrRetryJob() tracks job %d, found job is %d, want the same job: %v", trackedJob.ID, jobID, tt.wantSameJob)
+			}
+			remoteJob, _ := server.Job(1, trackedJob.ID)
+			if remoteJob.Status != JobStatusPending {
+				t.Errorf("PlayOrRetryJob() started job has status %s, want %s", remoteJob.Status, JobStatusPending)
+			}
+			if start, ok := service.GetJobStart(tt.environment, 1); !ok || start.JobID != trackedJob.ID || start.User != user {
+				t.Errorf("PlayOrRetryJob() start = %+v, want job %d started by the user", start, trackedJob.ID)
+			}
+		})
+	}
+}
+
+func TestPlayOrRetryJobsWithQuery(t *testing.T) {
+	service, server := newTestService(t, 1, 2, 3, 4, 5)
+	now := time.Now()
+
+	// Played, the newest matched branch wins
+	server.AddProject(1, "api")
+	server.AddBranch(1, "release-42", "a1", now.Add(-time.Hour))
+	server.AddBranch(1, "release-42-hotfix", "a2", now)
+	server.AddJob(1, server.AddPipeline(1, "release-42", "a1"), "qa", JobStatusSuccess)
+	server.AddJob(1, server.AddPipeline(1, "release-42-hotfix", "a2"), "qa", JobStatusManual)
+	// Retried
+	server.AddProject(2, "web")
+	server.AddBranch(2, "release-42", "b1", now)
+	server.AddJob(2, server.AddPipeline(2, "release-42", "b1"), "qa", JobStatusSuccess)
+	// No branch
+	server.AddProject(3, "worker")
+	server.AddBranch(3, "master", "c1", now)
+	// No job
+	server.AddProject(4, "docs")
+	server.AddBranch(4, "release-42", "d1", now)
+	server.AddJob(4, server.AddPipeline(4, "release-42", "d1"), "tests", JobStatusSuccess)
+	// Fallback
+	server.AddProject(5, "admin")
+	server.AddBranch(5, "master", "e1", now)
+	server.AddJob(5, server.AddPipeline(5, "master", "e1"), "qa", JobStatusManual)
+
+	results, err := service.PlayOrRetryJobsWithQuery(context.Background(), "qa", QueryDeployOptions{
+		Query:        "release-42",
+		FallbackRefs: map[int]string{5: "master"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		branch   string
+		fallback bool
+		outcome  string
+	}{
+		{"release-42-hotfix", false, QueryDeployPlayed},
+		{"release-42", false, QueryDeployRetried},
+		{"", false, QueryDeploySkippedNoBranch},
+		{"release-42", false, QueryDeploySkippedNoJob},
+		{"master", true, QueryDeployPlayed},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("PlayOrRetryJobsWithQuery() got %d results, want %d", len(results), len(want))
+	}
+	for i, result := range results {
+		if result.Branch != want[i].branch || result.Fallback != want[i].fallback || result.Outcome != want[i].outcome {
+			t.Errorf("PlayOrRetryJobsWithQuery() project %d = %+v, want %+v", result.ProjectID, *result, want[i])
+		}
+		if _, ok := service.GetJob("qa", result.ProjectID); ok != (result.Job != nil) {
+			t.Errorf("PlayOrRetryJobsWithQuery() project %d has job %v but tracked is %v", result.ProjectID, result.Job, ok)
+		}
+	}
+	if results.Deployed() != 3 || results.Failed() != 0 {
+		t.Errorf("PlayOrRetryJobsWithQuery() deployed %d and failed %d, want 3 and 0", results.Deployed(), results.Failed())
+	}
+
+	// Protected environments are not touched at all
+	_, err = service.PlayOrRetryJobsWithQuery(context.Background(), "production", QueryDeployOptions{Query: "release-42"}, nil)
+	if err != DeniedForProtectedEnvironment {
+		t.Errorf("PlayOrRetryJobsWithQuery() for a protected environment error = %v", err)
+	}
+}
+
+func TestPreviewJobsWithQuery(t *testing.T) {
+	service, server := newTestService(t, 1, 2, 3, 4, 5)
+	now := time.Now()
+
+	// Played, the newest matched branch wins
+	server.AddProject(1, "api")
+	server.AddBranch(1, "release-42", "a1", now.Add(-time.Hour))
+	server.AddBranch(1, "release-42-hotfix", "a2", now)
+	playedJobID := server.AddJob(1, server.AddPipeline(1, "release-42-hotfix", "a2"), "qa", JobStatusManual)
+	// Retried
+	server.AddProject(2, "web")
+	server.AddBranch(2, "release-42", "b1", now)
+	retriedJobID := server.AddJob(2, server.AddPipeline(2, "release-42", "b1"), "qa", JobStatusSuccess)
+	// No branch
+	server.AddProject(3, "worker")
+	server.AddBranch(3, "master", "c1", now)
+	// No job
+	server.AddProject(4, "docs")
+	server.AddBranch(4, "release-42", "d1", now)
+	server.AddJob(4, server.AddPipeline(4, "release-42", "d1"), "tests", JobStatusSuccess)
+	// Fallback
+	server.AddProject(5, "admin")
+	server.AddBranch(5, "master", "e1", now)
+	fallbackJobID := server.AddJob(5, server.AddPipeline(5, "master", "e1"), "qa", JobStatusManual)
+
+	previews, err := service.PreviewJobsWithQuery(context.Background(), "qa", QueryDeployOptions{
+		Query:        "release-42",
+		FallbackRefs: map[int]string{5: "master"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []QueryDeployPreview{
+		{ProjectID: 1, Branch: "release-42-hotfix", JobID: playedJobID, Action: JobActionPlay},
+		{ProjectID: 2, Branch: "release-42", JobID: retriedJobID, Action: JobActionRetry},
+		{ProjectID: 3, SkipReason: BranchNotFound.Error()},
+		{ProjectID: 4, Branch: "release-42", SkipReason: JobNotFound.Error()},
+		{ProjectID: 5, Branch: "master", Fallback: true, JobID: fallbackJobID, Action: JobActionPlay},
+	}
+	if len(previews) != len(want) {
+		t.Fatalf("PreviewJobsWithQuery() got %d previews, want %d", len(previews), len(want))
+	}
+	for i, preview := range previews {
+		if preview.ProjectID != want[i].ProjectID || preview.Branch != want[i].Branch || preview.Fallback != want[i].Fallback ||
+			preview.JobID != want[i].JobID || preview.Action != want[i].Action || preview.SkipReason != want[i].SkipReason {
+			t.Errorf("PreviewJobsWithQuery() project %d = %+v, want %+v", preview.ProjectID, *preview, want[i])
+		}
+	}
+
+	// Nothing is played, retried or tracked
+	jobs := []struct {
+		projectID int
+		jobID     int
+		status    string
+	}{
+		{1, playedJobID, JobStatusManual},
+		{2, retriedJobID, JobStatusSuccess},
+		{5, fallbackJobID, JobStatusManual},
+	}
+	for _, job := range jobs {
+		if remoteJob, _ := server.Job(job.projectID, job.jobID); remoteJob.Status != job.status {
+			t.Errorf("PreviewJobsWithQuery() changed job %d of project %d to %s", job.jobID, job.projectID, remoteJob.Status)
+		}
+		if _, ok := service.GetJob("qa", job.projectID); ok {
+			t.Errorf("PreviewJobsWithQuery() tracks a job of project %d", job.projectID)
+		}
+	}
+}
+
+func TestJobWatcherEvents(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    string
+		wantEvent string
+	}{
+		{"success", JobStatusSuccess, JobEventSucceeded},
+		{"failed", JobStatusFailed, JobEventFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, server := newTestService(t, 1)
+			server.AddProject(1, "api")
+			server.AddJob(1, server.AddPipeline(1, "master", "a1"), "qa", JobStatusManual)
+			events := make(chan JobEvent, 10)
+			service.AddJobListener(func(event JobEvent) {
+				events <- event
+			})
+
+			user := &ProjectUser{Username: "jdoe"}
+			job, err := service.PlayOrRetryJob(context.Background(), 1, "qa", "master", user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event := <-events; event.Event != JobEventStarted {
+				t.Fatalf("first event = %s, want %s", event.Event, JobEventStarted)
+			}
+			server.SetJobStatus(1, job.ID, tt.status)
+
+			select {
+			case event := <-events:
+				if event.Event != tt.wantEvent || event.Job.ID != job.ID || event.Job.Status != tt.status || event.User != user {
+					t.Errorf("event = %s of job %d with status %s, want %s of job %d with status %s by the user",
+						event.Event, event.Job.ID, event.Job.Status, tt.wantEvent, job.ID, tt.status)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%s event was not emitted", tt.wantEvent)
+			}
+			// The watcher stops after the job is finished
+			waitForWatchers(t, service)
+		})
+	}
+}
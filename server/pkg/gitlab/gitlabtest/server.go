@@ -0,0 +1,632 @@
+/*
+Package gitlabtest provides an in-process fake GitLab for tests
+
+The fake keeps projects, environments, deployments, pipelines, jobs, branches and commits in memory
+and serves the part of GitLab API v4 which gitlab.Service uses.
+*/
+package gitlabtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake GitLab served by httptest.Server
+// Use Server.URL as a base URL of a GitLab client
+type Server struct {
+	*httptest.Server
+
+	mtx      sync.Mutex
+	lastID   int
+	projects map[int]*project
+	// Members of groups by full paths of groups, including inherited ones
+	groupMembers map[string][]*wrappedGitLab.GroupMember
+	// All requests are unauthorized when the token is revoked
+	tokenRevoked bool
+}
+
+type project struct {
+	project      *wrappedGitLab.Project
+	environments []*wrappedGitLab.Environment
+	deployments  []*wrappedGitLab.Deployment
+	branches     []*wrappedGitLab.Branch
+	commits      map[string]*wrappedGitLab.Commit
+	pipelines    []*wrappedGitLab.PipelineInfo
+	jobs         []*wrappedGitLab.Job
+	traces       map[int]string
+}
+
+// NewServer starts a fake GitLab without projects, it should be closed by Close
+func NewServer() *Server {
+	s := &Server{projects: map[int]*project{}, groupMembers: map[string][]*wrappedGitLab.GroupMember{}}
+
+	r := mux.NewRouter()
+	r.Use(s.authorize)
+	r.Methods("GET").Path("/api/v4/user").HandlerFunc(s.currentUser)
+	r.Methods("GET").Path("/api/v4/groups/{group:.+}/members/all").HandlerFunc(s.listAllGroupMembers)
+	api := r.PathPrefix("/api/v4/projects/{projectID:[0-9]+}").Subrouter()
+	api.Methods("GET").Path("/environments").HandlerFunc(s.listEnvironments)
+	api.Methods("GET").Path("/environments/{id:[0-9]+}").HandlerFunc(s.getEnvironment)
+	api.Methods("GET").Path("/deployments").HandlerFunc(s.listDeployments)
+	api.Methods("GET").Path("/repository/branches").HandlerFunc(s.listBranches)
+	api.Methods("GET").Path("/repository/compare").HandlerFunc(s.compare)
+	api.Methods("GET").Path("/pipelines").HandlerFunc(s.listPipelines)
+	api.Methods("GET").Path("/pipelines/{id:[0-9]+}/jobs").HandlerFunc(s.listPipelineJobs)
+	api.Methods("GET").Path("/jobs/{id:[0-9]+}").HandlerFunc(s.getJob)
+	api.Methods("GET").Path("/jobs/{id:[0-9]+}/trace").HandlerFunc(s.getTrace)
+	api.Methods("POST").Path("/jobs/{id:[0-9]+}/play").HandlerFunc(s.playJob)
+	api.Methods("POST").Path("/jobs/{id:[0-9]+}/retry").HandlerFunc(s.retryJob)
+	api.Methods("POST").Path("/jobs/{id:[0-9]+}/cancel").HandlerFunc(s.cancelJob)
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "404 Not Found")
+	})
+
+	s.Server = httptest.NewServer(r)
+
+	return s
+}
+
+// RevokeToken makes GitLab reject all requests with 401
+func (s *Server) RevokeToken() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.tokenRevoked = true
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mtx.Lock()
+		tokenRevoked := s.tokenRevoked
+		s.mtx.Unlock()
+
+		if tokenRevoked {
+			writeError(w, http.StatusUnauthorized, "401 Unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) currentUser(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &wrappedGitLab.User{ID: 1, Username: "dashboard", Name: "Dashboard"})
+}
+
+// AddGroupMember adds a member to the group, `state` is "active" or "blocked"
+// The group exists when it has members
+func (s *Server) AddGroupMember(group string, username string, state string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.groupMembers[group] = append(s.groupMembers[group], &wrappedGitLab.GroupMember{
+		ID:       s.nextID(),
+		Username: username,
+		Name:     username,
+		State:    state,
+	})
+}
+
+func (s *Server) listAllGroupMembers(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	members, ok := s.groupMembers[mux.Vars(r)["group"]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "404 Group Not Found")
+		return
+	}
+	query := r.URL.Query().Get("query")
+	var found []*wrappedGitLab.GroupMember
+	for _, member := range members {
+		if strings.Contains(member.Username, query) || strings.Contains(member.Name, query) {
+			found = append(found, member)
+		}
+	}
+
+	writePage(w, r, len(found), func(from, to int) interface{} {
+		return found[from:to]
+	})
+}
+
+// nextID returns a new ID, IDs are unique among all objects
+// mtx should be locked
+func (s *Server) nextID() int {
+	s.lastID++
+	return s.lastID
+}
+
+// AddProject adds a project without environments, pipelines and branches
+func (s *Server) AddProject(id int, name string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.projects[id] = &project{
+		project: &wrappedGitLab.Project{
+			ID:                id,
+			Name:              name,
+			NameWithNamespace: "group / " + name,
+			WebURL:            fmt.Sprintf("%s/group/%s", s.URL, name),
+		},
+		commits: map[string]*wrappedGitLab.Commit{},
+		traces:  map[int]string{},
+	}
+}
+
+// AddBranch adds a branch with the head commit
+func (s *Server) AddBranch(projectID int, name string, sha string, committedDate time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	p := s.projects[projectID]
+	p.branches = append(p.branches, &wrappedGitLab.Branch{
+		Name:   name,
+		Commit: &wrappedGitLab.Commit{ID: sha, CommittedDate: &committedDate},
+	})
+}
+
+// AddCommit adds a commit on top of its parents, a commit without parents is the first one
+// Compare counts commits by parents, so compared commits should be added first
+func (s *Server) AddCommit(projectID int, sha string, parents ...string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.projects[projectID].commits[sha] = &wrappedGitLab.Commit{ID: sha, ShortID: sha, ParentIDs: parents}
+}
+
+// AddEnvironment adds an environment which was deployed from the ref and returns its ID
+// The environment doesn't have deployments if the ref is empty
+func (s *Server) AddEnvironment(projectID int, name string, ref string, sha string) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	p := s.projects[projectID]
+	environment := &wrappedGitLab.Environment{ID: s.nextID(), Name: name}
+	if ref != "" {
+		updatedAt := time.Now()
+		deployment := &wrappedGitLab.Deployment{
+			ID:        s.nextID(),
+			Ref:       ref,
+			SHA:       sha,
+			UpdatedAt: &updatedAt,
+			User:      &wrappedGitLab.ProjectUser{Username: "deployer", Name: "Deployer"},
+		}
+		deployment.Deployable.Name = name
+		deployment.Deployable.Status = "success"
+		environment.LastDeployment = deployment
+	}
+	p.environments = append(p.environments, environment)
+
+	return environment.ID
+}
+
+// AddDeployment adds a deployment of the ref to the environment by a job with the status and returns its ID
+func (s *Server) AddDeployment(projectID int, environment string, ref string, status string) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	p := s.projects[projectID]
+	updatedAt := time.Now()
+	deployment := &wrappedGitLab.Deployment{
+		ID:          s.nextID(),
+		Ref:         ref,
+		UpdatedAt:   &updatedAt,
+		User:        &wrappedGitLab.ProjectUser{Username: "deployer", Name: "Deployer"},
+		Environment: &wrappedGitLab.Environment{Name: environment},
+	}
+	deployment.Deployable.Name = environment
+	deployment.Deployable.Status = status
+	p.deployments = append(p.deployments, deployment)
+
+	return deployment.ID
+}
+
+// AddPipeline adds a pipeline of the commit and returns its ID
+func (s *Server) AddPipeline(projectID int, ref string, sha string) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	p := s.projects[projectID]
+	pipeline := &wrappedGitLab.PipelineInfo{ID: s.nextID(), Ref: ref, SHA: sha, Status: "success"}
+	p.pipelines = append(p.pipelines, pipeline)
+
+	return pipeline.ID
+}
+
+// AddJob adds a job to the pipeline and returns its ID
+func (s *Server) AddJob(projectID int, pipelineID int, name string, status string) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	p := s.projects[projectID]
+	job := &wrappedGitLab.Job{ID: s.nextID(), Name: name, Status: status}
+	for _, pipeline := range p.pipelines {
+		if pipeline.ID == pipelineID {
+			job.Ref = pipeline.Ref
+			job.Pipeline.ID = pipeline.ID
+			job.Pipeline.Ref = pipeline.Ref
+			job.Pipeline.Sha = pipeline.SHA
+			job.Pipeline.Status = pipeline.Status
+		}
+	}
+	job.WebURL = fmt.Sprintf("%s/-/jobs/%d", p.project.WebURL, job.ID)
+	p.jobs = append(p.jobs, job)
+
+	return job.ID
+}
+
+// Job returns a copy of the job
+func (s *Server) Job(projectID int, jobID int) (wrappedGitLab.Job, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	job := s.findJob(projectID, jobID)
+	if job == nil {
+		return wrappedGitLab.Job{}, false
+	}
+
+	return *job, true
+}
+
+// SetJobStatus changes the status of the job, i.e. to finish a running job
+func (s *Server) SetJobStatus(projectID int, jobID int, status string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if job := s.findJob(projectID, jobID); job != nil {
+		job.Status = status
+	}
+}
+
+// SetJobTrace replaces the log of the job
+func (s *Server) SetJobTrace(projectID int, jobID int, trace string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.projects[projectID].traces[jobID] = trace
+}
+
+// findJob returns the stored job, mtx should be locked
+func (s *Server) findJob(projectID int, jobID int) *wrappedGitLab.Job {
+	p, ok := s.projects[projectID]
+	if !ok {
+		return nil
+	}
+	for _, job := range p.jobs {
+		if job.ID == jobID {
+			return job
+		}
+	}
+
+	return nil
+}
+
+// getProject returns the project of the request and the `id` path param, mtx should be locked
+func (s *Server) getProject(w http.ResponseWriter, r *http.Request) (*project, int, bool) {
+	vars := mux.Vars(r)
+	projectID, _ := strconv.Atoi(vars["projectID"])
+	p, ok := s.projects[projectID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "404 Project Not Found")
+		return nil, 0, false
+	}
+	id, _ := strconv.Atoi(vars["id"])
+
+	return p, id, true
+}
+
+func (s *Server) listEnvironments(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	p, _, ok := s.getProject(w, r)
+	if !ok {
+		return
+	}
+	// GitLab doesn't return last deployments in the list
+	environments := make([]*wrappedGitLab.Environment, 0, len(p.environments))
+	for _, environment := range p.environments {
+		environments = append(environments, &wrappedGitLab.Environment{
+			ID:      environment.ID,
+			Name:    environment.Name,
+			Project: p.project,
+		})
+	}
+	writePage(w, r, len(environments), func(from, to int) interface{} {
+		return environments[from:to]
+	})
+}
+
+func (s *Server) getEnvironment(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	p, id, ok := s.getProject(w, r)
+	if !ok {
+		return
+	}
+	for _, environment := range p.environments {
+		if environment.ID == id {
+			// GitLab doesn't return the project of a single environment
+			writeJSON(w, http.StatusOK, environment)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "404 Environment Not Found")
+}
+
+// listDeployments lists deployments of the environment, newest first as the dashboard asks
+func (s *Server) listDeployments(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	p, _, ok := s.getProject(w, r)
+	if !ok {
+		return
+	}
+	deployments := []*wrappedGitLab.Deployment{}
+	for i := len(p.deployments) - 1; i >= 0; i-- {
+		deployment := p.deployments[i]
+		if environment := r.URL.Query().Get("environment"); environment == "" || deployment.Environment.Name == environment {
+			deployments = append(deployments, deployment)
+		}
+	}
+	writePage(w, r, len(deployments), func(from, to int) interface{} {
+		return deployments[from:to]
+	})
+}
+
+func (s *Server) listBranches(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	p, _, ok := s.getProject(w, r)
+	if !ok {
+		return
+	}
+	branches := []*wrappedGitLab.Branch{}
+	for _, branch := range p.branches {
+		if matchSearch(branch.Name, r.URL.Query().Get("search")) {
+			branches = append(branches, branch)
+		}
+	}
+	// GitLab sorts branches by name
+	sort.Slice(branches, func(i, j int) bool {
+		return branches[i].Name < branches[j].Name
+	})
+	writePage(w, r, len(branches), func(from, to int) interface{} {
+		return branches[from:to]
+	})
+}
+
+// compare returns commits which `to` has but `from` doesn't, oldest first as GitLab does
+func (s *Server) compare(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	p, _, ok := s.getProject(w, r)
+	if !ok {
+		return
+	}
+	from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	if p.commits[from] == nil || p.commits[to] == nil {
+		writeError(w, http.StatusNotFound, "404 Ref Not Found")
+		return
+	}
+
+	fromAncestors := map[string]bool{}
+	for _, commit := range p.ancestors(from) {
+		fromAncestors[commit.ID] = true
+	}
+	commits := []*wrappedGitLab.Commit{}
+	for _, commit := range p.ancestors(to) {
+		if !fromAncestors[commit.ID] {
+			commits = append([]*wrappedGitLab.Commit{commit}, commits...)
+		}
+	}
+	writeJSON(w, http.StatusOK, &wrappedGitLab.Compare{
+		Commit:         p.commits[to],
+		Commits:        commits,
+		Diffs:          []*wrappedGitLab.Diff{},
+		CompareSameRef: from == to,
+	})
+}
+
+// ancestors returns the commit and all its known ancestors, newest first
+func (p *project) ancestors(sha string) []*wrappedGitLab.Commit {
+	var commits []*wrappedGitLab.Commit
+	visited := map[string]bool{}
+	queue := []string{sha}
+	for len(queue) > 0 {
+		commit, ok := p.commits[queue[0]]
+		queue = queue[1:]
+		if !ok || visited[commit.ID] {
+			continue
+		}
+		visited[commit.ID] = true
+		commits = append(commits, commit)
+		queue = append(queue, commit.ParentIDs...)
+	}
+
+	return commits
+}
+
+// matchSearch matches a name as GitLab does, `^` and `$` mean the beginning and the end of the name
+func matchSearch(name string, search string) bool {
+	switch {
+	case search == "":
+		return true
+	case strings.HasPrefix(search, "^"):
+		return strings.HasPrefix(name, search[1:])
+	case strings.HasSuffix(search, "$"):
+		return strings.HasSuffix(name, search[:len(search)-1])
+	}
+
+	return strings.Contains(name, search)
+}
+
+func (s *Server) listPipelines(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	p, _, ok := s.getProject(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	pipelines := []*wrappedGitLab.PipelineInfo{}
+	// Newest pipelines first
+	for i := len(p.pipelines) - 1; i >= 0; i-- {
+		pipeline := p.pipelines[i]
+		if query.Get("ref") != "" && pipeline.Ref != query.Get("ref") {
+			continue
+		}
+		if query.Get("sha") != "" && pipeline.SHA != query.Get("sha") {
+			continue
+		}
+		pipelines = append(pipelines, pipeline)
+	}
+	writePage(w, r, len(pipelines), func(from, to int) interface{} {
+		return pipelines[from:to]
+	})
+}
+
+func (s *Server) listPipelineJobs(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	p, id, ok := s.getProject(w, r)
+	if !ok {
+		return
+	}
+	scopes := r.URL.Query()["scope[]"]
+	jobs := []*wrappedGitLab.Job{}
+	for _, job := range p.jobs {
+		if job.Pipeline.ID != id {
+			continue
+		}
+		if len(scopes) > 0 && !utils.StringsContainString(scopes, job.Status) {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	writePage(w, r, len(jobs), func(from, to int) interface{} {
+		return jobs[from:to]
+	})
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	s.withJob(w, r, func(p *project, job *wrappedGitLab.Job) {
+		writeJSON(w, http.StatusOK, job)
+	})
+}
+
+func (s *Server) getTrace(w http.ResponseWriter, r *http.Request) {
+	s.withJob(w, r, func(p *project, job *wrappedGitLab.Job) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(p.traces[job.ID]))
+	})
+}
+
+func (s *Server) playJob(w http.ResponseWriter, r *http.Request) {
+	s.withJob(w, r, func(p *project, job *wrappedGitLab.Job) {
+		if job.Status != "manual" {
+			writeError(w, http.StatusBadRequest, "400 Unplayable Job")
+			return
+		}
+		job.Status = "pending"
+		writeJSON(w, http.StatusOK, job)
+	})
+}
+
+func (s *Server) retryJob(w http.ResponseWriter, r *http.Request) {
+	s.withJob(w, r, func(p *project, job *wrappedGitLab.Job) {
+		// GitLab creates a new job on retry
+		retried := *job
+		retried.ID = s.nextID()
+		retried.Status = "pending"
+		retried.WebURL = fmt.Sprintf("%s/-/jobs/%d", p.project.WebURL, retried.ID)
+		p.jobs = append(p.jobs, &retried)
+		writeJSON(w, http.StatusCreated, &retried)
+	})
+}
+
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	s.withJob(w, r, func(p *project, job *wrappedGitLab.Job) {
+		if utils.StringsContainString([]string{"created", "pending", "running"}, job.Status) {
+			job.Status = "canceled"
+		}
+		writeJSON(w, http.StatusCreated, job)
+	})
+}
+
+// withJob calls `handle` with the job of the request under the lock
+func (s *Server) withJob(w http.ResponseWriter, r *http.Request, handle func(p *project, job *wrappedGitLab.Job)) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	p, id, ok := s.getProject(w, r)
+	if !ok {
+		return
+	}
+	job := s.findJob(p.project.ID, id)
+	if job == nil {
+		writeError(w, http.StatusNotFound, "404 Job Not Found")
+		return
+	}
+	handle(p, job)
+}
+
+// writePage writes a page of items with GitLab pagination headers
+// `slice` returns items between given indexes
+func writePage(w http.ResponseWriter, r *http.Request, total int, slice func(from, to int) interface{}) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if perPage < 1 {
+		perPage = 20
+	}
+	totalPages := (total + perPage - 1) / perPage
+	if totalPages == 0 {
+		totalPages = 1
+	}
+
+	from := (page - 1) * perPage
+	if from > total {
+		from = total
+	}
+	to := from + perPage
+	if to > total {
+		to = total
+	}
+
+	w.Header().Set("X-Page", strconv.Itoa(page))
+	w.Header().Set("X-Per-Page", strconv.Itoa(perPage))
+	w.Header().Set("X-Total", strconv.Itoa(total))
+	w.Header().Set("X-Total-Pages", strconv.Itoa(totalPages))
+	if page < totalPages {
+		w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+	}
+	writeJSON(w, http.StatusOK, slice(from, to))
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"message": message})
+}
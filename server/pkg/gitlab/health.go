@@ -0,0 +1,77 @@
+package gitlab
+
+import (
+	"context"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"net/http"
+	"time"
+)
+
+const (
+	// gitLabCheckTTL prevents requesting GitLab on every probe of the orchestrator
+	gitLabCheckTTL = 10 * time.Second
+	// gitLabCheckTimeout stops retries of an unreachable GitLab, probes shouldn't hang
+	gitLabCheckTimeout = 2 * time.Second
+)
+
+// GitLabStatus is a result of checking GitLab with the token of the dashboard
+type GitLabStatus struct {
+	Reachable  bool
+	TokenValid bool
+	// Error of the check if GitLab is unreachable or the token is invalid
+	Error     string
+	CheckedAt time.Time
+}
+
+// CheckGitLab requests the user of the token to check GitLab and the token
+// The result is cached for gitLabCheckTTL
+// GitLab is unreachable if it doesn't respond in gitLabCheckTimeout
+func (c *Service) CheckGitLab(ctx context.Context) GitLabStatus {
+	c.gitLabStatusMtx.Lock()
+	defer c.gitLabStatusMtx.Unlock()
+
+	if time.Since(c.gitLabStatus.CheckedAt) < gitLabCheckTTL {
+		return c.gitLabStatus
+	}
+
+	ctx, span := startSpan(ctx, "Service.CheckGitLab")
+	defer span.End()
+
+	checkCtx, cancel := context.WithTimeout(ctx, gitLabCheckTimeout)
+	defer cancel()
+
+	status := GitLabStatus{CheckedAt: time.Now()}
+	_, resp, err := c.git.Users.CurrentUser(wrappedGitLab.WithContext(checkCtx))
+	switch {
+	case err == nil:
+		status.Reachable = true
+		status.TokenValid = true
+	case resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden):
+		// GitLab answers, but the token is revoked or expired
+		status.Reachable = true
+		status.Error = err.Error()
+	default:
+		status.Error = err.Error()
+	}
+	// A canceled probe says nothing about GitLab
+	if ctx.Err() == nil {
+		c.gitLabStatus = status
+	}
+
+	return status
+}
+
+// LastUpdates returns times of the last successful updates of environments and branches caches
+// An update of branches is successful only when branches of all projects are fetched
+// The time is zero until the first successful update
+func (c *Service) LastUpdates() (environments time.Time, branches time.Time) {
+	c.environmentsMtx.RLock()
+	environments = c.environmentsUpdatedAt
+	c.environmentsMtx.RUnlock()
+
+	c.branchesMtx.RLock()
+	branches = c.branchesUpdatedAt
+	c.branchesMtx.RUnlock()
+
+	return environments, branches
+}
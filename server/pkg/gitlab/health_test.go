@@ -0,0 +1,84 @@
+package gitlab
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckGitLab(t *testing.T) {
+	tests := []struct {
+		name           string
+		revokeToken    bool
+		stopGitLab     bool
+		wantReachable  bool
+		wantTokenValid bool
+	}{
+		{"valid", false, false, true, true},
+		{"revoked", true, false, true, false},
+		{"unreachable", false, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, server := newTestService(t)
+			if tt.revokeToken {
+				server.RevokeToken()
+			}
+			if tt.stopGitLab {
+				server.Close()
+			}
+			status := service.CheckGitLab(context.Background())
+			if status.Reachable != tt.wantReachable || status.TokenValid != tt.wantTokenValid {
+				t.Errorf("CheckGitLab() = %+v, want reachable %v and valid token %v", status, tt.wantReachable, tt.wantTokenValid)
+			}
+			if (status.Error != "") == (tt.wantReachable && tt.wantTokenValid) {
+				t.Errorf("CheckGitLab() error = %q", status.Error)
+			}
+		})
+	}
+}
+
+func TestCheckGitLabIsCached(t *testing.T) {
+	service, server := newTestService(t)
+	if status := service.CheckGitLab(context.Background()); !status.TokenValid {
+		t.Fatalf("CheckGitLab() = %+v, want valid token", status)
+	}
+
+	server.RevokeToken()
+	if status := service.CheckGitLab(context.Background()); !status.TokenValid {
+		t.Errorf("CheckGitLab() = %+v, want the cached result", status)
+	}
+}
+
+func TestLastUpdates(t *testing.T) {
+	service, server := newTestService(t, 1)
+	server.AddProject(1, "api")
+
+	environments, branches := service.LastUpdates()
+	if !environments.IsZero() || !branches.IsZero() {
+		t.Fatalf("LastUpdates() = %v, %v before updates, want zero times", environments, branches)
+	}
+
+	if err := service.UpdateEnvironments(context.Background(), []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.UpdateBranches(context.Background(), []int{1}); err != nil {
+		t.Fatal(err)
+	}
+	environments, branches = service.LastUpdates()
+	if environments.IsZero() || branches.IsZero() {
+		t.Errorf("LastUpdates() = %v, %v after updates, want update times", environments, branches)
+	}
+
+	// Failed updates don't make caches look fresh for the readiness probe
+	server.RevokeToken()
+	if err := service.UpdateEnvironments(context.Background(), []int{1}); err == nil {
+		t.Error("UpdateEnvironments() with a revoked token succeeded, want error")
+	}
+	if err := service.UpdateBranches(context.Background(), []int{1}); err == nil {
+		t.Error("UpdateBranches() with a revoked token succeeded, want error")
+	}
+	failedEnvironments, failedBranches := service.LastUpdates()
+	if !failedEnvironments.Equal(environments) || !failedBranches.Equal(branches) {
+		t.Errorf("LastUpdates() = %v, %v after failed updates, want %v, %v", failedEnvironments, failedBranches, environments, branches)
+	}
+}
@@ -0,0 +1,60 @@
+package gitlab
+
+import (
+	"time"
+)
+
+var (
+	EnvironmentIsLocked    = newError(KindConflict, "environment_locked", "environment is locked")
+	EnvironmentIsNotLocked = newError(KindConflict, "environment_not_locked", "environment is not locked")
+)
+
+// EnvironmentLock forbids deploys to an environment from the dashboard until it's unlocked
+// User is nil when OAuth is disabled
+type EnvironmentLock struct {
+	Environment string       `json:"environment"`
+	User        *ProjectUser `json:"user"`
+	Reason      string       `json:"reason"`
+	LockedAt    time.Time    `json:"lockedAt"`
+}
+
+// LockEnvironment locks the environment, a locked environment cannot be locked again
+func (c *Service) LockEnvironment(environment string, user *ProjectUser, reason string) (*EnvironmentLock, error) {
+	c.locksMtx.Lock()
+	defer c.locksMtx.Unlock()
+
+	if _, ok := c.locks[environment]; ok {
+		return nil, EnvironmentIsLocked
+	}
+	lock := &EnvironmentLock{
+		Environment: environment,
+		User:        user,
+		Reason:      reason,
+		LockedAt:    time.Now(),
+	}
+	c.locks[environment] = lock
+
+	return lock, nil
+}
+
+// UnlockEnvironment removes the lock of the environment
+func (c *Service) UnlockEnvironment(environment string) error {
+	c.locksMtx.Lock()
+	defer c.locksMtx.Unlock()
+
+	if _, ok := c.locks[environment]; !ok {
+		return EnvironmentIsNotLocked
+	}
+	delete(c.locks, environment)
+
+	return nil
+}
+
+// GetEnvironmentLock returns the lock of the environment if it's locked
+func (c *Service) GetEnvironmentLock(environment string) (*EnvironmentLock, bool) {
+	c.locksMtx.RLock()
+	defer c.locksMtx.RUnlock()
+
+	lock, ok := c.locks[environment]
+	return lock, ok
+}
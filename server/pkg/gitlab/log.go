@@ -0,0 +1,131 @@
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/utils"
+	"io/ioutil"
+	"regexp"
+	"unicode/utf8"
+)
+
+var (
+	// ansiEscapeRegex matches colours and other control sequences, i.e. "\x1b[32;1m"
+	ansiEscapeRegex = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+	// ansiSectionRegex matches GitLab collapsible sections, i.e. "section_start:1597999109:build_script\r"
+	ansiSectionRegex = regexp.MustCompile(`section_(start|end):[0-9]+:[^\r\n]*\r`)
+)
+
+// JobLog is a part of a job trace started from an offset
+// Offset is a byte offset in the trace to continue reading from, it's always at the start of a UTF-8 character
+type JobLog struct {
+	JobID    int    `json:"jobId"`
+	Status   string `json:"status"`
+	Finished bool   `json:"finished"`
+	Offset   int    `json:"offset"`
+	Log      string `json:"log"`
+}
+
+// GetJobLog returns the trace of the job run from the dashboard starting from the offset
+// When `stripANSI` is set colours and sections are removed from the log
+func (c *Service) GetJobLog(ctx context.Context, environment string, projectID int, offset int, stripANSI bool) (jobLog *JobLog, err error) {
+	ctx, span := startSpan(ctx, "Service.GetJobLog",
+		projectIDKey.Int(projectID),
+		environmentKey.String(environment),
+	)
+	defer func() { endSpan(span, err) }()
+
+	job, ok := c.GetJob(environment, projectID)
+	if !ok || job == nil {
+		return nil, JobNotFound
+	}
+
+	// Read the status before the trace
+	// so the trace of a finished job is complete
+	jobLog = &JobLog{
+		JobID:    job.ID,
+		Status:   job.Status,
+		Finished: utils.StringsContainString(finishedJobStatus, job.Status),
+	}
+
+	reader, _, err := c.git.Jobs.GetTraceFile(projectID, job.ID, wrappedGitLab.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	trace, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset < 0 || offset > len(trace) {
+		offset = len(trace)
+	}
+	// The log is a string, so it shouldn't start or end in the middle of a character
+	offset = alignToRuneStart(trace, offset)
+	chunk := trace[offset:]
+	if !jobLog.Finished {
+		chunk = trimIncompleteRune(chunk)
+	}
+	if stripANSI {
+		// The trace of a running job could end in the middle of a control sequence
+		// We leave it for the next read
+		if !jobLog.Finished {
+			chunk = trimIncompleteEscape(chunk)
+		}
+		jobLog.Log = StripANSI(string(chunk))
+	} else {
+		jobLog.Log = string(chunk)
+	}
+	jobLog.Offset = offset + len(chunk)
+
+	return jobLog, nil
+}
+
+// StripANSI removes colours and GitLab sections from a job log
+func StripANSI(log string) string {
+	log = ansiSectionRegex.ReplaceAllString(log, "")
+	return ansiEscapeRegex.ReplaceAllString(log, "")
+}
+
+// alignToRuneStart moves the offset back to the start of the character which it points into
+func alignToRuneStart(trace []byte, offset int) int {
+	for start := offset - 1; start >= 0 && offset-start < utf8.UTFMax; start-- {
+		if utf8.RuneStart(trace[start]) {
+			if utf8.FullRune(trace[start:offset]) {
+				return offset
+			}
+			return start
+		}
+	}
+
+	return offset
+}
+
+// trimIncompleteRune cuts an unfinished character at the end of the chunk
+// The trace of a running job could end in the middle of it, the rest comes with the next read
+func trimIncompleteRune(chunk []byte) []byte {
+	for start := len(chunk) - 1; start >= 0 && len(chunk)-start <= utf8.UTFMax; start-- {
+		if utf8.RuneStart(chunk[start]) {
+			if !utf8.FullRune(chunk[start:]) {
+				return chunk[:start]
+			}
+			return chunk
+		}
+	}
+
+	return chunk
+}
+
+// trimIncompleteEscape cuts an unfinished control sequence at the end of the chunk
+func trimIncompleteEscape(chunk []byte) []byte {
+	index := bytes.LastIndexByte(chunk, 0x1b)
+	if index == -1 {
+		return chunk
+	}
+	if ansiEscapeRegex.Match(chunk[index:]) {
+		return chunk
+	}
+
+	return chunk[:index]
+}
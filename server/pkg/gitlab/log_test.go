@@ -0,0 +1,95 @@
+package gitlab
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStripANSI(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+		want string
+	}{
+		{"plain", "Running with gitlab-runner\n", "Running with gitlab-runner\n"},
+		{"colours", "\x1b[32;1mJob succeeded\x1b[0;m\n", "Job succeeded\n"},
+		{
+			"sections",
+			"section_start:1597999109:build_script\r\x1b[0K\x1b[32;1m$ make\x1b[0;m\nsection_end:1597999110:build_script\r\x1b[0K",
+			"$ make\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StripANSI(tt.log); got != tt.want {
+				t.Errorf("StripANSI() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimIncompleteEscape(t *testing.T) {
+	tests := []struct {
+		name  string
+		chunk []byte
+		want  []byte
+	}{
+		{"plain", []byte("make"), []byte("make")},
+		{"complete", []byte("make\x1b[0;m"), []byte("make\x1b[0;m")},
+		{"incomplete", []byte("make\x1b[32;"), []byte("make")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trimIncompleteEscape(tt.chunk); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("trimIncompleteEscape() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlignToRuneStart(t *testing.T) {
+	// "✓" takes bytes 3-5
+	tests := []struct {
+		name   string
+		trace  string
+		offset int
+		want   int
+	}{
+		{"start", "ok ✓ done", 0, 0},
+		{"ascii", "ok ✓ done", 3, 3},
+		{"insideRune", "ok ✓ done", 4, 3},
+		{"lastByteOfRune", "ok ✓ done", 5, 3},
+		{"afterRune", "ok ✓ done", 6, 6},
+		{"end", "ok ✓ done", 10, 10},
+		// The trace of a running job ends in the middle of the character
+		{"cutTrace", "ok ✓ done"[:5], 5, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := alignToRuneStart([]byte(tt.trace), tt.offset); got != tt.want {
+				t.Errorf("alignToRuneStart() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimIncompleteRune(t *testing.T) {
+	tests := []struct {
+		name  string
+		chunk []byte
+		want  []byte
+	}{
+		{"empty", []byte{}, []byte{}},
+		{"ascii", []byte("make"), []byte("make")},
+		{"complete", []byte("make ✓"), []byte("make ✓")},
+		{"incomplete", []byte("make ✓")[:6], []byte("make ")},
+		{"invalid", []byte("make \xff"), []byte("make \xff")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trimIncompleteRune(tt.chunk); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("trimIncompleteRune() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
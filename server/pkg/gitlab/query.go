@@ -0,0 +1,381 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/utils"
+	"path"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// MinQueryLength is the shortest query, shorter prefixes would match branches of almost every project
+const MinQueryLength = 3
+
+// Modes of matching a branch name with a query
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+	MatchGlob   = "glob"
+	MatchRegex  = "regex"
+)
+
+// QueryDeployOptions describes how branches are chosen for a query deployment
+type QueryDeployOptions struct {
+	Query string
+	// Match is one of Match* modes, MatchPrefix by default
+	Match string
+	// FallbackRef is deployed when a project doesn't have a matched branch
+	FallbackRef string
+	// FallbackRefs overrides FallbackRef for given project IDs
+	FallbackRefs map[int]string
+}
+
+// fallbackRef returns the fallback ref for given project
+func (o QueryDeployOptions) fallbackRef(projectID int) string {
+	if ref, ok := o.FallbackRefs[projectID]; ok {
+		return ref
+	}
+
+	return o.FallbackRef
+}
+
+// branchMatcher checks if a branch name satisfies a query
+type branchMatcher struct {
+	// search is sent to GitLab to reduce amount of fetched branches
+	search *string
+	// cached means branches are matched against the branches cache when a project is there
+	// GitLab can't search by globs and regexes, so all branches would be fetched otherwise
+	cached bool
+	match  func(name string) bool
+}
+
+// prefixSearch returns a search of branches which start with the prefix, nil for an empty prefix
+func prefixSearch(prefix string) *string {
+	if prefix == "" {
+		return nil
+	}
+
+	return wrappedGitLab.String(fmt.Sprintf("^%s", prefix))
+}
+
+// globLiteralPrefix returns the part of a glob pattern before the first special character
+func globLiteralPrefix(pattern string) string {
+	if index := strings.IndexAny(pattern, `*?[\`); index >= 0 {
+		return pattern[:index]
+	}
+
+	return pattern
+}
+
+// regexLiteralPrefix returns the literal which starts every match of a regex anchored by `^`
+// It returns an empty string when the regex isn't anchored or doesn't start with a case-sensitive literal
+func regexLiteralPrefix(expr string) string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 || re.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+	literal := re.Sub[1]
+	if literal.Op != syntax.OpLiteral || literal.Flags&syntax.FoldCase != 0 {
+		return ""
+	}
+
+	return string(literal.Rune)
+}
+
+func newBranchMatcher(mode string, query string) (*branchMatcher, error) {
+	if len(query) < MinQueryLength {
+		return nil, invalidArgument("query is too short (min %d symbols)", MinQueryLength)
+	}
+
+	switch mode {
+	case MatchExact:
+		return &branchMatcher{
+			search: wrappedGitLab.String(query),
+			match: func(name string) bool {
+				return name == query
+			},
+		}, nil
+	case MatchPrefix, "":
+		return &branchMatcher{
+			search: wrappedGitLab.String(fmt.Sprintf("^%s", query)),
+			match: func(name string) bool {
+				return strings.HasPrefix(name, query)
+			},
+		}, nil
+	case MatchGlob:
+		// Validate the pattern once, path.Match returns an error only for a bad pattern
+		if _, err := path.Match(query, ""); err != nil {
+			return nil, invalidArgument("wrong glob pattern: %v", err)
+		}
+		return &branchMatcher{
+			search: prefixSearch(globLiteralPrefix(query)),
+			cached: true,
+			match: func(name string) bool {
+				matched, _ := path.Match(query, name)
+				return matched
+			},
+		}, nil
+	case MatchRegex:
+		re, err := regexp.Compile(query)
+		if err != nil {
+			return nil, invalidArgument("wrong regex: %v", err)
+		}
+		return &branchMatcher{
+			search: prefixSearch(regexLiteralPrefix(query)),
+			cached: true,
+			match:  re.MatchString,
+		}, nil
+	}
+
+	return nil, invalidArgument("unknown match mode: %s", mode)
+}
+
+// Outcomes of a query deployment for a project
+const (
+	QueryDeployPlayed          = "played"
+	QueryDeployRetried         = "retried"
+	QueryDeploySkippedNoBranch = "skipped-no-branch"
+	QueryDeploySkippedNoJob    = "skipped-no-job"
+	QueryDeployNotReady        = "not-ready"
+	QueryDeployError           = "error"
+)
+
+// QueryDeployPreview describes what PlayOrRetryJobsWithQuery would do with a project
+// SkipReason is filled when the project would be skipped
+type QueryDeployPreview struct {
+	ProjectID  int    `json:"projectId"`
+	Branch     string `json:"branch,omitempty"`
+	Fallback   bool   `json:"fallback,omitempty"`
+	PipelineID int    `json:"pipelineId,omitempty"`
+	JobID      int    `json:"jobId,omitempty"`
+	JobName    string `json:"jobName,omitempty"`
+	Action     string `json:"action,omitempty"`
+	SkipReason string `json:"skipReason,omitempty"`
+}
+
+// QueryDeployResult describes what PlayOrRetryJobsWithQuery did with a project
+type QueryDeployResult struct {
+	ProjectID int                `json:"projectId"`
+	Branch    string             `json:"branch,omitempty"`
+	Fallback  bool               `json:"fallback,omitempty"`
+	Outcome   string             `json:"outcome"`
+	Error     string             `json:"error,omitempty"`
+	Job       *wrappedGitLab.Job `json:"job,omitempty"`
+	// err is the error of the error outcome, it's classified by UpstreamError
+	err error
+}
+
+// QueryDeployResults is a list of results for all projects of a query deployment
+type QueryDeployResults []*QueryDeployResult
+
+// Deployed returns amount of projects where a job was played or retried
+func (r QueryDeployResults) Deployed() int {
+	count := 0
+	for _, result := range r {
+		if result.Outcome == QueryDeployPlayed || result.Outcome == QueryDeployRetried {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Failed returns amount of projects which failed with an unexpected error
+func (r QueryDeployResults) Failed() int {
+	count := 0
+	for _, result := range r {
+		if result.Outcome == QueryDeployError {
+			count++
+		}
+	}
+
+	return count
+}
+
+// UpstreamError returns the classified error when every failed project failed because of GitLab
+// It returns nil when nothing failed or some project failed with another error
+// Unavailable GitLab wins over its errors, clients could retry it later
+func (r QueryDeployResults) UpstreamError() *Error {
+	var upstream *Error
+	for _, result := range r {
+		if result.Outcome != QueryDeployError {
+			continue
+		}
+		classified := ClassifyError(result.err)
+		if classified == nil || (classified.Kind != KindUpstream && classified.Kind != KindUnavailable) {
+			return nil
+		}
+		if upstream == nil || classified.Kind == KindUnavailable {
+			upstream = classified
+		}
+	}
+
+	return upstream
+}
+
+// setError fills the outcome by the given error
+// Missing branches and jobs are normal for a query deployment
+// because not all projects have the branch or the environment
+func (r *QueryDeployResult) setError(err error) {
+	switch err {
+	case BranchNotFound:
+		r.Outcome = QueryDeploySkippedNoBranch
+	case JobNotFound:
+		r.Outcome = QueryDeploySkippedNoJob
+	case JobIsNotReady:
+		r.Outcome = QueryDeployNotReady
+	default:
+		r.Outcome = QueryDeployError
+		r.Error = err.Error()
+		r.err = err
+	}
+}
+
+// cachedBranches returns branches of a project from the cache filled by UpdateBranches
+// A project isn't cached until its branches are fetched once
+func (c *Service) cachedBranches(projectID int) ([]*wrappedGitLab.Branch, bool) {
+	c.branchesMtx.RLock()
+	defer c.branchesMtx.RUnlock()
+
+	branches, ok := c.branches[projectID]
+	return branches, ok
+}
+
+// findBranchForQuery returns the most recently committed branch which matches the query
+// If nothing matched it returns the fallback ref of the project (if any) and `fallback` flag
+// Glob and regex queries use cached branches, they are fetched from GitLab only when a project isn't cached
+func (c *Service) findBranchForQuery(ctx context.Context, projectId int, matcher *branchMatcher, options QueryDeployOptions) (branch string, fallback bool, err error) {
+	branches, ok := []*wrappedGitLab.Branch(nil), false
+	if matcher.cached {
+		branches, ok = c.cachedBranches(projectId)
+	}
+	if !ok {
+		branches, err = c.listBranches(ctx, projectId, matcher.search)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	// Branches are sorted by committed_date desc
+	for _, remoteBranch := range branches {
+		if matcher.match(remoteBranch.Name) {
+			return remoteBranch.Name, false, nil
+		}
+	}
+
+	if ref := options.fallbackRef(projectId); ref != "" {
+		return ref, true, nil
+	}
+
+	return "", false, BranchNotFound
+}
+
+// PlayOrRetryJobsWithQuery plays or retries jobs on all projects which have a branch for the query
+// A failed project doesn't stop others, the outcome of every project is returned
+func (c *Service) PlayOrRetryJobsWithQuery(ctx context.Context, environment string, options QueryDeployOptions, user *ProjectUser) (results QueryDeployResults, err error) {
+	ctx, span := startSpan(ctx, "Service.PlayOrRetryJobsWithQuery", environmentKey.String(environment))
+	defer func() { endSpan(span, err) }()
+
+	if utils.StringsContainString(c.protectedEnvironments, environment) {
+		return nil, DeniedForProtectedEnvironment
+	}
+	// Every project would fail on the lock, so the whole deployment is denied
+	if _, ok := c.GetEnvironmentLock(environment); ok {
+		return nil, EnvironmentIsLocked
+	}
+	matcher, err := newBranchMatcher(options.Match, options.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	results = make(QueryDeployResults, 0, len(c.projectIDs))
+	for _, projectId := range c.projectIDs {
+		result := &QueryDeployResult{ProjectID: projectId}
+		results = append(results, result)
+
+		branch, fallback, err := c.findBranchForQuery(ctx, projectId, matcher, options)
+		if err != nil {
+			result.setError(err)
+			continue
+		}
+		result.Branch = branch
+		result.Fallback = fallback
+
+		_, runJob, action, err := c.playOrRetryJob(ctx, projectId, environment, branch, "", user)
+		if err != nil {
+			result.setError(err)
+			continue
+		}
+		result.Job = runJob
+		result.Outcome = QueryDeployRetried
+		if action == JobActionPlay {
+			result.Outcome = QueryDeployPlayed
+		}
+	}
+
+	return results, nil
+}
+
+// PreviewJobsWithQuery shows what PlayOrRetryJobsWithQuery would do for every project
+// It only reads from GitLab and never plays or retries jobs
+func (c *Service) PreviewJobsWithQuery(ctx context.Context, environment string, options QueryDeployOptions) (previews []*QueryDeployPreview, err error) {
+	ctx, span := startSpan(ctx, "Service.PreviewJobsWithQuery", environmentKey.String(environment))
+	defer func() { endSpan(span, err) }()
+
+	if utils.StringsContainString(c.protectedEnvironments, environment) {
+		return nil, DeniedForProtectedEnvironment
+	}
+	// Every project would fail on the lock, so the whole deployment is denied
+	if _, ok := c.GetEnvironmentLock(environment); ok {
+		return nil, EnvironmentIsLocked
+	}
+	matcher, err := newBranchMatcher(options.Match, options.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	previews = make([]*QueryDeployPreview, 0, len(c.projectIDs))
+	for _, projectId := range c.projectIDs {
+		preview := &QueryDeployPreview{ProjectID: projectId}
+		previews = append(previews, preview)
+
+		branch, fallback, err := c.findBranchForQuery(ctx, projectId, matcher, options)
+		if err == BranchNotFound {
+			preview.SkipReason = err.Error()
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		preview.Branch = branch
+		preview.Fallback = fallback
+
+		job, err := c.findJobForGivenCriteriaRecursive(ctx, projectId, environment, branch, "")
+		if err == JobNotFound || err == JobIsNotReady {
+			preview.SkipReason = err.Error()
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		preview.JobID = job.ID
+		preview.JobName = job.Name
+		preview.PipelineID = job.Pipeline.ID
+
+		action, err := getJobAction(job)
+		if err != nil {
+			preview.SkipReason = err.Error()
+			continue
+		}
+		preview.Action = action
+	}
+
+	return previews, nil
+}
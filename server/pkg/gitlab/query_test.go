@@ -0,0 +1,190 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gitlab-environment-dashboard/server/pkg/breaker"
+	"testing"
+	"time"
+)
+
+func TestNewBranchMatcher(t *testing.T) {
+	type args struct {
+		mode  string
+		query string
+		name  string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    bool
+		wantErr bool
+	}{
+		{"exact", args{MatchExact, "feature/x", "feature/x"}, true, false},
+		{"exactLonger", args{MatchExact, "feature/x", "feature/xy"}, false, false},
+		{"prefix", args{MatchPrefix, "release-42", "release-42-hotfix"}, true, false},
+		{"prefixByDefault", args{"", "release-42", "release-42-hotfix"}, true, false},
+		{"prefixNotFound", args{MatchPrefix, "release-42", "hotfix-release-42"}, false, false},
+		{"glob", args{MatchGlob, "feature/*-login", "feature/sa-1-login"}, true, false},
+		{"globNotFound", args{MatchGlob, "feature/*", "bugfix/sa-1"}, false, false},
+		{"globWrongPattern", args{MatchGlob, "feature/[", ""}, false, true},
+		{"regex", args{MatchRegex, "^release-[0-9]+$", "release-42"}, true, false},
+		{"regexNotFound", args{MatchRegex, "^release-[0-9]+$", "release-42a"}, false, false},
+		{"regexWrongPattern", args{MatchRegex, "release-(", ""}, false, true},
+		{"unknownMode", args{"fuzzy", "release", ""}, false, true},
+		{"tooShort", args{MatchPrefix, "re", "release-42"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := newBranchMatcher(tt.args.mode, tt.args.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newBranchMatcher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := matcher.match(tt.args.name); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBranchMatcherSearch(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  string
+		query string
+		want  string
+	}{
+		{"exact", MatchExact, "feature/x", "feature/x"},
+		{"prefix", MatchPrefix, "release-42", "^release-42"},
+		{"glob", MatchGlob, "feature/*-login", "^feature/"},
+		{"globEscape", MatchGlob, `feature\*`, "^feature"},
+		{"globWithoutPrefix", MatchGlob, "*-login", ""},
+		{"regex", MatchRegex, "^release-[0-9]+$", "^release-"},
+		{"regexRepeatedLetter", MatchRegex, "^feature+", "^featur"},
+		{"regexNotAnchored", MatchRegex, "release-[0-9]+", ""},
+		{"regexAlternation", MatchRegex, "^release|^hotfix", ""},
+		{"regexIgnoreCase", MatchRegex, "(?i)^release", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := newBranchMatcher(tt.mode, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			search := ""
+			if matcher.search != nil {
+				search = *matcher.search
+			}
+			if search != tt.want {
+				t.Errorf("newBranchMatcher() search = %q, want %q", search, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindBranchForQuery(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		query  string
+		cached bool
+		want   string
+	}{
+		{"globCached", MatchGlob, "feature/*-login", true, "feature/old-login"},
+		{"globNotCached", MatchGlob, "feature/*-login", false, "feature/new-login"},
+		{"regexCached", MatchRegex, "-login$", true, "feature/old-login"},
+		{"regexNotCached", MatchRegex, "-login$", false, "feature/new-login"},
+		// Prefix queries are searched by GitLab, so new branches are found at once
+		{"prefixIgnoresCache", MatchPrefix, "feature/", true, "feature/new-login"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, server := newTestService(t, 1)
+			now := time.Now()
+			server.AddProject(1, "api")
+			server.AddBranch(1, "master", "a1", now.Add(-2*time.Hour))
+			server.AddBranch(1, "feature/old-login", "a2", now.Add(-time.Hour))
+			if tt.cached {
+				if err := service.UpdateBranches(context.Background(), []int{1}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Pushed after the last update of the cache
+			server.AddBranch(1, "feature/new-login", "a3", now)
+
+			matcher, err := newBranchMatcher(tt.mode, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			branch, fallback, err := service.findBranchForQuery(context.Background(), 1, matcher, QueryDeployOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if branch != tt.want || fallback {
+				t.Errorf("findBranchForQuery() = %s, %v, want %s", branch, fallback, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryDeployResultsUpstreamError(t *testing.T) {
+	result := func(err error) *QueryDeployResult {
+		r := &QueryDeployResult{}
+		r.setError(err)
+		return r
+	}
+	unavailable := fmt.Errorf("GET /projects/1/jobs: %w", breaker.CircuitIsOpen)
+	upstream := newError(KindUpstream, "gitlab_error", "GitLab responded with 500")
+
+	tests := []struct {
+		name     string
+		results  QueryDeployResults
+		wantCode string
+	}{
+		{"unavailable", QueryDeployResults{result(unavailable), result(unavailable)}, "gitlab_unavailable"},
+		{"upstream", QueryDeployResults{result(upstream), result(BranchNotFound)}, "gitlab_error"},
+		{"mixed", QueryDeployResults{result(upstream), result(unavailable)}, "gitlab_unavailable"},
+		{"unknownError", QueryDeployResults{result(unavailable), result(errors.New("something went wrong"))}, ""},
+		{"skippedOnly", QueryDeployResults{result(BranchNotFound), result(JobNotFound)}, ""},
+		{"serviceError", QueryDeployResults{result(JobIsAlreadyRunning)}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreamErr := tt.results.UpstreamError()
+			if tt.wantCode == "" {
+				if upstreamErr != nil {
+					t.Errorf("UpstreamError() = %+v, want nil", upstreamErr)
+				}
+				return
+			}
+			if upstreamErr == nil || upstreamErr.Code != tt.wantCode {
+				t.Errorf("UpstreamError() = %+v, want %s", upstreamErr, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestQueryDeployLockedEnvironment(t *testing.T) {
+	service, server := newTestService(t, 1)
+	server.AddProject(1, "api")
+	server.AddBranch(1, "release-42", "a1", time.Now())
+	jobID := server.AddJob(1, server.AddPipeline(1, "release-42", "a1"), "qa", JobStatusManual)
+	if _, err := service.LockEnvironment("qa", nil, "testing"); err != nil {
+		t.Fatal(err)
+	}
+	options := QueryDeployOptions{Query: "release-42"}
+
+	if _, err := service.PlayOrRetryJobsWithQuery(context.Background(), "qa", options, nil); err != EnvironmentIsLocked {
+		t.Errorf("PlayOrRetryJobsWithQuery() error = %v, want %v", err, EnvironmentIsLocked)
+	}
+	if _, err := service.PreviewJobsWithQuery(context.Background(), "qa", options); err != EnvironmentIsLocked {
+		t.Errorf("PreviewJobsWithQuery() error = %v, want %v", err, EnvironmentIsLocked)
+	}
+	if job, _ := server.Job(1, jobID); job.Status != JobStatusManual {
+		t.Errorf("job status = %s, want %s", job.Status, JobStatusManual)
+	}
+}
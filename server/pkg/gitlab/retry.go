@@ -0,0 +1,125 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/breaker"
+	"gitlab-environment-dashboard/server/pkg/utils"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// go-gitlab retries a request up to 5 times
+// We decide which requests are safe to retry and how long to wait between attempts
+const (
+	retryWaitMin = 500 * time.Millisecond
+	retryWaitMax = 10 * time.Second
+)
+
+// idempotentMethods could be repeated without side effects
+var idempotentMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodOptions,
+	http.MethodPut,
+	http.MethodDelete,
+}
+
+// clientOptions returns options of every GitLab client of the dashboard
+func clientOptions(baseURL string, httpClient *http.Client) []wrappedGitLab.ClientOptionFunc {
+	return []wrappedGitLab.ClientOptionFunc{
+		wrappedGitLab.WithBaseURL(baseURL),
+		wrappedGitLab.WithHTTPClient(httpClient),
+		wrappedGitLab.WithCustomRetry(checkRetry),
+		wrappedGitLab.WithCustomBackoff(retryBackoff),
+	}
+}
+
+// checkRetry decides if a GitLab request should be retried
+// Rate limited requests and requests which couldn't connect never reach GitLab, so they are always retried
+// Other failures are retried only for idempotent methods
+// because a failed POST (i.e. playing a job) could be already done by GitLab
+func checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+
+	if err != nil {
+		if errors.Is(err, breaker.CircuitIsOpen) {
+			return false, err
+		}
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return true, nil
+		}
+		// http.Client puts the method to Op, i.e. "Get"
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return isIdempotent(urlErr.Op), nil
+		}
+		return false, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true, nil
+	}
+	if resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented {
+		return isIdempotent(resp.Request.Method), nil
+	}
+
+	return false, nil
+}
+
+func isIdempotent(method string) bool {
+	return utils.StringsContainString(idempotentMethods, strings.ToUpper(method))
+}
+
+// retryBackoff waits as long as GitLab asks by Retry-After or RateLimit-Reset headers
+// Otherwise it's an exponential backoff with jitter
+// go-gitlab defaults `min` and `max` are too short for a restarting GitLab, so we use our own
+func retryBackoff(_, _ time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if wait, ok := retryAfter(resp.Header, time.Now()); ok {
+			if wait > retryWaitMax {
+				return retryWaitMax
+			}
+			if wait > 0 {
+				return wait
+			}
+		}
+	}
+
+	wait := retryWaitMin << uint(attemptNum)
+	if wait <= 0 || wait > retryWaitMax {
+		wait = retryWaitMax
+	}
+	// Up to 25% of jitter to not retry all requests at the same time
+	jitter := time.Duration(rand.Int63n(int64(wait / 4)))
+
+	return wait - jitter
+}
+
+// retryAfter reads the time to wait from Retry-After (seconds or a date) or RateLimit-Reset (unix time) headers
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return date.Sub(now), true
+		}
+	}
+	if value := header.Get("RateLimit-Reset"); value != "" {
+		if reset, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.Unix(reset, 0).Sub(now), true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,89 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"gitlab-environment-dashboard/server/pkg/breaker"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCheckRetry(t *testing.T) {
+	response := func(method string, code int) *http.Response {
+		return &http.Response{StatusCode: code, Request: &http.Request{Method: method}}
+	}
+	urlError := func(op string, err error) error {
+		return &url.Error{Op: op, URL: "https://gitlab.com/api/v4", Err: err}
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		resp *http.Response
+		err  error
+		want bool
+	}{
+		{"ok", context.Background(), response("GET", 200), nil, false},
+		{"notFound", context.Background(), response("GET", 404), nil, false},
+		{"badGatewayGet", context.Background(), response("GET", 502), nil, true},
+		{"badGatewayPost", context.Background(), response("POST", 502), nil, false},
+		{"notImplemented", context.Background(), response("GET", 501), nil, false},
+		{"rateLimitedPost", context.Background(), response("POST", 429), nil, true},
+		{"timeoutGet", context.Background(), nil, urlError("Get", errors.New("timeout")), true},
+		{"timeoutPost", context.Background(), nil, urlError("Post", errors.New("timeout")), false},
+		{"connectionRefusedPost", context.Background(), nil, urlError("Post", &net.OpError{Op: "dial", Err: errors.New("refused")}), true},
+		{"circuitIsOpen", context.Background(), nil, urlError("Get", breaker.CircuitIsOpen), false},
+		{"canceled", canceled, response("GET", 502), nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := checkRetry(tt.ctx, tt.resp, tt.err)
+			if got != tt.want {
+				t.Errorf("checkRetry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 8, 21, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+		wantOk bool
+	}{
+		{"none", http.Header{}, 0, false},
+		{"seconds", http.Header{"Retry-After": {"3"}}, 3 * time.Second, true},
+		{"date", http.Header{"Retry-After": {"Fri, 21 Aug 2020 10:00:05 GMT"}}, 5 * time.Second, true},
+		{"rateLimitReset", http.Header{"Ratelimit-Reset": {"1598004007"}}, 7 * time.Second, true},
+		{"wrong", http.Header{"Retry-After": {"soon"}}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := retryAfter(tt.header, now)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("retryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		wait := retryBackoff(0, 0, attempt, nil)
+		if wait <= 0 || wait > retryWaitMax {
+			t.Errorf("retryBackoff() for attempt %d = %v", attempt, wait)
+		}
+	}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": {"3600"}}}
+	if wait := retryBackoff(0, 0, 0, resp); wait != retryWaitMax {
+		t.Errorf("retryBackoff() with a long Retry-After = %v, want %v", wait, retryWaitMax)
+	}
+}
@@ -0,0 +1,518 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+var (
+	SnapshotNotFound      = newError(KindNotFound, "snapshot_not_found", "snapshot not found")
+	SnapshotAlreadyExists = newError(KindConflict, "snapshot_already_exists", "snapshot already exists")
+	SnapshotNameIsInvalid = newError(KindInvalid, "invalid_snapshot_name", "snapshot name should consist of letters, digits, '.', '_' and '-' and not start with '.'")
+	RestoreNotFound       = newError(KindNotFound, "restore_not_found", "restore not found")
+	RestoreIsInProgress   = newError(KindConflict, "restore_in_progress", "restore is in progress")
+	RestoreIsNotRunning   = newError(KindConflict, "restore_not_running", "restore is not running")
+)
+
+// snapshotNameRegex allows names which are kept as they are in URL paths, i.e. without "/" and ".."
+var snapshotNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// Statuses of a project in a diff
+const (
+	DiffIdentical = "identical"
+	DiffDifferent = "different"
+	DiffMissing   = "missing"
+)
+
+// Statuses of a project in a snapshot restore
+// A started project has a job which is tracked like any other job run from the dashboard
+const (
+	RestorePending   = "pending"
+	RestoreUnchanged = "unchanged"
+	RestoreStarted   = "started"
+	RestoreFailed    = "failed"
+	RestoreCanceled  = "canceled"
+)
+
+// restoreTimeout is how long a restore waits for its jobs, a stuck job doesn't block next restores longer
+const restoreTimeout = time.Hour
+
+// Snapshot keeps deployed refs and commits of all projects in an environment
+type Snapshot struct {
+	Name        string             `json:"name"`
+	Environment string             `json:"environment"`
+	CreatedAt   time.Time          `json:"createdAt"`
+	Projects    []*SnapshotProject `json:"projects"`
+}
+
+// SnapshotProject is a deployed state of a project
+type SnapshotProject struct {
+	ProjectID int    `json:"projectId"`
+	Name      string `json:"name"`
+	Ref       string `json:"ref"`
+	SHA       string `json:"sha"`
+}
+
+// SnapshotDiff shows a difference of a project between two snapshots
+// Left or Right is nil when the project is missing
+type SnapshotDiff struct {
+	ProjectID int              `json:"projectId"`
+	Status    string           `json:"status"`
+	Left      *SnapshotProject `json:"left"`
+	Right     *SnapshotProject `json:"right"`
+}
+
+// SnapshotRestore tracks progress of a snapshot restore
+// Error is filled when the restore was canceled or timed out
+type SnapshotRestore struct {
+	Snapshot    string                    `json:"snapshot"`
+	Environment string                    `json:"environment"`
+	User        *ProjectUser              `json:"user"`
+	StartedAt   time.Time                 `json:"startedAt"`
+	Finished    bool                      `json:"finished"`
+	Error       string                    `json:"error,omitempty"`
+	Projects    []*SnapshotRestoreProject `json:"projects"`
+
+	// cancel stops the restore, done is closed when it's finished
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// SnapshotRestoreProject tracks progress of a project restore
+type SnapshotRestoreProject struct {
+	SnapshotProject
+	Status string             `json:"status"`
+	Error  string             `json:"error,omitempty"`
+	Job    *wrappedGitLab.Job `json:"job,omitempty"`
+}
+
+// takeSnapshot builds a snapshot from the environments cache
+// Projects without deployments are not included
+func (c *Service) takeSnapshot(environment string, name string) (*Snapshot, error) {
+	env, ok := c.getEnvironment(environment)
+	if !ok {
+		return nil, EnvironmentNotFound
+	}
+
+	snapshot := &Snapshot{
+		Name:        name,
+		Environment: environment,
+		CreatedAt:   time.Now(),
+	}
+	for _, project := range env.Projects {
+		if project == nil || project.LastDeployment == nil {
+			continue
+		}
+		snapshot.Projects = append(snapshot.Projects, &SnapshotProject{
+			ProjectID: project.ID,
+			Name:      project.Name,
+			Ref:       project.LastDeployment.Ref,
+			SHA:       project.LastDeployment.SHA,
+		})
+	}
+	sort.Slice(snapshot.Projects, func(i, j int) bool {
+		return snapshot.Projects[i].ProjectID < snapshot.Projects[j].ProjectID
+	})
+
+	return snapshot, nil
+}
+
+// LoadSnapshots reads snapshots from the file and saves them there on every change
+// The file is created with the first snapshot, snapshots are only kept in memory if it's never called
+func (c *Service) LoadSnapshots(file string) error {
+	c.snapshotsMtx.Lock()
+	defer c.snapshotsMtx.Unlock()
+
+	c.snapshotsFile = file
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snapshots []*Snapshot
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		return fmt.Errorf("cannot parse %s: %v", file, err)
+	}
+	for _, snapshot := range snapshots {
+		if _, ok := c.snapshots[snapshot.Environment]; !ok {
+			c.snapshots[snapshot.Environment] = map[string]*Snapshot{}
+		}
+		c.snapshots[snapshot.Environment][snapshot.Name] = snapshot
+	}
+
+	return nil
+}
+
+// saveSnapshots writes snapshots of all environments to the file, snapshotsMtx should be locked
+// The file is replaced atomically, so a crash doesn't leave a broken file
+func (c *Service) saveSnapshots() error {
+	if c.snapshotsFile == "" {
+		return nil
+	}
+
+	snapshots := []*Snapshot{}
+	for _, environmentSnapshots := range c.snapshots {
+		for _, snapshot := range environmentSnapshots {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+	data, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.snapshotsFile), filepath.Base(c.snapshotsFile)+".*")
+	if err != nil {
+		return fmt.Errorf("cannot save snapshots: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot save snapshots: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot save snapshots: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), c.snapshotsFile); err != nil {
+		return fmt.Errorf("cannot save snapshots: %v", err)
+	}
+
+	return nil
+}
+
+// CreateSnapshot stores current deployed state of the environment under given name
+// The name is a part of snapshot URLs, so only names matched by snapshotNameRegex are accepted
+func (c *Service) CreateSnapshot(environment string, name string) (*Snapshot, error) {
+	if utils.StringsContainString(c.protectedEnvironments, environment) {
+		return nil, DeniedForProtectedEnvironment
+	}
+	if !snapshotNameRegex.MatchString(name) {
+		return nil, SnapshotNameIsInvalid
+	}
+
+	snapshot, err := c.takeSnapshot(environment, name)
+	if err != nil {
+		return nil, err
+	}
+
+	c.snapshotsMtx.Lock()
+	defer c.snapshotsMtx.Unlock()
+
+	if _, ok := c.snapshots[environment][name]; ok {
+		return nil, SnapshotAlreadyExists
+	}
+	if _, ok := c.snapshots[environment]; !ok {
+		c.snapshots[environment] = map[string]*Snapshot{}
+	}
+	c.snapshots[environment][name] = snapshot
+	if err := c.saveSnapshots(); err != nil {
+		delete(c.snapshots[environment], name)
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// GetSnapshots returns all snapshots of the environment ordered by creation time
+func (c *Service) GetSnapshots(environment string) []*Snapshot {
+	snapshots := []*Snapshot{}
+
+	c.snapshotsMtx.RLock()
+	for _, snapshot := range c.snapshots[environment] {
+		snapshots = append(snapshots, snapshot)
+	}
+	c.snapshotsMtx.RUnlock()
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+
+	return snapshots
+}
+
+// GetSnapshot returns a snapshot by name
+func (c *Service) GetSnapshot(environment string, name string) (*Snapshot, error) {
+	c.snapshotsMtx.RLock()
+	defer c.snapshotsMtx.RUnlock()
+
+	snapshot, ok := c.snapshots[environment][name]
+	if !ok {
+		return nil, SnapshotNotFound
+	}
+
+	return snapshot, nil
+}
+
+// DeleteSnapshot removes a snapshot by name
+func (c *Service) DeleteSnapshot(environment string, name string) error {
+	c.snapshotsMtx.Lock()
+	defer c.snapshotsMtx.Unlock()
+
+	snapshot, ok := c.snapshots[environment][name]
+	if !ok {
+		return SnapshotNotFound
+	}
+	delete(c.snapshots[environment], name)
+	if err := c.saveSnapshots(); err != nil {
+		c.snapshots[environment][name] = snapshot
+		return err
+	}
+
+	return nil
+}
+
+// DiffSnapshot compares a snapshot with another one
+// If `against` is empty the snapshot is compared with current state of the environment
+func (c *Service) DiffSnapshot(environment string, name string, against string) ([]*SnapshotDiff, error) {
+	left, err := c.GetSnapshot(environment, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var right *Snapshot
+	if against == "" {
+		right, err = c.takeSnapshot(environment, "")
+	} else {
+		right, err = c.GetSnapshot(environment, against)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return diffSnapshotProjects(left.Projects, right.Projects), nil
+}
+
+// diffSnapshotProjects lines up projects by ID, projects are compared by commit
+func diffSnapshotProjects(left []*SnapshotProject, right []*SnapshotProject) []*SnapshotDiff {
+	diffs := map[int]*SnapshotDiff{}
+	for _, project := range left {
+		diffs[project.ProjectID] = &SnapshotDiff{ProjectID: project.ProjectID, Left: project}
+	}
+	for _, project := range right {
+		if _, ok := diffs[project.ProjectID]; !ok {
+			diffs[project.ProjectID] = &SnapshotDiff{ProjectID: project.ProjectID}
+		}
+		diffs[project.ProjectID].Right = project
+	}
+
+	result := make([]*SnapshotDiff, 0, len(diffs))
+	for _, diff := range diffs {
+		switch {
+		case diff.Left == nil || diff.Right == nil:
+			diff.Status = DiffMissing
+		case diff.Left.SHA == diff.Right.SHA:
+			diff.Status = DiffIdentical
+		default:
+			diff.Status = DiffDifferent
+		}
+		result = append(result, diff)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ProjectID < result[j].ProjectID
+	})
+
+	return result
+}
+
+// RestoreSnapshot redeploys every project of the environment to the commit recorded in the snapshot
+// Projects are restored in background, use GetSnapshotRestore to track the progress
+// Only one restore per environment could be run at the same time, it's abandoned after restoreTimeout
+// Jobs of the restore are attributed to the user
+func (c *Service) RestoreSnapshot(ctx context.Context, environment string, name string, user *ProjectUser) (*SnapshotRestore, error) {
+	if utils.StringsContainString(c.protectedEnvironments, environment) {
+		return nil, DeniedForProtectedEnvironment
+	}
+
+	snapshot, err := c.GetSnapshot(environment, name)
+	if err != nil {
+		return nil, err
+	}
+	current, err := c.takeSnapshot(environment, "")
+	if err != nil {
+		return nil, err
+	}
+	currentSHAs := map[int]string{}
+	for _, project := range current.Projects {
+		currentSHAs[project.ProjectID] = project.SHA
+	}
+
+	background, cancel := context.WithTimeout(c.background, restoreTimeout)
+	restore := &SnapshotRestore{
+		Snapshot:    name,
+		Environment: environment,
+		User:        user,
+		StartedAt:   time.Now(),
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	for _, project := range snapshot.Projects {
+		status := RestorePending
+		// We don't need to redeploy the same commit
+		if currentSHAs[project.ProjectID] == project.SHA {
+			status = RestoreUnchanged
+		}
+		restore.Projects = append(restore.Projects, &SnapshotRestoreProject{
+			SnapshotProject: *project,
+			Status:          status,
+		})
+	}
+
+	c.snapshotsMtx.Lock()
+	if running, ok := c.restores[environment]; ok && !running.Finished {
+		c.snapshotsMtx.Unlock()
+		cancel()
+		return nil, RestoreIsInProgress
+	}
+	c.restores[environment] = restore
+	c.snapshotsMtx.Unlock()
+
+	c.backgroundWg.Add(1)
+	go c.runSnapshotRestore(background, ctx, restore)
+
+	return c.GetSnapshotRestore(environment)
+}
+
+// runSnapshotRestore plays or retries jobs of pending projects one by one and watches the started jobs
+// The restore outlives the request, so it has its own trace linked to the request one
+// Jobs are watched by their IDs, so the restore finishes even if other jobs are deployed meanwhile
+// When the restore is canceled, stopped or timed out, pending projects are canceled and it's finished
+func (c *Service) runSnapshotRestore(background context.Context, requestCtx context.Context, restore *SnapshotRestore) {
+	defer c.backgroundWg.Done()
+	defer close(restore.done)
+	defer restore.cancel()
+	ctx, span := startLinkedSpan(background, requestCtx, "Service.runSnapshotRestore", environmentKey.String(restore.Environment))
+	defer span.End()
+
+	for _, project := range restore.Projects {
+		if project.Status != RestorePending {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		_, runJob, _, err := c.playOrRetryJob(ctx, project.ProjectID, restore.Environment, project.Ref, project.SHA, restore.User)
+
+		c.snapshotsMtx.Lock()
+		if err != nil {
+			project.Status = RestoreFailed
+			project.Error = err.Error()
+		} else {
+			project.Status = RestoreStarted
+			project.Job = runJob
+		}
+		c.snapshotsMtx.Unlock()
+	}
+	if ctx.Err() == nil {
+		c.watchRestoreJobs(ctx, restore)
+	}
+
+	c.snapshotsMtx.Lock()
+	defer c.snapshotsMtx.Unlock()
+	if err := ctx.Err(); err != nil {
+		restore.Error = "restore was canceled"
+		if err == context.DeadlineExceeded {
+			restore.Error = fmt.Sprintf("restore timed out after %s", restoreTimeout)
+		}
+		for _, project := range restore.Projects {
+			if project.Status == RestorePending {
+				project.Status = RestoreCanceled
+			}
+		}
+	}
+	restore.Finished = true
+}
+
+// watchRestoreJobs checks started jobs of the restore until all of them are finished
+// A project fails when its job can't be checked jobWatcherMaxFailures times in a row
+func (c *Service) watchRestoreJobs(ctx context.Context, restore *SnapshotRestore) {
+	failures := map[int]int{}
+	for {
+		watched := 0
+		for _, project := range restore.Projects {
+			// Only this goroutine changes projects, so they are read without the lock
+			job := project.Job
+			if project.Status != RestoreStarted || utils.StringsContainString(finishedJobStatus, job.Status) {
+				continue
+			}
+			watched++
+
+			watchedJob, _, err := c.git.Jobs.GetJob(project.ProjectID, job.ID, wrappedGitLab.WithContext(ctx))
+			if ctx.Err() != nil {
+				return
+			}
+			c.snapshotsMtx.Lock()
+			if err != nil {
+				failures[project.ProjectID]++
+				log.WithContext(ctx).Errorf("cannot check restore job %d of project %d: %v", job.ID, project.ProjectID, err)
+				if failures[project.ProjectID] >= jobWatcherMaxFailures {
+					project.Status = RestoreFailed
+					project.Error = fmt.Sprintf("cannot check job %d: %v", job.ID, err)
+				}
+			} else {
+				failures[project.ProjectID] = 0
+				project.Job = watchedJob
+			}
+			c.snapshotsMtx.Unlock()
+		}
+		if watched == 0 || !sleep(ctx, c.watchInterval) {
+			return
+		}
+	}
+}
+
+// CancelSnapshotRestore stops the running restore of the environment and waits for it
+// Pending projects are not started, already started jobs keep running in GitLab
+func (c *Service) CancelSnapshotRestore(environment string) (*SnapshotRestore, error) {
+	c.snapshotsMtx.RLock()
+	restore, ok := c.restores[environment]
+	finished := ok && restore.Finished
+	c.snapshotsMtx.RUnlock()
+	if !ok {
+		return nil, RestoreNotFound
+	}
+	if finished {
+		return nil, RestoreIsNotRunning
+	}
+
+	restore.cancel()
+	<-restore.done
+
+	return c.GetSnapshotRestore(environment)
+}
+
+// GetSnapshotRestore returns progress of the last restore of the environment
+func (c *Service) GetSnapshotRestore(environment string) (*SnapshotRestore, error) {
+	c.snapshotsMtx.RLock()
+	defer c.snapshotsMtx.RUnlock()
+
+	restore, ok := c.restores[environment]
+	if !ok {
+		return nil, RestoreNotFound
+	}
+
+	// Copy the restore to not share it with the running restore
+	progress := *restore
+	progress.Projects = make([]*SnapshotRestoreProject, len(restore.Projects))
+	for i, project := range restore.Projects {
+		projectProgress := *project
+		progress.Projects[i] = &projectProgress
+	}
+
+	return &progress, nil
+}
@@ -0,0 +1,251 @@
+package gitlab
+
+import (
+	"context"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/gitlab/gitlabtest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newSnapshotTestService creates a Service with the "qa" environment of one project in the cache
+func newSnapshotTestService(t *testing.T) *Service {
+	service, server := newTestService(t, 1)
+	server.AddProject(1, "api")
+	server.AddEnvironment(1, "qa", "master", "a1")
+	if err := service.UpdateEnvironments(context.Background(), []int{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	return service
+}
+
+func TestCreateSnapshotValidatesName(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot string
+		wantErr  error
+	}{
+		{"valid", "release-42_rc.1", nil},
+		{"slash", "release/42", SnapshotNameIsInvalid},
+		{"dotDot", "..", SnapshotNameIsInvalid},
+		{"leadingDot", ".release", SnapshotNameIsInvalid},
+		{"space", "release 42", SnapshotNameIsInvalid},
+		{"empty", "", SnapshotNameIsInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newSnapshotTestService(t)
+			if _, err := service.CreateSnapshot("qa", tt.snapshot); err != tt.wantErr {
+				t.Fatalf("CreateSnapshot() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if _, err := service.GetSnapshot("qa", tt.snapshot); err != nil {
+				t.Errorf("GetSnapshot() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadSnapshots(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "snapshots.json")
+	service := newSnapshotTestService(t)
+	if err := service.LoadSnapshots(file); err != nil {
+		t.Fatalf("LoadSnapshots() of a missing file error = %v", err)
+	}
+	kept, err := service.CreateSnapshot("qa", "kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.CreateSnapshot("qa", "deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.DeleteSnapshot("qa", "deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newSnapshotTestService(t)
+	if err := reloaded.LoadSnapshots(file); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := reloaded.GetSnapshot("qa", "kept")
+	if err != nil {
+		t.Fatalf("GetSnapshot() after reload error = %v", err)
+	}
+	if !snapshot.CreatedAt.Equal(kept.CreatedAt) || !reflect.DeepEqual(snapshot.Projects, kept.Projects) {
+		t.Errorf("GetSnapshot() after reload = %+v, want %+v", snapshot, kept)
+	}
+	if _, err := reloaded.GetSnapshot("qa", "deleted"); err != SnapshotNotFound {
+		t.Errorf("GetSnapshot() of the deleted snapshot after reload error = %v, want %v", err, SnapshotNotFound)
+	}
+}
+
+func TestDiffSnapshotProjects(t *testing.T) {
+	api := &SnapshotProject{ProjectID: 1, Ref: "master", SHA: "a1"}
+	apiChanged := &SnapshotProject{ProjectID: 1, Ref: "feature/x", SHA: "b2"}
+	web := &SnapshotProject{ProjectID: 2, Ref: "master", SHA: "c3"}
+	geo := &SnapshotProject{ProjectID: 3, Ref: "master", SHA: "d4"}
+
+	tests := []struct {
+		name  string
+		left  []*SnapshotProject
+		right []*SnapshotProject
+		want  []*SnapshotDiff
+	}{
+		{
+			"identical",
+			[]*SnapshotProject{api, web},
+			[]*SnapshotProject{web, api},
+			[]*SnapshotDiff{
+				{ProjectID: 1, Status: DiffIdentical, Left: api, Right: api},
+				{ProjectID: 2, Status: DiffIdentical, Left: web, Right: web},
+			},
+		},
+		{
+			"differentAndMissing",
+			[]*SnapshotProject{api, web},
+			[]*SnapshotProject{apiChanged, geo},
+			[]*SnapshotDiff{
+				{ProjectID: 1, Status: DiffDifferent, Left: api, Right: apiChanged},
+				{ProjectID: 2, Status: DiffMissing, Left: web},
+				{ProjectID: 3, Status: DiffMissing, Right: geo},
+			},
+		},
+		{
+			"empty",
+			nil,
+			nil,
+			[]*SnapshotDiff{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffSnapshotProjects(tt.left, tt.right); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffSnapshotProjects() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newRestoreTestService creates a Service with the "before" snapshot of the "qa" environment
+// Project 1 should be redeployed from a1 by its manual job, project 2 is already deployed
+// and project 3 doesn't have a job for the snapshot commit
+func newRestoreTestService(t *testing.T) (*Service, *gitlabtest.Server, int) {
+	service, server := newTestService(t, 1, 2, 3)
+	server.AddProject(1, "api")
+	server.AddEnvironment(1, "qa", "master", "a2")
+	jobID := server.AddJob(1, server.AddPipeline(1, "master", "a1"), "qa", JobStatusManual)
+	server.AddProject(2, "web")
+	server.AddEnvironment(2, "qa", "master", "b1")
+	server.AddProject(3, "worker")
+	server.AddEnvironment(3, "qa", "master", "c2")
+	if err := service.UpdateEnvironments(context.Background(), []int{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	service.snapshots["qa"] = map[string]*Snapshot{"before": {
+		Name:        "before",
+		Environment: "qa",
+		Projects: []*SnapshotProject{
+			{ProjectID: 1, Name: "api", Ref: "master", SHA: "a1"},
+			{ProjectID: 2, Name: "web", Ref: "master", SHA: "b1"},
+			{ProjectID: 3, Name: "worker", Ref: "master", SHA: "c1"},
+		},
+	}}
+
+	return service, server, jobID
+}
+
+// waitForRestore waits until the progress of the restore satisfies the condition
+func waitForRestore(t *testing.T, service *Service, environment string, condition func(restore *SnapshotRestore) bool) *SnapshotRestore {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		restore, err := service.GetSnapshotRestore(environment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if condition(restore) {
+			return restore
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GetSnapshotRestore() = %+v, the restore didn't get the expected state", restore)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	service, server, jobID := newRestoreTestService(t)
+	user := &ProjectUser{Username: "jdoe"}
+
+	if _, err := service.GetSnapshotRestore("qa"); err != RestoreNotFound {
+		t.Fatalf("GetSnapshotRestore() before a restore error = %v, want %v", err, RestoreNotFound)
+	}
+	restore, err := service.RestoreSnapshot(context.Background(), "qa", "before", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restore.Finished || restore.User != user || restore.Projects[1].Status != RestoreUnchanged {
+		t.Errorf("RestoreSnapshot() = %+v, want a running restore by the user with unchanged project 2", restore)
+	}
+	if _, err := service.RestoreSnapshot(context.Background(), "qa", "before", user); err != RestoreIsInProgress {
+		t.Errorf("RestoreSnapshot() while running error = %v, want %v", err, RestoreIsInProgress)
+	}
+	if _, err := service.RestoreSnapshot(context.Background(), "qa", "after", user); err != SnapshotNotFound {
+		t.Errorf("RestoreSnapshot() of an unknown snapshot error = %v, want %v", err, SnapshotNotFound)
+	}
+
+	waitForRestore(t, service, "qa", func(restore *SnapshotRestore) bool {
+		return restore.Projects[0].Status == RestoreStarted
+	})
+	// Another deploy replaces the tracked job, the restore still watches its own job
+	service.jobsMtx.Lock()
+	service.jobs["qa"][1] = &wrappedGitLab.Job{ID: jobID + 100, Status: JobStatusRunning}
+	service.jobsMtx.Unlock()
+	server.SetJobStatus(1, jobID, JobStatusSuccess)
+
+	restore = waitForRestore(t, service, "qa", func(restore *SnapshotRestore) bool {
+		return restore.Finished
+	})
+	project := restore.Projects[0]
+	if project.Job == nil || project.Job.ID != jobID || project.Job.Status != JobStatusSuccess || restore.Error != "" {
+		t.Errorf("GetSnapshotRestore() project 1 = %+v, error %q, want succeeded job %d", project, restore.Error, jobID)
+	}
+	if project := restore.Projects[2]; project.Status != RestoreFailed || project.Error != JobNotFound.Error() {
+		t.Errorf("GetSnapshotRestore() project 3 = %+v, want failed with %v", project, JobNotFound)
+	}
+	if _, err := service.CancelSnapshotRestore("qa"); err != RestoreIsNotRunning {
+		t.Errorf("CancelSnapshotRestore() of a finished restore error = %v, want %v", err, RestoreIsNotRunning)
+	}
+	if _, err := service.RestoreSnapshot(context.Background(), "qa", "before", user); err != nil {
+		t.Errorf("RestoreSnapshot() after the finished one error = %v", err)
+	}
+}
+
+func TestCancelSnapshotRestore(t *testing.T) {
+	service, _, jobID := newRestoreTestService(t)
+	if _, err := service.CancelSnapshotRestore("qa"); err != RestoreNotFound {
+		t.Errorf("CancelSnapshotRestore() without a restore error = %v, want %v", err, RestoreNotFound)
+	}
+	if _, err := service.RestoreSnapshot(context.Background(), "qa", "before", nil); err != nil {
+		t.Fatal(err)
+	}
+	waitForRestore(t, service, "qa", func(restore *SnapshotRestore) bool {
+		return restore.Projects[0].Status == RestoreStarted
+	})
+
+	// The started job keeps running, so only the cancellation finishes the restore
+	restore, err := service.CancelSnapshotRestore("qa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restore.Finished || restore.Error == "" {
+		t.Errorf("CancelSnapshotRestore() = %+v, want a finished restore with an error", restore)
+	}
+	if job := restore.Projects[0].Job; job == nil || job.ID != jobID || job.Status != JobStatusPending {
+		t.Errorf("CancelSnapshotRestore() project 1 job = %+v, want pending job %d", job, jobID)
+	}
+}
@@ -0,0 +1,44 @@
+package gitlab
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("gitlab-environment-dashboard/server/pkg/gitlab")
+
+// Attributes of Service spans
+var (
+	environmentKey = attribute.Key("dashboard.environment")
+	projectIDKey   = attribute.Key("dashboard.project_id")
+	refKey         = attribute.Key("dashboard.ref")
+)
+
+// startSpan starts a span of a Service method
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan marks the span as failed in case of an error and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startLinkedSpan starts a new trace for background work which outlives the request
+// The new trace is linked to the span of the request `link`
+func startLinkedSpan(ctx context.Context, link context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(
+		ctx,
+		name,
+		trace.WithNewRoot(),
+		trace.WithAttributes(attributes...),
+		trace.WithLinks(trace.LinkFromContext(link)),
+	)
+}
@@ -1,81 +1,147 @@
 package gitlab
 
 import (
+	"context"
 	"errors"
-	log "github.com/sirupsen/logrus"
+	"fmt"
 	wrappedGitlab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/apitoken"
+	"gitlab-environment-dashboard/server/pkg/auth"
+	"gitlab-environment-dashboard/server/pkg/session"
 	"net/http"
+	"strings"
 )
 
-// UserService using for authenticate current user
-// It keeps token and do not ask GitLab twice with same token
-// Probably we need to track all tokens and clean it
-// but for first implementation it's ok
+// UserIsNotAllowed is returned with the user who isn't in allow-lists
+var UserIsNotAllowed = newError(KindForbidden, "user_not_allowed", "user is not allowed to use the dashboard")
+
+// UserService authenticates the current user by the auth provider or the API token
 type UserService struct {
-	tokens        map[string]*ProjectUser
-	gitlabService *Service
-	gitlabBaseURL string
+	provider auth.Provider
+	tokens   *apitoken.Store
+	// ownerAccess checks owners of API tokens, they could be removed from allow-lists after the token was created
+	ownerAccess *auth.AccessCache
 }
 
-func NewUserService(
-	service *Service,
-	gitlabBaseURL string,
-) *UserService {
-	return &UserService{
-		tokens:        map[string]*ProjectUser{},
-		gitlabBaseURL: gitlabBaseURL,
-		gitlabService: service,
-	}
+// NewUserService creates a service of users of the auth provider and the API tokens
+// Without the provider (OAuth is disabled) there are no users
+func NewUserService(provider auth.Provider, tokens *apitoken.Store, ownerAccess *auth.AccessCache) *UserService {
+	return &UserService{provider: provider, tokens: tokens, ownerAccess: ownerAccess}
 }
 
-func (s *UserService) GetUserFromRequest(request *http.Request) (user *ProjectUser, err error) {
-	tokenCookie, err := request.Cookie("token")
-	if err != nil && err != http.ErrNoCookie {
-		return nil, errors.New("cannot read cookies")
+// GetUserFromRequest returns the user of the provider, nil if the request isn't authenticated
+// A request with an API token acts as the owner of the token
+// UserIsNotAllowed is returned with the user when allow-lists don't have the user or the owner of the token
+func (s *UserService) GetUserFromRequest(request *http.Request) (*ProjectUser, error) {
+	user, _, err := s.GetUserWithAccess(request)
+	return user, err
+}
+
+// GetUserWithAccess does the same as GetUserFromRequest but also explains why the user is allowed or not
+// The user and the access are found by a single lookup of the session, the access is nil without the user
+func (s *UserService) GetUserWithAccess(request *http.Request) (*ProjectUser, *session.Access, error) {
+	token, err := s.GetTokenFromRequest(request)
+	if err != nil {
+		return nil, nil, err
 	}
-	if tokenCookie == nil {
-		return nil, nil
+	if token != nil {
+		return s.getTokenUser(request.Context(), token)
 	}
 
-	// Check stored token firstly
-	user = s.getStoredUser(tokenCookie.Value)
-	if user != nil {
-		return user, nil
+	sessionUser, err := s.getProviderUser(request)
+	if err != nil || sessionUser == nil {
+		return nil, nil, err
 	}
 
-	client, err := wrappedGitlab.NewOAuthClient(tokenCookie.Value, wrappedGitlab.WithBaseURL(s.gitlabBaseURL))
-	if err != nil {
-		return nil, errors.New("cannot create gitlab client")
+	user := &ProjectUser{
+		Username:  sessionUser.Username,
+		Name:      sessionUser.Name,
+		AvatarURL: sessionUser.AvatarURL,
+	}
+	if !sessionUser.Access.Allowed {
+		return user, &sessionUser.Access, UserIsNotAllowed
 	}
 
-	remoteUser, _, err := client.Users.CurrentUser()
-	if err != nil {
-		log.Error(err)
-		// Most likely is unauthorized
+	return user, &sessionUser.Access, nil
+}
+
+// GetTokenFromRequest returns the API token of `Authorization: Bearer` header, nil if there is no header
+// Unknown and revoked tokens are rejected with apitoken.TokenNotFound
+func (s *UserService) GetTokenFromRequest(request *http.Request) (*apitoken.Token, error) {
+	header := request.Header.Get("Authorization")
+	if header == "" {
 		return nil, nil
 	}
-
-	if remoteUser != nil {
-		user = &ProjectUser{
-			Username:  remoteUser.Username,
-			Name:      remoteUser.Name,
-			AvatarURL: remoteUser.AvatarURL,
-		}
-		s.storeUserToken(tokenCookie.Value, user)
+	secret := strings.TrimPrefix(header, "Bearer ")
+	if secret == header || s.tokens == nil {
+		return nil, apitoken.TokenNotFound
 	}
 
-	return
+	return s.tokens.Authenticate(secret)
 }
 
-func (s *UserService) storeUserToken(token string, user *ProjectUser) {
-	s.tokens[token] = user
+// getTokenUser returns the owner of the token when the owner is still allowed
+func (s *UserService) getTokenUser(ctx context.Context, token *apitoken.Token) (*ProjectUser, *session.Access, error) {
+	user := &ProjectUser{
+		Username:  token.Owner.Username,
+		Name:      token.Owner.Name,
+		AvatarURL: token.Owner.AvatarURL,
+		Token:     token.Name,
+	}
+	if s.ownerAccess == nil {
+		return user, &session.Access{Allowed: true}, nil
+	}
+
+	access, err := s.ownerAccess.Check(ctx, token.Owner.Username)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot check access of %s: %w", token.Owner.Username, err)
+	}
+	if !access.Allowed {
+		return user, &access, UserIsNotAllowed
+	}
+
+	return user, &access, nil
 }
 
-func (s *UserService) getStoredUser(token string) (user *ProjectUser) {
-	user, ok := s.tokens[token]
-	if !ok {
-		return nil
+func (s *UserService) getProviderUser(request *http.Request) (*session.User, error) {
+	if s.provider == nil {
+		return nil, nil
 	}
 
-	return user
+	return s.provider.User(request)
+}
+
+// NewTokenValidator creates a session.ValidateFunc which asks GitLab for the user of the OAuth token
+// and checks the user by the access policy
+// It returns session.Unauthorized when GitLab rejects the token
+func NewTokenValidator(gitlabBaseURL string, httpClient *http.Client, accessPolicy *AccessPolicy) session.ValidateFunc {
+	return func(ctx context.Context, accessToken string) (*session.User, error) {
+		client, err := wrappedGitlab.NewOAuthClient(
+			accessToken,
+			clientOptions(gitlabBaseURL, httpClient)...,
+		)
+		if err != nil {
+			return nil, errors.New("cannot create gitlab client")
+		}
+
+		remoteUser, resp, err := client.Users.CurrentUser(wrappedGitlab.WithContext(ctx))
+		if err != nil {
+			if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+				return nil, session.Unauthorized
+			}
+			return nil, err
+		}
+
+		access, err := accessPolicy.Check(ctx, remoteUser.Username)
+		if err != nil {
+			return nil, fmt.Errorf("cannot check access of %s: %w", remoteUser.Username, err)
+		}
+
+		return &session.User{
+			Username:  remoteUser.Username,
+			Name:      remoteUser.Name,
+			AvatarURL: remoteUser.AvatarURL,
+			Access:    access,
+		}, nil
+	}
 }
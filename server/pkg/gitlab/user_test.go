@@ -0,0 +1,111 @@
+package gitlab
+
+import (
+	"gitlab-environment-dashboard/server/pkg/apitoken"
+	"gitlab-environment-dashboard/server/pkg/auth"
+	"gitlab-environment-dashboard/server/pkg/session"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// countingProvider counts lookups of the user, every lookup of a session provider reads the session store
+type countingProvider struct {
+	user    *session.User
+	lookups int
+}
+
+func (p *countingProvider) Name() string {
+	return auth.ProviderGitLab
+}
+
+func (p *countingProvider) User(*http.Request) (*session.User, error) {
+	p.lookups++
+	return p.user, nil
+}
+
+func TestGetUserFromRequestWithToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		owner    string
+		header   string
+		wantUser string
+		wantErr  error
+	}{
+		{"allowedOwner", "jdoe", "", "jdoe", nil},
+		{"memberOwner", "jane", "", "jane", nil},
+		{"removedOwner", "contractor", "", "contractor", UserIsNotAllowed},
+		{"wrongToken", "jdoe", "Bearer gld_wrong", "", apitoken.TokenNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, server := newTestService(t)
+			server.AddGroupMember("platform", "jane", "active")
+			policy := NewAccessPolicy(service, []string{"jdoe"}, []string{"platform"})
+			tokens, err := apitoken.NewStore("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, secret, err := tokens.Create("ci", apitoken.Owner{Username: tt.owner}, []apitoken.Scope{apitoken.ScopeDeploy}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			userService := NewUserService(nil, tokens, auth.NewAccessCache(policy.Check, time.Minute))
+
+			request := httptest.NewRequest("GET", "/environments", nil)
+			request.Header.Set("Authorization", "Bearer "+secret)
+			if tt.header != "" {
+				request.Header.Set("Authorization", tt.header)
+			}
+			user, err := userService.GetUserFromRequest(request)
+			if err != tt.wantErr {
+				t.Fatalf("GetUserFromRequest() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantUser == "" {
+				if user != nil {
+					t.Errorf("GetUserFromRequest() = %+v, want nil", user)
+				}
+				return
+			}
+			if user == nil || user.Username != tt.wantUser || user.Token != "ci" {
+				t.Errorf("GetUserFromRequest() = %+v, want %s with the token", user, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestGetUserWithAccess(t *testing.T) {
+	tests := []struct {
+		name        string
+		user        *session.User
+		wantErr     error
+		wantReasons []string
+	}{
+		{"allowed", &session.User{Username: "jdoe", Access: session.Access{Allowed: true, Reasons: []string{"user is allowed"}}}, nil, []string{"user is allowed"}},
+		{"notAllowed", &session.User{Username: "contractor", Access: session.Access{Reasons: []string{"not in allowed users"}}}, UserIsNotAllowed, []string{"not in allowed users"}},
+		{"anonymous", nil, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &countingProvider{user: tt.user}
+			user, access, err := NewUserService(provider, nil, nil).GetUserWithAccess(httptest.NewRequest("GET", "/config", nil))
+			if err != tt.wantErr {
+				t.Fatalf("GetUserWithAccess() error = %v, want %v", err, tt.wantErr)
+			}
+			if provider.lookups != 1 {
+				t.Errorf("GetUserWithAccess() looked the user up %d times, want once", provider.lookups)
+			}
+			if tt.user == nil {
+				if user != nil || access != nil {
+					t.Errorf("GetUserWithAccess() = %+v, %+v, want nil", user, access)
+				}
+				return
+			}
+			if user == nil || user.Username != tt.user.Username || access == nil || !reflect.DeepEqual(access.Reasons, tt.wantReasons) {
+				t.Errorf("GetUserWithAccess() = %+v, %+v, want %s with %v", user, access, tt.user.Username, tt.wantReasons)
+			}
+		})
+	}
+}
@@ -1,15 +1,14 @@
 package handler
 
 import (
-	"fmt"
+	"gitlab-environment-dashboard/server/pkg/dto"
 	"gitlab-environment-dashboard/server/pkg/gitlab"
 	"github.com/gorilla/mux"
-	wrappedGitlab "github.com/xanzy/go-gitlab"
 	"net/http"
 )
 
 type branchesResponse struct {
-	Branches []*wrappedGitlab.Branch `json:"branches"`
+	Branches []*dto.Branch `json:"branches"`
 }
 
 // CreateEnvironmentHandler provides all environments
@@ -23,11 +22,11 @@ func CreateBranchHandler(git *gitlab.Service) http.HandlerFunc {
 
 		branches, err := git.GetBranches(projectID)
 		if err != nil {
-			badRequest(w, fmt.Sprintf("cannot get branches: %v", err))
+			serviceError(r.Context(), w, err, "cannot get branches")
 			return
 		}
 
-		writeResponse(w, &branchesResponse{Branches: branches})
+		writeResponse(w, &branchesResponse{Branches: dto.NewBranches(branches)})
 		return
 	}
 }
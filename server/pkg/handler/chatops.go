@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gitlab-environment-dashboard/server/pkg/chatops"
+	"gitlab-environment-dashboard/server/pkg/tracing"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Slash command payloads are small, we don't need to read more
+const maxSlashCommandBodySize = 64 * 1024
+
+// CreateChatOpsHandler runs Slack/Mattermost slash commands
+// Requests are verified by Slack `signingSecret` and/or Mattermost `token` (empty ones are not checked)
+// Slow commands are answered via `response_url` when it's given
+func CreateChatOpsHandler(executor *chatops.Executor, signingSecret string, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSlashCommandBodySize))
+		if err != nil {
+			badRequest(w, fmt.Sprintf("cannot read request body: %v", err))
+			return
+		}
+		if signingSecret != "" {
+			err = chatops.VerifySlackSignature(
+				signingSecret,
+				r.Header.Get("X-Slack-Request-Timestamp"),
+				r.Header.Get("X-Slack-Signature"),
+				body,
+				time.Now(),
+			)
+			if err != nil {
+				unauthorizedRequest(w, err.Error())
+				return
+			}
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			badRequest(w, fmt.Sprintf("cannot parse request body: %v", err))
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(form.Get("token")), []byte(token)) != 1 {
+			unauthorizedRequest(w, "wrong token")
+			return
+		}
+
+		command, err := chatops.ParseCommand(form.Get("command"), form.Get("text"))
+		if err != nil {
+			writeResponse(w, &chatops.Response{ResponseType: chatops.ResponseEphemeral, Text: err.Error()})
+			return
+		}
+		// Usernames could be changed by chat users, IDs could not
+		user, err := executor.User(form.Get("user_id"))
+		if err != nil {
+			writeResponse(w, &chatops.Response{ResponseType: chatops.ResponseEphemeral, Text: err.Error()})
+			return
+		}
+
+		responseURL := form.Get("response_url")
+		if command.Slow() && responseURL != "" {
+			// The command outlives the request, so it cannot be canceled with the request
+			ctx := tracing.Detach(r.Context())
+			go func() {
+				err := executor.Respond(ctx, responseURL, executor.Execute(ctx, command, user))
+				if err != nil {
+					log.WithContext(ctx).Errorf("cannot respond to slash command: %v", err)
+				}
+			}()
+			writeResponse(w, &chatops.Response{ResponseType: chatops.ResponseEphemeral, Text: "working on it..."})
+			return
+		}
+
+		writeResponse(w, executor.Execute(r.Context(), command, user))
+	}
+}
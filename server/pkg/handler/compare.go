@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"github.com/gorilla/mux"
+	"gitlab-environment-dashboard/server/pkg/dto"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"net/http"
+)
+
+type environmentsCompareResponse struct {
+	Left     string                          `json:"left"`
+	Right    string                          `json:"right"`
+	Projects []*gitlab.EnvironmentComparison `json:"projects"`
+}
+
+type commitsResponse struct {
+	Commits []*dto.Commit `json:"commits"`
+}
+
+// CreateCompareEnvironmentsHandler compares last deployments of `left` and `right` environments
+func CreateCompareEnvironmentsHandler(git *gitlab.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		left := query.Get("left")
+		right := query.Get("right")
+		if left == "" || right == "" {
+			badRequest(w, "please provide `left` and `right`")
+			return
+		}
+
+		comparisons, err := git.CompareEnvironments(r.Context(), left, right)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot compare environments")
+			return
+		}
+
+		writeResponse(w, &environmentsCompareResponse{Left: left, Right: right, Projects: comparisons})
+	}
+}
+
+// CreateMissingCommitsHandler provides commits of the deployed branch which are not deployed yet
+func CreateMissingCommitsHandler(git *gitlab.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+		projectID, err := getRequiredIntFromVars(w, vars, "projectID")
+		if err != nil {
+			return
+		}
+
+		commits, err := git.GetMissingCommits(r.Context(), environment, projectID)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot get missing commits")
+			return
+		}
+
+		writeResponse(w, &commitsResponse{Commits: dto.NewCommits(commits)})
+	}
+}
@@ -1,9 +1,9 @@
 package handler
 
 import (
-	"fmt"
 	"gitlab-environment-dashboard/server/pkg/config"
 	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"gitlab-environment-dashboard/server/pkg/session"
 	"net/http"
 )
 
@@ -13,14 +13,19 @@ type configResponse struct {
 	UserLinkTemplate string              `json:"userLinkTemplate"`
 	OAuthEnabled     bool                `json:"oAuthEnabled"`
 	User             *gitlab.ProjectUser `json:"user"`
+	// Access explains if the user is allowed to use the dashboard, it's null without the user
+	Access *session.Access `json:"access"`
+	// AuthProvider tells the GUI how users log in: gitlab, oidc or headers of the proxy
+	AuthProvider string `json:"authProvider"`
 }
 
 // CreateConfigHandler provides basing configuration for GUI
 func CreateConfigHandler(userService *gitlab.UserService, cfg config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, request *http.Request) {
-		user, err := userService.GetUserFromRequest(request)
-		if err != nil {
-			badRequest(w, fmt.Sprintf("cannot get user from gitlab: %v", err))
+		// Users who aren't allowed get the config too, so the GUI could show why
+		user, access, err := userService.GetUserWithAccess(request)
+		if err != nil && err != gitlab.UserIsNotAllowed {
+			serviceError(request.Context(), w, err, "cannot get user from gitlab")
 			return
 		}
 
@@ -29,7 +34,9 @@ func CreateConfigHandler(userService *gitlab.UserService, cfg config.Config) htt
 			UserLinkTemplate: cfg.UserLinkTemplate,
 			GitLabAppID:      cfg.GitLabAppID,
 			OAuthEnabled:     cfg.OAuthEnabled,
+			AuthProvider:     cfg.AuthProvider,
 			User:             user,
+			Access:           access,
 		}
 
 		writeResponse(w, &response)
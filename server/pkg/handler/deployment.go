@@ -1,17 +1,27 @@
 package handler
 
 import (
-	"fmt"
 	"github.com/gorilla/mux"
+	"gitlab-environment-dashboard/server/pkg/dto"
 	"gitlab-environment-dashboard/server/pkg/gitlab"
 	"net/http"
+	"strings"
 )
 
 type deploymentsResponse struct {
-	Deployments []*gitlab.Deployment `json:"deployments"`
+	Deployments []*dto.Deployment `json:"deployments"`
+	NextPage    int               `json:"nextPage"`
+	// NextBeforeID is the last scanned deployment, it's sent with the next page to skip scanned deployments
+	NextBeforeID int `json:"nextBeforeId,omitempty"`
 }
 
 // CreateListDeploymentHandler provides list of deployments for given projectID and environment
+// Query parameters:
+// `page`, `beforeId` and `perPage` for pagination, use `nextPage` and `nextBeforeId` from the response to get the next page
+// At most 10 GitLab pages are scanned by a request, fewer than `perPage` deployments with `nextPage` mean that the limit was reached
+// `status` is a comma separated list of statuses (success by default), `all` disables the filter
+// `updatedAfter` and `updatedBefore` in RFC 3339
+// `user` is a username of the deployer and `ref` is a deployed ref
 func CreateListDeploymentHandler(git *gitlab.Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -24,13 +34,49 @@ func CreateListDeploymentHandler(git *gitlab.Service) http.HandlerFunc {
 			return
 		}
 
-		deployments, err := git.ListProjectDeployments(environment, projectID)
+		query := r.URL.Query()
+		filter := gitlab.DeploymentsFilter{
+			Username: query.Get("user"),
+			Ref:      query.Get("ref"),
+			Statuses: []string{gitlab.JobStatusSuccess},
+		}
+		if status := query.Get("status"); status == "all" {
+			filter.Statuses = nil
+		} else if status != "" {
+			filter.Statuses = strings.Split(status, ",")
+		}
+		filter.Page, err = getOptionalIntFromQuery(w, query, "page")
+		if err != nil {
+			return
+		}
+		filter.BeforeID, err = getOptionalIntFromQuery(w, query, "beforeId")
+		if err != nil {
+			return
+		}
+		filter.PerPage, err = getOptionalIntFromQuery(w, query, "perPage")
+		if err != nil {
+			return
+		}
+		filter.UpdatedAfter, err = getOptionalTimeFromQuery(w, query, "updatedAfter")
+		if err != nil {
+			return
+		}
+		filter.UpdatedBefore, err = getOptionalTimeFromQuery(w, query, "updatedBefore")
+		if err != nil {
+			return
+		}
+
+		deployments, next, err := git.ListProjectDeployments(r.Context(), environment, projectID, filter)
 		if err != nil {
-			badRequest(w, fmt.Sprintf("cannot get deployments: %v", err))
+			serviceError(r.Context(), w, err, "cannot get deployments")
 			return
 		}
 
-		writeResponse(w, &deploymentsResponse{Deployments: deployments})
+		writeResponse(w, &deploymentsResponse{
+			Deployments:  dto.NewDeployments(deployments),
+			NextPage:     next.Page,
+			NextBeforeID: next.BeforeID,
+		})
 		return
 	}
 }
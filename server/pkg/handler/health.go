@@ -1,10 +1,111 @@
 package handler
 
 import (
+	"gitlab-environment-dashboard/server/pkg/breaker"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
 	"net/http"
+	"time"
 )
 
-func CreateHealthHandler() http.HandlerFunc {
+type healthResponse struct {
+	GitLab gitLabHealth `json:"gitlab"`
+}
+
+type gitLabHealth struct {
+	// CircuitBreaker is open when GitLab is unavailable, requests to GitLab fail immediately
+	CircuitBreaker string `json:"circuitBreaker"`
+}
+
+type livenessResponse struct {
+	Status string `json:"status"`
+}
+
+type readinessResponse struct {
+	Ready        bool            `json:"ready"`
+	Environments cacheReadiness  `json:"environments"`
+	Branches     cacheReadiness  `json:"branches"`
+	GitLab       gitLabReadiness `json:"gitlab"`
+}
+
+type cacheReadiness struct {
+	// Populated is false until the first successful refresh
+	Populated   bool       `json:"populated"`
+	LastRefresh *time.Time `json:"lastRefresh"`
+	AgeSeconds  *int64     `json:"ageSeconds"`
+	// Stale is true when the last refresh is older than allowed
+	Stale bool `json:"stale"`
+}
+
+type gitLabReadiness struct {
+	Reachable      bool      `json:"reachable"`
+	TokenValid     bool      `json:"tokenValid"`
+	Error          string    `json:"error,omitempty"`
+	CheckedAt      time.Time `json:"checkedAt"`
+	CircuitBreaker string    `json:"circuitBreaker"`
+}
+
+func CreateHealthHandler(gitLabBreaker *breaker.Breaker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, &healthResponse{
+			GitLab: gitLabHealth{CircuitBreaker: gitLabBreaker.State()},
+		})
+	}
+}
+
+// CreateLivenessHandler responds while the server is able to handle requests
+// It doesn't depend on GitLab, so the orchestrator doesn't restart the dashboard when GitLab is down
+func CreateLivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, &livenessResponse{Status: "ok"})
+	}
+}
+
+// CreateReadinessHandler responds with 200 when caches are fresh and GitLab accepts the token, otherwise with 503
+// Caches are stale when they weren't refreshed successfully for `maxRefreshAge`, failed refreshes don't count
+func CreateReadinessHandler(gitLabService *gitlab.Service, gitLabBreaker *breaker.Breaker, maxRefreshAge time.Duration) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		environmentsUpdatedAt, branchesUpdatedAt := gitLabService.LastUpdates()
+		status := gitLabService.CheckGitLab(r.Context())
+
+		response := readinessResponse{
+			Environments: newCacheReadiness(environmentsUpdatedAt, now, maxRefreshAge),
+			Branches:     newCacheReadiness(branchesUpdatedAt, now, maxRefreshAge),
+			GitLab: gitLabReadiness{
+				Reachable:      status.Reachable,
+				TokenValid:     status.TokenValid,
+				Error:          status.Error,
+				CheckedAt:      status.CheckedAt,
+				CircuitBreaker: gitLabBreaker.State(),
+			},
+		}
+		response.Ready = response.Environments.ready() && response.Branches.ready() &&
+			status.Reachable && status.TokenValid
+
+		code := http.StatusOK
+		if !response.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeResponseWithCode(w, &response, code)
 	}
 }
+
+func newCacheReadiness(updatedAt time.Time, now time.Time, maxRefreshAge time.Duration) cacheReadiness {
+	if updatedAt.IsZero() {
+		return cacheReadiness{}
+	}
+
+	age := now.Sub(updatedAt)
+	ageSeconds := int64(age / time.Second)
+
+	return cacheReadiness{
+		Populated:   true,
+		LastRefresh: &updatedAt,
+		AgeSeconds:  &ageSeconds,
+		Stale:       age > maxRefreshAge,
+	}
+}
+
+func (c cacheReadiness) ready() bool {
+	return c.Populated && !c.Stale
+}
@@ -4,13 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
-	gitlab2 "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/dto"
 	"gitlab-environment-dashboard/server/pkg/gitlab"
 	"net/http"
 )
 
 type jobResponse struct {
-	Job *gitlab2.Job `json:"job"`
+	Job          *dto.Job                `json:"job"`
+	Start        *gitlab.JobStart        `json:"start,omitempty"`
+	Cancellation *gitlab.JobCancellation `json:"cancellation,omitempty"`
 }
 
 type playJobRequestBody struct {
@@ -18,15 +20,35 @@ type playJobRequestBody struct {
 }
 
 type playJobsRequestBody struct {
-	Query string `json:"query"`
+	Query        string         `json:"query"`
+	Match        string         `json:"match"`
+	FallbackRef  string         `json:"fallbackRef"`
+	FallbackRefs map[int]string `json:"fallbackRefs"`
+	DryRun       bool           `json:"dryRun"`
 }
 
 type jobsListResponse struct {
-	Jobs map[string]map[int]*gitlab2.Job `json:"jobs"`
+	Jobs map[string]map[int]*dto.Job `json:"jobs"`
+}
+
+type queryDeployResponse struct {
+	Error string `json:"error,omitempty"`
+	// Code is set with the error like the one of errorResponse
+	Code     string                   `json:"code,omitempty"`
+	Projects []*dto.QueryDeployResult `json:"projects"`
+}
+
+type jobsCancelResponse struct {
+	Projects []*dto.JobCancelResult `json:"projects"`
+}
+
+type jobsPreviewResponse struct {
+	Projects []*gitlab.QueryDeployPreview `json:"projects"`
 }
 
 // CreatePlayJobHandler plays or retries a job for given projectId and environment
-func CreatePlayJobHandler(git *gitlab.Service) http.HandlerFunc {
+// The current user is recorded as the one who started the job
+func CreatePlayJobHandler(git *gitlab.Service, userService *gitlab.UserService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		environment, err := getRequiredStringFromVars(w, vars, "environment")
@@ -48,13 +70,19 @@ func CreatePlayJobHandler(git *gitlab.Service) http.HandlerFunc {
 			badRequest(w, "ref is empty")
 			return
 		}
-		deployment, err := git.PlayOrRetryJob(projectID, environment, requestBody.Ref)
+		user, err := userService.GetUserFromRequest(r)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot get user from gitlab")
+			return
+		}
+		deployment, err := git.PlayOrRetryJob(r.Context(), projectID, environment, requestBody.Ref, user)
 		if err != nil {
-			badRequest(w, fmt.Sprintf("cannot create job: %v", err))
+			serviceError(r.Context(), w, err, "cannot create job")
 			return
 		}
+		start, _ := git.GetJobStart(environment, projectID)
 
-		writeResponse(w, &jobResponse{Job: deployment})
+		writeResponse(w, &jobResponse{Job: dto.NewJob(deployment), Start: start})
 		return
 	}
 }
@@ -73,15 +101,22 @@ func CreateGetJobHandler(git *gitlab.Service) http.HandlerFunc {
 		}
 
 		job, _ := git.GetJob(environment, projectID)
+		start, _ := git.GetJobStart(environment, projectID)
+		cancellation, _ := git.GetJobCancellation(environment, projectID)
 
-		writeResponse(w, &jobResponse{Job: job})
+		writeResponse(w, &jobResponse{Job: dto.NewJob(job), Start: start, Cancellation: cancellation})
 		return
 	}
 }
 
 // CreatePlayJobsByQueryHandler plays or retries a job for given query
-// Query is substring for branch name
-func CreatePlayJobsByQueryHandler(git *gitlab.Service) http.HandlerFunc {
+// Query is matched with branch names by `match` mode (prefix by default)
+// Glob and regex modes match cached branches, GitLab can't search by them
+// Projects without a matched branch get `fallbackRef` (or `fallbackRefs` by project ID) when it's given
+// It responds with the outcome of every project, 207 status means partial failure
+// 502 and 503 mean nothing was run because GitLab failed for every failed project
+// With `dryRun` it only returns what would be run without touching GitLab jobs
+func CreatePlayJobsByQueryHandler(git *gitlab.Service, userService *gitlab.UserService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		environment, err := getRequiredStringFromVars(w, vars, "environment")
@@ -98,18 +133,65 @@ func CreatePlayJobsByQueryHandler(git *gitlab.Service) http.HandlerFunc {
 			badRequest(w, "query is empty")
 			return
 		}
-		if len(requestBody.Query) < 3 {
-			badRequest(w, "query is too small (min 3 symbols)")
+		if len(requestBody.Query) < gitlab.MinQueryLength {
+			badRequest(w, fmt.Sprintf("query is too small (min %d symbols)", gitlab.MinQueryLength))
+			return
+		}
+
+		options := gitlab.QueryDeployOptions{
+			Query:        requestBody.Query,
+			Match:        requestBody.Match,
+			FallbackRef:  requestBody.FallbackRef,
+			FallbackRefs: requestBody.FallbackRefs,
+		}
+		if requestBody.DryRun {
+			previews, err := git.PreviewJobsWithQuery(r.Context(), environment, options)
+			if err != nil {
+				serviceError(r.Context(), w, err, "cannot preview jobs")
+				return
+			}
+
+			writeResponse(w, &jobsPreviewResponse{Projects: previews})
 			return
 		}
 
-		err = git.PlayOrRetryJobsWithQuery(environment, requestBody.Query)
+		user, err := userService.GetUserFromRequest(r)
 		if err != nil {
-			badRequest(w, fmt.Sprintf("cannot start jobs: %v", err))
+			serviceError(r.Context(), w, err, "cannot get user from gitlab")
+			return
+		}
+		results, err := git.PlayOrRetryJobsWithQuery(r.Context(), environment, options, user)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot start jobs")
 			return
 		}
 
-		writeResponse(w, &jobsListResponse{Jobs: git.GetJobs()})
+		// Some projects could be deployed while others failed
+		// so we always give the outcome of every project
+		// Nothing deployed because of GitLab is reported with the status of the GitLab failure
+		upstreamErr := results.UpstreamError()
+		switch {
+		case results.Deployed() == 0 && upstreamErr != nil:
+			writeResponseWithCode(w, &queryDeployResponse{
+				Error:    fmt.Sprintf("nothing was run: %v", upstreamErr),
+				Code:     upstreamErr.Code,
+				Projects: dto.NewQueryDeployResults(results),
+			}, errorKindStatusCodes[upstreamErr.Kind])
+		case results.Deployed() == 0:
+			writeResponseWithCode(w, &queryDeployResponse{
+				Error:    "nothing was run",
+				Code:     "nothing_deployed",
+				Projects: dto.NewQueryDeployResults(results),
+			}, http.StatusUnprocessableEntity)
+		case results.Failed() > 0:
+			writeResponseWithCode(w, &queryDeployResponse{
+				Error:    "some jobs cannot be started",
+				Code:     "partially_deployed",
+				Projects: dto.NewQueryDeployResults(results),
+			}, http.StatusMultiStatus)
+		default:
+			writeResponse(w, &queryDeployResponse{Projects: dto.NewQueryDeployResults(results)})
+		}
 		return
 	}
 }
@@ -119,7 +201,61 @@ func CreateListJobsHandler(git *gitlab.Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		jobs := git.GetJobs()
 
-		writeResponse(w, &jobsListResponse{Jobs: jobs})
+		writeResponse(w, &jobsListResponse{Jobs: dto.NewJobs(jobs)})
 		return
 	}
 }
+
+// CreateCancelJobHandler cancels the running job for given environment and projectID
+// The current user is recorded as the one who canceled the job
+func CreateCancelJobHandler(git *gitlab.Service, userService *gitlab.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+		projectID, err := getRequiredIntFromVars(w, vars, "projectID")
+		if err != nil {
+			return
+		}
+		user, err := userService.GetUserFromRequest(r)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot get user from gitlab")
+			return
+		}
+
+		job, err := git.CancelJob(r.Context(), environment, projectID, user)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot cancel job")
+			return
+		}
+		cancellation, _ := git.GetJobCancellation(environment, projectID)
+
+		writeResponse(w, &jobResponse{Job: dto.NewJob(job), Cancellation: cancellation})
+	}
+}
+
+// CreateCancelJobsHandler cancels all running jobs for given environment
+func CreateCancelJobsHandler(git *gitlab.Service, userService *gitlab.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+		user, err := userService.GetUserFromRequest(r)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot get user from gitlab")
+			return
+		}
+
+		results, err := git.CancelJobs(r.Context(), environment, user)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot cancel jobs")
+			return
+		}
+
+		writeResponse(w, &jobsCancelResponse{Projects: dto.NewJobCancelResults(results)})
+	}
+}
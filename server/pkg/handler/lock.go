@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"net/http"
+)
+
+type lockRequestBody struct {
+	Reason string `json:"reason"`
+}
+
+type lockResponse struct {
+	Lock *gitlab.EnvironmentLock `json:"lock"`
+}
+
+// CreateGetLockHandler provides the lock of given environment, the lock is null if it's not locked
+func CreateGetLockHandler(git *gitlab.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+
+		lock, _ := git.GetEnvironmentLock(environment)
+
+		writeResponse(w, &lockResponse{Lock: lock})
+	}
+}
+
+// CreateLockHandler locks given environment by the current user
+func CreateLockHandler(git *gitlab.Service, userService *gitlab.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+		requestBody := lockRequestBody{}
+		err = json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			badRequest(w, fmt.Sprintf("cannot parse request body: %v", err))
+			return
+		}
+		user, err := userService.GetUserFromRequest(r)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot get user from gitlab")
+			return
+		}
+
+		lock, err := git.LockEnvironment(environment, user, requestBody.Reason)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot lock environment")
+			return
+		}
+
+		writeResponse(w, &lockResponse{Lock: lock})
+	}
+}
+
+// CreateUnlockHandler unlocks given environment
+func CreateUnlockHandler(git *gitlab.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+
+		err = git.UnlockEnvironment(environment)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot unlock environment")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"net/http"
+	"time"
+)
+
+const (
+	logFollowInterval = 3 * time.Second
+	// logFollowWriteTimeout is a deadline of writing every record of the follow mode
+	// It replaces the server WriteTimeout, so the stream isn't cut after it while slow clients are still dropped
+	logFollowWriteTimeout = 15 * time.Second
+)
+
+type jobLogResponse struct {
+	Log *gitlab.JobLog `json:"log"`
+}
+
+// CreateJobLogHandler provides the trace of the job run from the dashboard for given environment and projectID
+// Query parameters:
+// `offset` is a byte offset to read the trace from, use `offset` from the response for the next read
+// `ansi=strip` removes colours and sections from the trace
+// `follow=1` streams parts of the trace as JSON lines until the job is finished,
+// the stream isn't limited by the request timeout, the client reconnects with the last offset if it's broken
+func CreateJobLogHandler(git *gitlab.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+		projectID, err := getRequiredIntFromVars(w, vars, "projectID")
+		if err != nil {
+			return
+		}
+		query := r.URL.Query()
+		offset, err := getOptionalIntFromQuery(w, query, "offset")
+		if err != nil {
+			return
+		}
+		stripANSI := query.Get("ansi") == "strip"
+
+		jobLog, err := git.GetJobLog(r.Context(), environment, projectID, offset, stripANSI)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot get job log")
+			return
+		}
+
+		if query.Get("follow") != "1" {
+			writeResponse(w, &jobLogResponse{Log: jobLog})
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			badRequest(w, "streaming is not supported")
+			return
+		}
+		w.Header().Add("content-type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		ctx := withoutTimeout(r)
+		for {
+			err = extendWriteDeadline(r, logFollowWriteTimeout)
+			if err != nil {
+				log.WithContext(ctx).Println(err)
+				return
+			}
+			err = encoder.Encode(&jobLogResponse{Log: jobLog})
+			if err != nil {
+				log.WithContext(ctx).Println(err)
+				return
+			}
+			flusher.Flush()
+
+			if jobLog.Finished {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(logFollowInterval):
+			}
+
+			jobLog, err = git.GetJobLog(ctx, environment, projectID, jobLog.Offset, stripANSI)
+			if err != nil {
+				log.WithContext(ctx).Println(err)
+				return
+			}
+		}
+	}
+}
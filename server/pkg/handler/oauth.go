@@ -1,85 +1,116 @@
 package handler
 
 import (
-	"encoding/json"
+	"crypto/subtle"
 	"fmt"
+	"gitlab-environment-dashboard/server/pkg/apitoken"
 	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"gitlab-environment-dashboard/server/pkg/oauth"
+	"gitlab-environment-dashboard/server/pkg/session"
 	log "github.com/sirupsen/logrus"
-	"io/ioutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"net/http"
 	"strings"
 	"time"
 )
 
 const (
-	TokenCookieName = "token"
+	// loginCookieName keeps the state and the PKCE verifier of a started login
+	loginCookieName = "oauth_login"
+	loginLifetime   = 10 * time.Minute
 )
 
-type tokenResponse struct {
-	AccessToken  string `json:"access_token"`
-	TokenType    string `json:"token_type"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresIn    int    `json:"expires_in"`
+// CreateLoginHandler starts the login, it redirects to the provider with a new state and a PKCE challenge
+// The state and the verifier are kept in a short-lived cookie until the provider redirects back
+func CreateLoginHandler(oauthClient *oauth.Client, sslEnabled bool, cookieSecured bool) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		state, err := oauth.NewState()
+		if err != nil {
+			serviceError(request.Context(), writer, err, "cannot start login")
+			return
+		}
+		verifier, err := oauth.NewVerifier()
+		if err != nil {
+			serviceError(request.Context(), writer, err, "cannot start login")
+			return
+		}
+
+		http.SetCookie(writer, &http.Cookie{
+			Name:     loginCookieName,
+			Value:    state + "." + verifier,
+			Path:     "/oauth",
+			MaxAge:   int(loginLifetime / time.Second),
+			Secure:   cookieSecured,
+			HttpOnly: true,
+			// The cookie should be sent when the provider redirects back
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(
+			writer,
+			request,
+			oauthClient.AuthorizeURL(redirectURL(request, sslEnabled), state, oauth.Challenge(verifier)),
+			http.StatusTemporaryRedirect,
+		)
+	}
 }
 
-func CreateOauthHandler(gitLabBaseUrl string, gitLabAppID string, gitLabSecret string, sslEnabled bool, cookieSecured bool) http.HandlerFunc {
+// CreateOauthHandler handles the redirect from the provider, GitLab or OpenID Connect one
+// It checks the state of the login, exchanges the code to tokens and creates a session with them
+func CreateOauthHandler(oauthClient *oauth.Client, sessions *session.Manager, sslEnabled bool, cookieSecured bool) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		query := request.URL.Query()
 		// Check errors first
 		// If any error occurred we redirect to rhe main page
 		errorMessage := query.Get("error")
 		if errorMessage != "" {
-			log.Errorf("Error authentication: %s", query.Encode())
+			log.WithContext(request.Context()).Errorf("Error authentication: %s, %s", errorMessage, query.Get("error_description"))
 			http.Redirect(writer, request, "/", http.StatusTemporaryRedirect)
 			return
 		}
 
-		code := query.Get("code")
-
-		scheme := "http"
-		if sslEnabled {
-			scheme = "https"
-		}
-
-		redirectUrl := scheme + "://" + request.Host + request.URL.Path
-		log.Info(redirectUrl)
-
-		requestUrl := fmt.Sprintf(
-			"%s/oauth/token?client_id=%s&client_secret=%s&code=%s&grant_type=authorization_code&redirect_uri=%s",
-			gitLabBaseUrl,
-			gitLabAppID,
-			gitLabSecret,
-			code,
-			redirectUrl,
-		)
-		resp, err := http.Post(requestUrl, "application/json", strings.NewReader(""))
-		if err != nil {
-			log.Error(err)
-			badRequest(writer, "cannot get token")
+		// The login is finished anyway, its state can't be used twice
+		loginCookie, _ := request.Cookie(loginCookieName)
+		http.SetCookie(writer, &http.Cookie{
+			Name:     loginCookieName,
+			Value:    "",
+			Path:     "/oauth",
+			MaxAge:   -1,
+			Secure:   cookieSecured,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		if loginCookie == nil {
+			badRequest(writer, "login is not started or expired, please log in again")
 			return
 		}
-		if resp.StatusCode != http.StatusOK {
-			body, err := ioutil.ReadAll(resp.Body)
-			log.Printf("%v,%v", string(body), err)
-			badRequest(writer, "authorization failed, url: "+requestUrl+",code: "+string(resp.StatusCode)+",body: "+string(body))
+		parts := strings.SplitN(loginCookie.Value, ".", 2)
+		state := query.Get("state")
+		if len(parts) != 2 || state == "" || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(state)) != 1 {
+			badRequest(writer, "invalid state, please log in again")
 			return
 		}
 
-		tokenResponse := tokenResponse{}
-		body, _ := ioutil.ReadAll(resp.Body)
-		log.Println(string(body))
-		err = json.NewDecoder(strings.NewReader(string(body))).Decode(&tokenResponse)
+		redirectURI := redirectURL(request, sslEnabled)
+		token, err := oauthClient.Exchange(request.Context(), query.Get("code"), parts[1], redirectURI)
 		if err != nil {
-			log.Error(err)
-			badRequest(writer, "cannot decode token")
+			log.WithContext(request.Context()).Errorf("cannot get token: %v", err)
+			badRequest(writer, fmt.Sprintf("cannot get token: %v", err))
+			return
+		}
+		userSession, signedID, err := sessions.Create(request.Context(), token, redirectURI)
+		if err != nil {
+			log.WithContext(request.Context()).Errorf("cannot create session: %v", err)
+			serviceError(request.Context(), writer, err, "cannot create session")
+			return
 		}
 
 		http.SetCookie(writer, &http.Cookie{
-			Name:     TokenCookieName,
-			Value:    tokenResponse.AccessToken,
-			Domain:   "*",
+			Name:     session.CookieName,
+			Value:    signedID,
 			Path:     "/",
-			Expires:  time.Now().Add(7600 * time.Second),
+			Expires:  userSession.ExpiresAt,
 			Secure:   cookieSecured,
 			HttpOnly: true,
 			SameSite: http.SameSiteStrictMode,
@@ -88,16 +119,19 @@ func CreateOauthHandler(gitLabBaseUrl string, gitLabAppID string, gitLabSecret s
 	}
 }
 
-func CreateLogoutHandler(cookieSecured bool) http.HandlerFunc {
+// CreateLogoutHandler revokes the session
+func CreateLogoutHandler(sessions *session.Manager, cookieSecured bool) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
+		if sessionCookie, err := request.Cookie(session.CookieName); err == nil {
+			sessions.RevokeSigned(sessionCookie.Value)
+		}
 		http.SetCookie(
 			writer,
 			&http.Cookie{
-				Name:     TokenCookieName,
+				Name:     session.CookieName,
 				Value:    "",
-				Expires:  time.Now(),
-				Domain:   "*",
 				Path:     "/",
+				MaxAge:   -1,
 				Secure:   cookieSecured,
 				HttpOnly: true,
 				SameSite: http.SameSiteStrictMode,
@@ -106,17 +140,71 @@ func CreateLogoutHandler(cookieSecured bool) http.HandlerFunc {
 	}
 }
 
+// redirectURL is the callback of the login, it should be the same in all requests of a login
+func redirectURL(request *http.Request, sslEnabled bool) string {
+	scheme := "http"
+	if sslEnabled {
+		scheme = "https"
+	}
+
+	return scheme + "://" + request.Host + "/oauth/code"
+}
+
+// accessDeniedMessage explains why the user isn't allowed
+func accessDeniedMessage(access *session.Access) string {
+	if access == nil || len(access.Reasons) == 0 {
+		return gitlab.UserIsNotAllowed.Error()
+	}
+
+	return fmt.Sprintf("%v: %s", gitlab.UserIsNotAllowed, strings.Join(access.Reasons, "; "))
+}
+
+var tracer = otel.Tracer("gitlab-environment-dashboard/server/pkg/handler")
+
+// CreateAuthMiddleware lets only authorized users through
+// API tokens are let through when their scopes and environments allow the request
+// Authorization is traced in its own span because it could ask GitLab for the user
 func CreateAuthMiddleware(userService *gitlab.UserService, handler http.Handler) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		user, err := userService.GetUserFromRequest(request)
+		ctx, span := tracer.Start(request.Context(), "auth")
+		token, err := userService.GetTokenFromRequest(request)
+		if err == apitoken.TokenNotFound {
+			span.SetStatus(codes.Error, "unauthorized")
+			span.End()
+			unauthorizedRequest(writer, "invalid token")
+			return
+		}
+		if token != nil {
+			span.SetAttributes(attribute.String("dashboard.token", token.ID))
+			if !checkTokenScope(writer, request, token, requiredScope(request)) {
+				span.SetStatus(codes.Error, "forbidden")
+				span.End()
+				return
+			}
+		}
+		user, access, err := userService.GetUserWithAccess(request.WithContext(ctx))
+		if err == gitlab.UserIsNotAllowed {
+			span.SetAttributes(attribute.String("dashboard.user", user.Username))
+			span.SetStatus(codes.Error, "forbidden")
+			span.End()
+			forbiddenRequest(writer, accessDeniedMessage(access))
+			return
+		}
 		if err != nil {
-			badRequest(writer, fmt.Sprintf("cannot authorize user: %v", err))
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			span.End()
+			serviceError(request.Context(), writer, err, "cannot authorize user")
 			return
 		}
 		if user == nil {
+			span.SetStatus(codes.Error, "unauthorized")
+			span.End()
 			unauthorizedRequest(writer, "unauthorized")
 			return
 		}
+		span.SetAttributes(attribute.String("dashboard.user", user.Username))
+		span.End()
 
 		handler.ServeHTTP(writer, request)
 	}
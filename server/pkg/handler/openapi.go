@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"gitlab-environment-dashboard/server/pkg/openapi"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// CreateOpenAPIHandler serves the specification of the API v1
+func CreateOpenAPIHandler(document *openapi.Document) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("content-type", "application/json")
+		_, err := w.Write(document.JSON())
+		if err != nil {
+			log.WithContext(r.Context()).Println(err)
+		}
+	}
+}
+
+// CreateValidationMiddleware validates JSON bodies of requests against the specification
+// The operation is found by the route, so legacy routes are validated like the ones of v1
+// With `validateResponses` JSON responses are validated too, mismatches are only logged
+// because the response has been sent already, it's meant to catch a drift of the specification
+func CreateValidationMiddleware(document *openapi.Document, validateResponses bool, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		pathTemplate, err := route.GetPathTemplate()
+		if err != nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		operation, ok := document.Operation(r.Method, pathTemplate)
+		if !ok {
+			log.WithContext(r.Context()).Warnf("%s %s isn't described by the openapi specification", r.Method, pathTemplate)
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if schema := operation.RequestSchema(); schema != nil {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				badRequest(w, fmt.Sprintf("cannot read request body: %v", err))
+				return
+			}
+			err = document.ValidateJSON(schema, body)
+			if err != nil {
+				response := &errorResponse{Error: fmt.Sprintf("invalid request body: %v", err), Code: codeInvalidRequestBody}
+				if validationErr, ok := err.(*openapi.ValidationError); ok && validationErr.Path != "" {
+					response.Details = map[string]interface{}{"field": validationErr.Path}
+				}
+				writeErrorResponse(r.Context(), w, response, http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		if !validateResponses {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, code: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+		// Streams of the job log aren't JSON documents
+		if !strings.HasPrefix(recorder.Header().Get("content-type"), "application/json") {
+			return
+		}
+		schema, documented := operation.ResponseSchema(recorder.code)
+		if !documented {
+			log.WithContext(r.Context()).Errorf("%s %s responded with undocumented %d", r.Method, pathTemplate, recorder.code)
+			return
+		}
+		if schema == nil {
+			return
+		}
+		err = document.ValidateJSON(schema, recorder.body.Bytes())
+		if err != nil {
+			log.WithContext(r.Context()).Errorf("%s %s response %d doesn't match the openapi specification: %v", r.Method, pathTemplate, recorder.code, err)
+		}
+	}
+}
+
+// responseRecorder keeps a copy of the response which is written to the client
+type responseRecorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"github.com/gorilla/mux"
+	"gitlab-environment-dashboard/server/pkg/apitoken"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"gitlab-environment-dashboard/server/pkg/session"
+	"gitlab-environment-dashboard/server/pkg/utils"
+	"net/http"
+)
+
+type sessionsResponse struct {
+	Sessions []*session.Session `json:"sessions"`
+}
+
+// CreateAdminMiddleware lets only admins through
+// API tokens of admins should have `admin` scope
+func CreateAdminMiddleware(userService *gitlab.UserService, admins []string, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := userService.GetTokenFromRequest(r)
+		if err == apitoken.TokenNotFound {
+			unauthorizedRequest(w, "invalid token")
+			return
+		}
+		if token != nil && !checkTokenScope(w, r, token, apitoken.ScopeAdmin) {
+			return
+		}
+		user, access, err := userService.GetUserWithAccess(r)
+		if err == gitlab.UserIsNotAllowed {
+			forbiddenRequest(w, accessDeniedMessage(access))
+			return
+		}
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot authorize user")
+			return
+		}
+		if user == nil {
+			unauthorizedRequest(w, "unauthorized")
+			return
+		}
+		if !utils.StringsContainString(admins, user.Username) {
+			forbiddenRequest(w, "only admins are allowed")
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// CreateListSessionsHandler provides active sessions, the newest first
+func CreateListSessionsHandler(sessions *session.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, &sessionsResponse{Sessions: sessions.List()})
+	}
+}
+
+// CreateRevokeSessionHandler logs out the user of the session
+func CreateRevokeSessionHandler(sessions *session.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, err := getRequiredStringFromVars(w, vars, "id")
+		if err != nil {
+			return
+		}
+
+		err = sessions.Revoke(id)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot revoke session")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"gitlab-environment-dashboard/server/pkg/dto"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"net/http"
+)
+
+type snapshotRequestBody struct {
+	Name string `json:"name"`
+}
+
+type snapshotResponse struct {
+	Snapshot *gitlab.Snapshot `json:"snapshot"`
+}
+
+type snapshotsResponse struct {
+	Snapshots []*gitlab.Snapshot `json:"snapshots"`
+}
+
+type snapshotDiffResponse struct {
+	Projects []*gitlab.SnapshotDiff `json:"projects"`
+}
+
+type snapshotRestoreResponse struct {
+	Restore *dto.SnapshotRestore `json:"restore"`
+}
+
+// CreateListSnapshotsHandler provides all snapshots of given environment
+func CreateListSnapshotsHandler(git *gitlab.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+
+		writeResponse(w, &snapshotsResponse{Snapshots: git.GetSnapshots(environment)})
+	}
+}
+
+// CreateSnapshotHandler stores deployed refs of given environment under given name
+func CreateSnapshotHandler(git *gitlab.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+
+		requestBody := snapshotRequestBody{}
+		err = json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			badRequest(w, fmt.Sprintf("cannot parse request body: %v", err))
+			return
+		}
+		if requestBody.Name == "" {
+			badRequest(w, "name is empty")
+			return
+		}
+
+		snapshot, err := git.CreateSnapshot(environment, requestBody.Name)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot create snapshot")
+			return
+		}
+
+		writeResponse(w, &snapshotResponse{Snapshot: snapshot})
+	}
+}
+
+// CreateGetSnapshotHandler provides a snapshot for given environment and name
+func CreateGetSnapshotHandler(git *gitlab.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+		name, err := getRequiredStringFromVars(w, vars, "name")
+		if err != nil {
+			return
+		}
+
+		snapshot, err := git.GetSnapshot(environment, name)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot get snapshot")
+			return
+		}
+
+		writeResponse(w, &snapshotResponse{Snapshot: snapshot})
+	}
+}
+
+// CreateDeleteSnapshotHandler removes a snapshot for given environment and name
+func CreateDeleteSnapshotHandler(git *gitlab.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+		name, err := getRequiredStringFromVars(w, vars, "name")
+		if err != nil {
+			return
+		}
+
+		err = git.DeleteSnapshot(environment, name)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot delete snapshot")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// CreateDiffSnapshotHandler compares a snapshot with `against` snapshot
+// or with current state of the environment if `against` is not given
+func CreateDiffSnapshotHandler(git *gitlab.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+		name, err := getRequiredStringFromVars(w, vars, "name")
+		if err != nil {
+			return
+		}
+
+		diff, err := git.DiffSnapshot(environment, name, r.URL.Query().Get("against"))
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot diff snapshot")
+			return
+		}
+
+		writeResponse(w, &snapshotDiffResponse{Projects: diff})
+	}
+}
+
+// CreateRestoreSnapshotHandler starts redeploying of given environment to the snapshot
+// The current user is recorded as the one who started the restore
+func CreateRestoreSnapshotHandler(git *gitlab.Service, userService *gitlab.UserService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+		name, err := getRequiredStringFromVars(w, vars, "name")
+		if err != nil {
+			return
+		}
+		user, err := userService.GetUserFromRequest(r)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot get user from gitlab")
+			return
+		}
+
+		restore, err := git.RestoreSnapshot(r.Context(), environment, name, user)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot restore snapshot")
+			return
+		}
+
+		writeResponseWithCode(w, &snapshotRestoreResponse{Restore: dto.NewSnapshotRestore(restore)}, http.StatusAccepted)
+	}
+}
+
+// CreateGetSnapshotRestoreHandler provides progress of the last restore of given environment
+func CreateGetSnapshotRestoreHandler(git *gitlab.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+
+		restore, err := git.GetSnapshotRestore(environment)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot get restore")
+			return
+		}
+
+		writeResponse(w, &snapshotRestoreResponse{Restore: dto.NewSnapshotRestore(restore)})
+	}
+}
+
+// CreateCancelSnapshotRestoreHandler stops the running restore of given environment
+// Pending projects are not started, already started jobs keep running
+func CreateCancelSnapshotRestoreHandler(git *gitlab.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		environment, err := getRequiredStringFromVars(w, vars, "environment")
+		if err != nil {
+			return
+		}
+
+		restore, err := git.CancelSnapshotRestore(environment)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot cancel restore")
+			return
+		}
+
+		writeResponse(w, &snapshotRestoreResponse{Restore: dto.NewSnapshotRestore(restore)})
+	}
+}
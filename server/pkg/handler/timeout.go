@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+type timeoutContextKey int
+
+const (
+	connKey timeoutContextKey = iota
+	withoutTimeoutKey
+)
+
+// CreateTimeoutMiddleware sets a deadline of the request context
+// GitLab requests made with the context are canceled after the deadline or when the client goes away
+// Unlike http.TimeoutHandler it doesn't buffer responses, so streaming handlers keep working
+func CreateTimeoutMiddleware(timeout time.Duration, handler http.Handler) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		ctx, cancel := context.WithTimeout(request.Context(), timeout)
+		defer cancel()
+		// Streaming handlers run until they are finished and use the context without the deadline
+		ctx = context.WithValue(ctx, withoutTimeoutKey, request.Context())
+
+		handler.ServeHTTP(writer, request.WithContext(ctx))
+	}
+}
+
+// withoutTimeout returns the request context without the deadline of CreateTimeoutMiddleware
+// It's still canceled when the client goes away
+func withoutTimeout(request *http.Request) context.Context {
+	if ctx, ok := request.Context().Value(withoutTimeoutKey).(context.Context); ok {
+		return ctx
+	}
+
+	return request.Context()
+}
+
+// SaveConnInContext is http.Server.ConnContext, it lets streaming handlers extend the server WriteTimeout
+func SaveConnInContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey, conn)
+}
+
+// extendWriteDeadline lets the handler write the response for `timeout` more
+// The server sets the write deadline once per request, so it's the only way to stream longer than WriteTimeout
+func extendWriteDeadline(request *http.Request, timeout time.Duration) error {
+	conn, ok := request.Context().Value(connKey).(net.Conn)
+	if !ok {
+		return nil
+	}
+
+	return conn.SetWriteDeadline(time.Now().Add(timeout))
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"gitlab-environment-dashboard/server/pkg/apitoken"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"gitlab-environment-dashboard/server/pkg/utils"
+	"net/http"
+)
+
+type createTokenRequestBody struct {
+	Name         string           `json:"name"`
+	Scopes       []apitoken.Scope `json:"scopes"`
+	Environments []string         `json:"environments"`
+}
+
+type tokenResponse struct {
+	Token *apitoken.Token `json:"token"`
+	// Secret is only given once when the token is created
+	Secret string `json:"secret,omitempty"`
+}
+
+type tokensResponse struct {
+	Tokens []*apitoken.Token `json:"tokens"`
+}
+
+// requiredScope is a scope of the token which is needed for the request
+// Reading needs `read`, everything else changes environments and needs `deploy`
+func requiredScope(request *http.Request) apitoken.Scope {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return apitoken.ScopeRead
+	}
+
+	return apitoken.ScopeDeploy
+}
+
+// checkTokenScope responds with 403 when the token doesn't allow the request
+// The environment is taken from the route, requests without it are to all environments
+func checkTokenScope(w http.ResponseWriter, request *http.Request, token *apitoken.Token, scope apitoken.Scope) bool {
+	if !token.HasScope(scope) {
+		forbiddenRequest(w, fmt.Sprintf("token doesn't have `%s` scope", scope))
+		return false
+	}
+	environment := mux.Vars(request)["environment"]
+	if !token.AllowsEnvironment(scope, environment) {
+		if environment == "" {
+			forbiddenRequest(w, "token is restricted to some environments")
+		} else {
+			forbiddenRequest(w, fmt.Sprintf("token isn't allowed for `%s` environment", environment))
+		}
+		return false
+	}
+
+	return true
+}
+
+// getTokenManager returns the logged in user who manages tokens
+// Tokens cannot be managed by tokens, so a leaked token cannot issue new ones
+func getTokenManager(w http.ResponseWriter, r *http.Request, userService *gitlab.UserService) (*gitlab.ProjectUser, bool) {
+	if r.Header.Get("Authorization") != "" {
+		forbiddenRequest(w, "tokens could be managed only by logged in users")
+		return nil, false
+	}
+	user, access, err := userService.GetUserWithAccess(r)
+	if err == gitlab.UserIsNotAllowed {
+		forbiddenRequest(w, accessDeniedMessage(access))
+		return nil, false
+	}
+	if err != nil {
+		serviceError(r.Context(), w, err, "cannot authorize user")
+		return nil, false
+	}
+	if user == nil {
+		unauthorizedRequest(w, "unauthorized")
+		return nil, false
+	}
+
+	return user, true
+}
+
+// CreateTokenHandler creates an API token of the current user
+// Only admins could create tokens with `admin` scope
+func CreateTokenHandler(tokens *apitoken.Store, userService *gitlab.UserService, admins []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := getTokenManager(w, r, userService)
+		if !ok {
+			return
+		}
+
+		requestBody := createTokenRequestBody{}
+		err := json.NewDecoder(r.Body).Decode(&requestBody)
+		if err != nil {
+			badRequest(w, fmt.Sprintf("cannot parse request body: %v", err))
+			return
+		}
+		for _, scope := range requestBody.Scopes {
+			if scope == apitoken.ScopeAdmin && !utils.StringsContainString(admins, user.Username) {
+				forbiddenRequest(w, "only admins could create tokens with `admin` scope")
+				return
+			}
+		}
+
+		owner := apitoken.Owner{Username: user.Username, Name: user.Name, AvatarURL: user.AvatarURL}
+		token, secret, err := tokens.Create(requestBody.Name, owner, requestBody.Scopes, requestBody.Environments)
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot create token")
+			return
+		}
+
+		writeResponseWithCode(w, &tokenResponse{Token: token, Secret: secret}, http.StatusCreated)
+	}
+}
+
+// CreateListTokensHandler provides tokens of the current user, admins get tokens of all users
+func CreateListTokensHandler(tokens *apitoken.Store, userService *gitlab.UserService, admins []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := getTokenManager(w, r, userService)
+		if !ok {
+			return
+		}
+
+		owner := user.Username
+		if utils.StringsContainString(admins, user.Username) {
+			owner = ""
+		}
+
+		writeResponse(w, &tokensResponse{Tokens: tokens.List(owner)})
+	}
+}
+
+// CreateRevokeTokenHandler revokes a token of the current user, admins could revoke any token
+func CreateRevokeTokenHandler(tokens *apitoken.Store, userService *gitlab.UserService, admins []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, ok := getTokenManager(w, r, userService)
+		if !ok {
+			return
+		}
+		vars := mux.Vars(r)
+		id, err := getRequiredStringFromVars(w, vars, "id")
+		if err != nil {
+			return
+		}
+
+		token, err := tokens.Get(id)
+		// Tokens of other users are not found for non-admins
+		if err == nil && token.Owner.Username != user.Username && !utils.StringsContainString(admins, user.Username) {
+			err = apitoken.TokenNotFound
+		}
+		if err == nil {
+			err = tokens.Revoke(id)
+		}
+		if err != nil {
+			serviceError(r.Context(), w, err, "cannot revoke token")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -1,37 +1,112 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"gitlab-environment-dashboard/server/pkg/apitoken"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"gitlab-environment-dashboard/server/pkg/session"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
+)
+
+// Codes of errors which aren't errors of the service
+const (
+	codeBadRequest         = "bad_request"
+	codeInvalidRequestBody = "invalid_request_body"
+	codeUnauthorized       = "unauthorized"
+	codeForbidden          = "forbidden"
+	codeInternal           = "internal"
 )
 
 type errorResponse struct {
 	Error string `json:"error"`
+	// Code is stable and machine-readable, i.e. `environment_locked`
+	Code    string                 `json:"code"`
+	Details map[string]interface{} `json:"details,omitempty"`
 }
 
-func writeErrorResponse(w http.ResponseWriter, message string, code int) {
-	w.WriteHeader(code)
+// errorKindStatusCodes are status codes of gitlab.ErrorKind
+var errorKindStatusCodes = map[gitlab.ErrorKind]int{
+	gitlab.KindForbidden:   http.StatusForbidden,
+	gitlab.KindNotFound:    http.StatusNotFound,
+	gitlab.KindConflict:    http.StatusConflict,
+	gitlab.KindInvalid:     http.StatusUnprocessableEntity,
+	gitlab.KindUpstream:    http.StatusBadGateway,
+	gitlab.KindUnavailable: http.StatusServiceUnavailable,
+}
+
+// packageErrors classifies errors of packages which don't have typed errors
+var packageErrors = map[error]*gitlab.Error{
+	apitoken.TokenNotFound:             {Kind: gitlab.KindNotFound, Code: "token_not_found"},
+	apitoken.InvalidScope:              {Kind: gitlab.KindInvalid, Code: gitlab.CodeInvalidArgument},
+	apitoken.NameIsEmpty:               {Kind: gitlab.KindInvalid, Code: gitlab.CodeInvalidArgument},
+	apitoken.InvalidEnvironmentPattern: {Kind: gitlab.KindInvalid, Code: gitlab.CodeInvalidArgument},
+	session.SessionNotFound:            {Kind: gitlab.KindNotFound, Code: "session_not_found"},
+}
+
+// writeErrorResponse logs with ctx, so failures are linked to the trace of the request
+func writeErrorResponse(ctx context.Context, w http.ResponseWriter, response *errorResponse, statusCode int) {
 	w.Header().Add("content-type", "application/json")
-	err := json.NewEncoder(w).Encode(&errorResponse{Error: message})
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(response)
 	if err != nil {
-		log.Println(err)
+		log.WithContext(ctx).Println(err)
 	}
 }
 
 func badRequest(w http.ResponseWriter, message string) {
-	writeErrorResponse(w, message, http.StatusBadRequest)
+	writeErrorResponse(context.Background(), w, &errorResponse{Error: message, Code: codeBadRequest}, http.StatusBadRequest)
 }
 
 func unauthorizedRequest(w http.ResponseWriter, message string) {
-	writeErrorResponse(w, message, http.StatusUnauthorized)
+	writeErrorResponse(context.Background(), w, &errorResponse{Error: message, Code: codeUnauthorized}, http.StatusUnauthorized)
+}
+
+func forbiddenRequest(w http.ResponseWriter, message string) {
+	writeErrorResponse(context.Background(), w, &errorResponse{Error: message, Code: codeForbidden}, http.StatusForbidden)
+}
+
+// serviceError responds with the status code and the code of the error
+// `action` tells what failed, i.e. "cannot create job". Unknown errors are internal errors
+// They are logged with the request context, so the log is linked to the trace of the request
+func serviceError(ctx context.Context, w http.ResponseWriter, err error, action string) {
+	response := &errorResponse{Error: fmt.Sprintf("%s: %v", action, err), Code: codeInternal}
+	statusCode := http.StatusInternalServerError
+
+	if classified := classifyError(err); classified != nil {
+		response.Code = classified.Code
+		response.Details = classified.Details
+		statusCode = errorKindStatusCodes[classified.Kind]
+	} else {
+		log.WithContext(ctx).Errorf("%s: %v", action, err)
+	}
+
+	writeErrorResponse(ctx, w, response, statusCode)
+}
+
+func classifyError(err error) *gitlab.Error {
+	for packageErr, classified := range packageErrors {
+		if errors.Is(err, packageErr) {
+			return classified
+		}
+	}
+
+	return gitlab.ClassifyError(err)
 }
 
 func writeResponse(w http.ResponseWriter, body interface{}) {
+	writeResponseWithCode(w, body, http.StatusOK)
+}
+
+func writeResponseWithCode(w http.ResponseWriter, body interface{}, code int) {
 	w.Header().Add("content-type", "application/json")
+	w.WriteHeader(code)
 	err := json.NewEncoder(w).Encode(body)
 	if err != nil {
 		log.Println(err)
@@ -66,3 +141,34 @@ func getRequiredIntFromVars(w http.ResponseWriter, vars map[string]string, param
 
 	return int(value), nil
 }
+
+func getOptionalIntFromQuery(w http.ResponseWriter, query url.Values, paramName string) (int, error) {
+	stringValue := query.Get(paramName)
+	if stringValue == "" {
+		return 0, nil
+	}
+
+	value, err := strconv.ParseInt(stringValue, 10, 64)
+	if err != nil {
+		badRequest(w, fmt.Sprintf("cannot parse `%s`", paramName))
+		return 0, CannotParseParam
+	}
+
+	return int(value), nil
+}
+
+// getOptionalTimeFromQuery parses RFC 3339 time, i.e. 2020-08-21T10:18:29Z
+func getOptionalTimeFromQuery(w http.ResponseWriter, query url.Values, paramName string) (*time.Time, error) {
+	stringValue := query.Get(paramName)
+	if stringValue == "" {
+		return nil, nil
+	}
+
+	value, err := time.Parse(time.RFC3339, stringValue)
+	if err != nil {
+		badRequest(w, fmt.Sprintf("cannot parse `%s`, RFC 3339 is expected", paramName))
+		return nil, CannotParseParam
+	}
+
+	return &value, nil
+}
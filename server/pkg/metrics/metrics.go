@@ -0,0 +1,198 @@
+/*
+Package metrics provides Prometheus metrics of the dashboard
+*/
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gitlab-environment-dashboard/server/pkg/breaker"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const namespace = "gitlab_dashboard"
+
+// Caches which are refreshed in background
+const (
+	CacheEnvironments = "environments"
+	CacheBranches     = "branches"
+)
+
+var (
+	deploysTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deploys_total",
+		Help:      "Deploys run from the dashboard by event (started, succeeded, failed, canceled).",
+	}, []string{"environment", "project_id", "event"})
+
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Duration of finished deploy jobs run from the dashboard.",
+		Buckets:   []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, []string{"environment", "project_id", "status"})
+
+	refreshDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "refresh_duration_seconds",
+		Help:      "Duration of cache refreshes.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"cache"})
+
+	refreshFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "refresh_failures_total",
+		Help:      "Failed cache refreshes.",
+	}, []string{"cache"})
+
+	gitLabRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "gitlab_request_duration_seconds",
+		Help:      "Duration of GitLab API requests by endpoint and status code (0 is a network error).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "endpoint", "code"})
+
+	deployedRef = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "deployed_ref_info",
+		Help:      "Currently deployed ref of a project in an environment, the value is always 1.",
+	}, []string{"environment", "project_id", "project", "ref", "sha"})
+
+	lastRefresh    = map[string]time.Time{}
+	lastRefreshMtx sync.RWMutex
+)
+
+func init() {
+	prometheus.MustRegister(&cacheAgeCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "cache_age_seconds"),
+			"Time since the last successful cache refresh.",
+			[]string{"cache"},
+			nil,
+		),
+	})
+}
+
+// cacheAgeCollector computes cache ages on scrape
+type cacheAgeCollector struct {
+	desc *prometheus.Desc
+}
+
+func (c *cacheAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *cacheAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	lastRefreshMtx.RLock()
+	defer lastRefreshMtx.RUnlock()
+
+	for cache, refreshedAt := range lastRefresh {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Since(refreshedAt).Seconds(), cache)
+	}
+}
+
+// ObserveJobEvent counts deploys and job durations, it's a gitlab.JobListener
+func ObserveJobEvent(event gitlab.JobEvent) {
+	if event.Job == nil {
+		return
+	}
+	projectID := strconv.Itoa(event.ProjectID)
+
+	deploysTotal.WithLabelValues(event.Environment, projectID, event.Event).Inc()
+	if event.Event != gitlab.JobEventStarted {
+		jobDuration.WithLabelValues(event.Environment, projectID, event.Job.Status).Observe(event.Job.Duration)
+	}
+}
+
+// ObserveRefresh records a cache refresh started at `start`
+func ObserveRefresh(cache string, start time.Time, err error) {
+	refreshDuration.WithLabelValues(cache).Observe(time.Since(start).Seconds())
+	if err != nil {
+		refreshFailures.WithLabelValues(cache).Inc()
+		return
+	}
+
+	lastRefreshMtx.Lock()
+	lastRefresh[cache] = time.Now()
+	lastRefreshMtx.Unlock()
+}
+
+// ObserveEnvironments exposes deployed refs of all environments
+func ObserveEnvironments(environments []*gitlab.Environment) {
+	deployedRef.Reset()
+	for _, environment := range environments {
+		for _, project := range environment.Projects {
+			if project == nil || project.LastDeployment == nil {
+				continue
+			}
+			deployedRef.WithLabelValues(
+				environment.Name,
+				strconv.Itoa(project.ID),
+				project.NameWithNamespace,
+				project.LastDeployment.Ref,
+				project.LastDeployment.SHA,
+			).Set(1)
+		}
+	}
+}
+
+// ObserveBreaker exposes the state of the GitLab circuit breaker
+// Every state is a series, the current one has value 1
+func ObserveBreaker(b *breaker.Breaker) {
+	for _, state := range []string{breaker.StateClosed, breaker.StateOpen, breaker.StateHalfOpen} {
+		state := state
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "gitlab_circuit_breaker_state",
+			Help:        "State of the GitLab circuit breaker, requests are not sent to GitLab while it's open.",
+			ConstLabels: prometheus.Labels{"state": state},
+		}, func() float64 {
+			if b.State() == state {
+				return 1
+			}
+			return 0
+		})
+	}
+}
+
+// idRegex matches IDs in GitLab API paths to keep the endpoint label bounded
+var idRegex = regexp.MustCompile(`/[0-9]+(/|$)`)
+
+// endpoint normalizes a GitLab API path, i.e. /api/v4/projects/28/jobs/42/play -> /projects/:id/jobs/:id/play
+func endpoint(path string) string {
+	path = strings.TrimPrefix(path, "/api/v4")
+	// Replace twice because neighbour IDs share a slash
+	path = idRegex.ReplaceAllString(path, "/:id$1")
+	return idRegex.ReplaceAllString(path, "/:id$1")
+}
+
+// instrumentedTransport measures GitLab API requests
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+// InstrumentTransport wraps the transport of a GitLab client with request metrics
+func InstrumentTransport(next http.RoundTripper) http.RoundTripper {
+	return &instrumentedTransport{next: next}
+}
+
+func (t *instrumentedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	start := time.Now()
+	response, err := t.next.RoundTrip(request)
+
+	code := "0"
+	if err == nil {
+		code = strconv.Itoa(response.StatusCode)
+	}
+	gitLabRequestDuration.
+		WithLabelValues(request.Method, endpoint(request.URL.Path), code).
+		Observe(time.Since(start).Seconds())
+
+	return response, err
+}
@@ -0,0 +1,23 @@
+package metrics
+
+import "testing"
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"environments", "/api/v4/projects/28/environments", "/projects/:id/environments"},
+		{"play", "/api/v4/projects/28/jobs/42/play", "/projects/:id/jobs/:id/play"},
+		{"neighbourIDs", "/api/v4/projects/28/42", "/projects/:id/:id"},
+		{"user", "/api/v4/user", "/user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := endpoint(tt.path); got != tt.want {
+				t.Errorf("endpoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+/*
+Package notification sends job events of the dashboard to outgoing webhooks
+*/
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"gitlab-environment-dashboard/server/pkg/utils"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Formats of webhook payloads
+const (
+	FormatJSON       = "json"
+	FormatSlack      = "slack"
+	FormatMattermost = "mattermost"
+)
+
+const defaultTemplate = "[{{.Environment}}] {{.Project}}: deploy of {{.Ref}} {{.Event}}" +
+	"{{if .User}} (by {{.User}}{{if .Token}} with {{.Token}} token{{end}}){{end}} {{.JobURL}}"
+
+// Config describes all outgoing webhooks
+type Config struct {
+	Webhooks []WebhookConfig `json:"webhooks"`
+}
+
+// WebhookConfig describes an outgoing webhook and which events are routed to it
+type WebhookConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Format is one of Format* constants, json by default
+	Format string `json:"format"`
+	// Environments are glob patterns (i.e. `qa*`), all environments if empty
+	Environments []string `json:"environments"`
+	// Events are gitlab.JobEvent* names, all events if empty
+	Events []string `json:"events"`
+	// Template is a text/template for the message, fields of Message are available
+	Template string `json:"template"`
+	// Channel and Username override defaults of Slack/Mattermost webhooks
+	Channel  string `json:"channel"`
+	Username string `json:"username"`
+}
+
+// Message is a rendered job event
+type Message struct {
+	Event       string `json:"event"`
+	Environment string `json:"environment"`
+	ProjectID   int    `json:"projectId"`
+	Project     string `json:"project"`
+	Ref         string `json:"ref"`
+	User        string `json:"user"`
+	Token       string `json:"token,omitempty"`
+	JobID       int    `json:"jobId"`
+	JobURL      string `json:"jobUrl"`
+	Status      string `json:"status"`
+	Text        string `json:"text"`
+}
+
+type chatPayload struct {
+	Text     string `json:"text"`
+	Channel  string `json:"channel,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+type webhook struct {
+	config   WebhookConfig
+	template *template.Template
+}
+
+// BackgroundRunner runs deliveries outside of the caller, i.e. gitlab.Service.RunInBackground
+// The context of a delivery is canceled on shutdown
+type BackgroundRunner func(task func(ctx context.Context))
+
+// Notifier posts job events to webhooks
+type Notifier struct {
+	webhooks        []*webhook
+	runInBackground BackgroundRunner
+	client          *http.Client
+	retries         int
+	retryDelay      time.Duration
+}
+
+// LoadConfig reads the webhooks configuration from a JSON file
+func LoadConfig(fileName string) (Config, error) {
+	config := Config{}
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(content, &config)
+
+	return config, err
+}
+
+// NewNotifier creates a Notifier, templates and formats are validated here
+// Deliveries are run by runInBackground, so they can be waited for on shutdown
+func NewNotifier(config Config, runInBackground BackgroundRunner) (*Notifier, error) {
+	notifier := &Notifier{
+		runInBackground: runInBackground,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		retries:         3,
+		retryDelay:      time.Second,
+	}
+	for _, webhookConfig := range config.Webhooks {
+		if webhookConfig.URL == "" {
+			return nil, fmt.Errorf("webhook %s: url is empty", webhookConfig.Name)
+		}
+		switch webhookConfig.Format {
+		case "":
+			webhookConfig.Format = FormatJSON
+		case FormatJSON, FormatSlack, FormatMattermost:
+		default:
+			return nil, fmt.Errorf("webhook %s: unknown format %s", webhookConfig.Name, webhookConfig.Format)
+		}
+		for _, pattern := range webhookConfig.Environments {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("webhook %s: wrong environment pattern %s: %w", webhookConfig.Name, pattern, err)
+			}
+		}
+
+		text := webhookConfig.Template
+		if text == "" {
+			text = defaultTemplate
+		}
+		messageTemplate, err := template.New(webhookConfig.Name).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: wrong template: %w", webhookConfig.Name, err)
+		}
+
+		notifier.webhooks = append(notifier.webhooks, &webhook{
+			config:   webhookConfig,
+			template: messageTemplate,
+		})
+	}
+
+	return notifier, nil
+}
+
+// Notify sends the event to all matched webhooks in background
+// It's a gitlab.JobListener
+func (n *Notifier) Notify(event gitlab.JobEvent) {
+	message := newMessage(event)
+	for _, hook := range n.webhooks {
+		if !hook.matches(message) {
+			continue
+		}
+
+		hookMessage := message
+		var text bytes.Buffer
+		err := hook.template.Execute(&text, &hookMessage)
+		if err != nil {
+			log.Errorf("cannot render notification for webhook %s: %v", hook.config.Name, err)
+			continue
+		}
+		hookMessage.Text = strings.TrimSpace(text.String())
+
+		n.runInBackground(func(ctx context.Context) {
+			n.deliver(ctx, hook, hookMessage)
+		})
+	}
+}
+
+func newMessage(event gitlab.JobEvent) Message {
+	message := Message{
+		Event:       event.Event,
+		Environment: event.Environment,
+		ProjectID:   event.ProjectID,
+	}
+	if event.Project != nil {
+		message.Project = event.Project.NameWithNamespace
+	}
+	if event.Job != nil {
+		message.Ref = event.Job.Ref
+		message.JobID = event.Job.ID
+		message.JobURL = event.Job.WebURL
+		message.Status = event.Job.Status
+		if event.Job.User != nil {
+			message.User = event.Job.User.Username
+		}
+	}
+	// The dashboard runs jobs by its own GitLab token, so the user of the dashboard is preferred
+	if event.User != nil {
+		message.User = event.User.Username
+		message.Token = event.User.Token
+	}
+
+	return message
+}
+
+// matches checks routing rules of the webhook
+func (w *webhook) matches(message Message) bool {
+	if len(w.config.Events) > 0 && !utils.StringsContainString(w.config.Events, message.Event) {
+		return false
+	}
+	if len(w.config.Environments) == 0 {
+		return true
+	}
+	for _, pattern := range w.config.Environments {
+		if matched, _ := path.Match(pattern, message.Environment); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// payload builds a request body in the webhook format
+func (w *webhook) payload(message Message) ([]byte, error) {
+	if w.config.Format == FormatJSON {
+		return json.Marshal(&message)
+	}
+
+	// Slack and Mattermost incoming webhooks have the same basic payload
+	return json.Marshal(&chatPayload{
+		Text:     message.Text,
+		Channel:  w.config.Channel,
+		Username: w.config.Username,
+	})
+}
+
+// deliver posts the message and retries with exponential backoff on failures
+// Retries are given up when the context is canceled
+func (n *Notifier) deliver(ctx context.Context, hook *webhook, message Message) {
+	body, err := hook.payload(message)
+	if err != nil {
+		log.Errorf("cannot build notification for webhook %s: %v", hook.config.Name, err)
+		return
+	}
+
+	delay := n.retryDelay
+	for attempt := 1; ; attempt++ {
+		retryable, err := n.post(ctx, hook.config.URL, body)
+		if err == nil {
+			return
+		}
+		if !retryable || attempt >= n.retries {
+			log.Errorf("cannot deliver notification to webhook %s: %v", hook.config.Name, err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Errorf("cannot deliver notification to webhook %s: %v", hook.config.Name, err)
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post sends the body and tells if the failure is worth retrying
+func (n *Notifier) post(ctx context.Context, url string, body []byte) (retryable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return false, fmt.Errorf("webhook rejected the notification with status %d", resp.StatusCode)
+}
@@ -0,0 +1,174 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	wrappedGitLab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestEvent(environment string) gitlab.JobEvent {
+	return gitlab.JobEvent{
+		Event:       gitlab.JobEventFailed,
+		Environment: environment,
+		ProjectID:   28,
+		Project:     &gitlab.Project{ID: 28, NameWithNamespace: "backend / api"},
+		Job: &wrappedGitLab.Job{
+			ID:     42,
+			Ref:    "feature/login",
+			Status: gitlab.JobStatusFailed,
+			WebURL: "https://gitlab.com/backend/api/-/jobs/42",
+			User:   &wrappedGitLab.User{Username: "admit133"},
+		},
+	}
+}
+
+// newTestRunner runs deliveries like gitlab.Service.RunInBackground, stop cancels and waits for them
+func newTestRunner() (runner BackgroundRunner, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	runner = func(task func(ctx context.Context)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task(ctx)
+		}()
+	}
+	return runner, func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func TestNotifierSlackPayloadWithRetry(t *testing.T) {
+	var attempts int32
+	payloads := make(chan chatPayload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first delivery fails and should be retried
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		payload := chatPayload{}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer server.Close()
+
+	runner, stop := newTestRunner()
+	defer stop()
+	notifier, err := NewNotifier(Config{Webhooks: []WebhookConfig{{
+		Name:    "slack",
+		URL:     server.URL,
+		Format:  FormatSlack,
+		Channel: "#deploys",
+	}}}, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier.retryDelay = time.Millisecond
+
+	notifier.Notify(newTestEvent("qa2"))
+
+	select {
+	case payload := <-payloads:
+		want := "[qa2] backend / api: deploy of feature/login failed (by admit133) https://gitlab.com/backend/api/-/jobs/42"
+		if payload.Text != want {
+			t.Errorf("text = %q, want %q", payload.Text, want)
+		}
+		if payload.Channel != "#deploys" {
+			t.Errorf("channel = %q, want %q", payload.Channel, "#deploys")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification was not delivered")
+	}
+}
+
+func TestNotifierStopCancelsRetries(t *testing.T) {
+	attempts := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts <- struct{}{}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	runner, stop := newTestRunner()
+	notifier, err := NewNotifier(Config{Webhooks: []WebhookConfig{{Name: "json", URL: server.URL}}}, runner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The delivery would wait for the retry much longer than the test
+	notifier.retryDelay = time.Hour
+
+	notifier.Notify(newTestEvent("qa2"))
+	select {
+	case <-attempts:
+	case <-time.After(time.Second):
+		t.Fatal("notification was not posted")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stop didn't wait for the canceled delivery")
+	}
+	if len(attempts) != 0 {
+		t.Errorf("got %d retries after stop, want 0", len(attempts))
+	}
+}
+
+func TestWebhookMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		config WebhookConfig
+		want   bool
+	}{
+		{"all", WebhookConfig{}, true},
+		{"environment", WebhookConfig{Environments: []string{"qa*"}}, true},
+		{"otherEnvironment", WebhookConfig{Environments: []string{"staging"}}, false},
+		{"event", WebhookConfig{Events: []string{gitlab.JobEventFailed}}, true},
+		{"otherEvent", WebhookConfig{Events: []string{gitlab.JobEventStarted}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &webhook{config: tt.config}
+			if got := hook.matches(newMessage(newTestEvent("qa2"))); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMessageUser(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      *gitlab.ProjectUser
+		wantUser  string
+		wantToken string
+	}{
+		{"gitLabUser", nil, "admit133", ""},
+		{"dashboardUser", &gitlab.ProjectUser{Username: "jdoe"}, "jdoe", ""},
+		{"apiToken", &gitlab.ProjectUser{Username: "jdoe", Token: "ci"}, "jdoe", "ci"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := newTestEvent("qa2")
+			event.User = tt.user
+			message := newMessage(event)
+			if message.User != tt.wantUser || message.Token != tt.wantToken {
+				t.Errorf("newMessage() user = %q, token = %q, want %q and %q", message.User, message.Token, tt.wantUser, tt.wantToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+/*
+Package oauth is a client of OAuth 2 providers, GitLab or any OpenID Connect provider
+
+It builds authorization URLs with state and PKCE, exchanges authorization codes
+and refreshes tokens. Secrets are sent in form-encoded bodies and never appear
+in URLs, logs or errors.
+*/
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope of GitLab tokens, the dashboard only needs to know the user
+const Scope = "read_user"
+
+// OIDCScope is a default scope of OpenID Connect tokens
+const OIDCScope = "openid profile email"
+
+// refreshResultTTL is how long a refresh result is shared with requests of the same refresh token
+// GitLab revokes a refresh token after use, so parallel requests of the browser can't refresh it again
+const refreshResultTTL = time.Minute
+
+// Token is a token response of the provider
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// Error is an error response of the provider
+// It only keeps the status and the OAuth error, the body of the response isn't kept
+type Error struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("provider responded with %d", e.StatusCode)
+	}
+	if e.Description == "" {
+		return fmt.Sprintf("provider responded with %d: %s", e.StatusCode, e.Code)
+	}
+
+	return fmt.Sprintf("provider responded with %d: %s (%s)", e.StatusCode, e.Code, e.Description)
+}
+
+// Endpoints are URLs of the provider
+type Endpoints struct {
+	Authorization string `json:"authorization_endpoint"`
+	Token         string `json:"token_endpoint"`
+	// UserInfo is only known for OpenID Connect providers
+	UserInfo string `json:"userinfo_endpoint"`
+}
+
+// Client requests tokens of an application of the provider
+type Client struct {
+	endpoints  Endpoints
+	scope      string
+	appID      string
+	appSecret  string
+	httpClient *http.Client
+
+	refreshes    map[string]*refresh
+	refreshesMtx sync.Mutex
+}
+
+// refresh is a running or finished refresh of a token
+type refresh struct {
+	done      chan struct{}
+	token     *Token
+	err       error
+	startedAt time.Time
+}
+
+// NewClient creates a client of the GitLab application
+func NewClient(baseURL string, appID string, appSecret string, httpClient *http.Client) *Client {
+	baseURL = strings.TrimRight(baseURL, "/")
+	endpoints := Endpoints{
+		Authorization: baseURL + "/oauth/authorize",
+		Token:         baseURL + "/oauth/token",
+	}
+
+	return NewClientWithEndpoints(endpoints, Scope, appID, appSecret, httpClient)
+}
+
+// NewClientWithEndpoints creates a client of any provider, i.e. endpoints of Discover
+func NewClientWithEndpoints(endpoints Endpoints, scope string, appID string, appSecret string, httpClient *http.Client) *Client {
+	return &Client{
+		endpoints:  endpoints,
+		scope:      scope,
+		appID:      appID,
+		appSecret:  appSecret,
+		httpClient: httpClient,
+		refreshes:  map[string]*refresh{},
+	}
+}
+
+// Discover gets endpoints of the OpenID Connect provider by its discovery document
+func Discover(ctx context.Context, issuer string, httpClient *http.Client) (*Endpoints, error) {
+	discoveryURL := strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %d", discoveryURL, resp.StatusCode)
+	}
+
+	endpoints := &Endpoints{}
+	if err := json.NewDecoder(resp.Body).Decode(endpoints); err != nil {
+		return nil, fmt.Errorf("cannot decode discovery document: %v", err)
+	}
+	if endpoints.Authorization == "" || endpoints.Token == "" || endpoints.UserInfo == "" {
+		return nil, fmt.Errorf("discovery document doesn't have authorization, token or userinfo endpoint")
+	}
+
+	return endpoints, nil
+}
+
+// Endpoints returns URLs of the provider
+func (c *Client) Endpoints() Endpoints {
+	return c.endpoints
+}
+
+// AuthorizeURL returns URL of the provider page where the user authorizes the dashboard
+// `challenge` is a PKCE challenge of the verifier which is passed to Exchange
+func (c *Client) AuthorizeURL(redirectURI string, state string, challenge string) string {
+	values := url.Values{}
+	values.Set("client_id", c.appID)
+	values.Set("redirect_uri", redirectURI)
+	values.Set("response_type", "code")
+	values.Set("scope", c.scope)
+	values.Set("state", state)
+	values.Set("code_challenge", challenge)
+	values.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(c.endpoints.Authorization, "?") {
+		separator = "&"
+	}
+
+	return c.endpoints.Authorization + separator + values.Encode()
+}
+
+// Exchange exchanges the authorization code to a token
+func (c *Client) Exchange(ctx context.Context, code string, verifier string, redirectURI string) (*Token, error) {
+	values := url.Values{}
+	values.Set("grant_type", "authorization_code")
+	values.Set("code", code)
+	values.Set("code_verifier", verifier)
+	values.Set("redirect_uri", redirectURI)
+
+	return c.requestToken(ctx, values)
+}
+
+// Refresh gets a new token by the refresh token
+// Concurrent refreshes of the same token are done once and share the result
+func (c *Client) Refresh(ctx context.Context, refreshToken string, redirectURI string) (*Token, error) {
+	c.refreshesMtx.Lock()
+	for key, r := range c.refreshes {
+		if time.Since(r.startedAt) > refreshResultTTL {
+			delete(c.refreshes, key)
+		}
+	}
+	r, ok := c.refreshes[refreshToken]
+	if !ok {
+		r = &refresh{done: make(chan struct{}), startedAt: time.Now()}
+		c.refreshes[refreshToken] = r
+	}
+	c.refreshesMtx.Unlock()
+
+	if !ok {
+		values := url.Values{}
+		values.Set("grant_type", "refresh_token")
+		values.Set("refresh_token", refreshToken)
+		values.Set("redirect_uri", redirectURI)
+		// The refresh shouldn't fail because the first request went away
+		r.token, r.err = c.requestToken(context.Background(), values)
+		close(r.done)
+	}
+
+	select {
+	case <-r.done:
+		return r.token, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) requestToken(ctx context.Context, values url.Values) (*Token, error) {
+	values.Set("client_id", c.appID)
+	values.Set("client_secret", c.appSecret)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoints.Token, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		oauthErr := &Error{StatusCode: resp.StatusCode}
+		// The body is parsed only to get the OAuth error, it's not a problem if it isn't JSON
+		_ = json.Unmarshal(body, oauthErr)
+		return nil, oauthErr
+	}
+
+	token := &Token{}
+	if err := json.Unmarshal(body, token); err != nil {
+		return nil, fmt.Errorf("cannot decode token: %v", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("provider responded without an access token")
+	}
+
+	return token, nil
+}
+
+// NewState returns a random state which binds the callback to the browser started the login
+func NewState() (string, error) {
+	return randomString(16)
+}
+
+// NewVerifier returns a random PKCE code verifier
+func NewVerifier() (string, error) {
+	// 32 bytes are 43 characters, the minimal length of a verifier
+	return randomString(32)
+}
+
+// Challenge returns S256 PKCE challenge of the verifier
+func Challenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,188 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestChallenge(t *testing.T) {
+	// The example of RFC 7636
+	got := Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("Challenge() = %s, want %s", got, want)
+	}
+}
+
+func TestAuthorizeURL(t *testing.T) {
+	client := NewClient("https://gitlab.example.com/", "app", "secret", http.DefaultClient)
+	got, err := url.Parse(client.AuthorizeURL("https://dashboard/oauth/code", "state", "challenge"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Host != "gitlab.example.com" || got.Path != "/oauth/authorize" {
+		t.Errorf("AuthorizeURL() = %s", got)
+	}
+	query := got.Query()
+	want := map[string]string{
+		"client_id":             "app",
+		"redirect_uri":          "https://dashboard/oauth/code",
+		"response_type":         "code",
+		"scope":                 Scope,
+		"state":                 "state",
+		"code_challenge":        "challenge",
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if query.Get(name) != value {
+			t.Errorf("AuthorizeURL() %s = %s, want %s", name, query.Get(name), value)
+		}
+	}
+	if strings.Contains(got.String(), "secret") {
+		t.Errorf("AuthorizeURL() = %s contains the secret", got)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery != "" {
+			t.Errorf("Exchange() sent query %s", r.URL.RawQuery)
+		}
+		if r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			t.Errorf("Exchange() sent %s", r.Header.Get("Content-Type"))
+		}
+		want := map[string]string{
+			"client_id":     "app",
+			"client_secret": "secret",
+			"grant_type":    "authorization_code",
+			"code":          "code",
+			"code_verifier": "verifier",
+			"redirect_uri":  "https://dashboard/oauth/code",
+		}
+		for name, value := range want {
+			if r.PostFormValue(name) != value {
+				t.Errorf("Exchange() sent %s = %s, want %s", name, r.PostFormValue(name), value)
+			}
+		}
+		w.Write([]byte(`{"access_token":"access","refresh_token":"refresh","expires_in":7200}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "app", "secret", server.Client())
+	token, err := client.Exchange(context.Background(), "code", "verifier", "https://dashboard/oauth/code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access" || token.RefreshToken != "refresh" || token.ExpiresIn != 7200 {
+		t.Errorf("Exchange() = %+v", token)
+	}
+}
+
+func TestExchangeError(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    int
+		body    string
+		wantErr string
+	}{
+		{"oauthError", 400, `{"error":"invalid_grant","error_description":"The code is invalid","secret":"secret"}`, "provider responded with 400: invalid_grant (The code is invalid)"},
+		{"notJSON", 502, "<html>secret</html>", "provider responded with 502"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.code)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := NewClient(server.URL, "app", "secret", server.Client())
+			_, err := client.Exchange(context.Background(), "code", "verifier", "https://dashboard/oauth/code")
+			var oauthErr *Error
+			if !errors.As(err, &oauthErr) || oauthErr.StatusCode != tt.code {
+				t.Fatalf("Exchange() error = %v, want Error with %d", err, tt.code)
+			}
+			if err.Error() != tt.wantErr {
+				t.Errorf("Exchange() error = %s, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRefreshIsDoneOnce(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.PostFormValue("grant_type") != "refresh_token" || r.PostFormValue("refresh_token") != "refresh" {
+			t.Errorf("Refresh() sent %v", r.PostForm)
+		}
+		<-release
+		w.Write([]byte(`{"access_token":"access2","refresh_token":"refresh2","expires_in":7200}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "app", "secret", server.Client())
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := client.Refresh(context.Background(), "refresh", "https://dashboard/oauth/code")
+			if err != nil || token.AccessToken != "access2" {
+				t.Errorf("Refresh() = %v, %v", token, err)
+			}
+		}()
+	}
+	close(release)
+	wg.Wait()
+
+	if requests != 1 {
+		t.Errorf("Refresh() sent %d requests, want 1", requests)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		document string
+		wantErr  bool
+	}{
+		{"ok", 200, `{"authorization_endpoint":"https://sso/auth","token_endpoint":"https://sso/token","userinfo_endpoint":"https://sso/userinfo"}`, false},
+		{"withoutUserInfo", 200, `{"authorization_endpoint":"https://sso/auth","token_endpoint":"https://sso/token"}`, true},
+		{"notFound", 404, `{}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/realms/dev/.well-known/openid-configuration" {
+					t.Errorf("Discover() requested %s", r.URL.Path)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.document))
+			}))
+			defer server.Close()
+
+			endpoints, err := Discover(context.Background(), server.URL+"/realms/dev/", server.Client())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Discover() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			client := NewClientWithEndpoints(*endpoints, OIDCScope, "app", "secret", server.Client())
+			got, _ := url.Parse(client.AuthorizeURL("https://dashboard/oauth/code", "state", "challenge"))
+			if got.Path != "/auth" || got.Query().Get("scope") != OIDCScope {
+				t.Errorf("AuthorizeURL() = %s, want the discovered endpoint with %s scope", got, OIDCScope)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+/*
+Package openapi serves and enforces the OpenAPI specification of the dashboard API v1
+
+The specification is maintained by hand in openapi.json and embedded into the binary.
+Only a subset of JSON schema which the specification uses is supported: type, format,
+required, properties, additionalProperties (a schema), items, enum, nullable, minLength, allOf and $ref.
+Nullable references are written as `{"nullable": true, "allOf": [{"$ref": ...}]}`.
+*/
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//go:embed openapi.json
+var specification []byte
+
+// Prefix is a path prefix of the API v1, paths of the specification are relative to it
+const Prefix = "/api/v1"
+
+const refPrefix = "#/components/schemas/"
+
+// routeVarPattern matches regexps of gorilla/mux path templates, i.e. `{projectID:[0-9]+}`
+var routeVarPattern = regexp.MustCompile(`\{([^}:]+):[^}]+\}`)
+
+// Document is the parsed specification
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Schemas map[string]*Schema `json:"schemas"`
+	} `json:"components"`
+
+	raw []byte
+}
+
+// Operation is a method of a path
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// RequestBody describes JSON bodies of requests
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response describes a response of a status code
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+// MediaType has a schema of the content
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON schema
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+}
+
+// Load parses the embedded specification
+// It fails when a schema refers to a missing schema, so a broken specification isn't served
+func Load() (*Document, error) {
+	document := &Document{raw: specification}
+	err := json.Unmarshal(specification, document)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse openapi specification: %v", err)
+	}
+
+	for name, schema := range document.Components.Schemas {
+		if err := document.checkRefs(schema); err != nil {
+			return nil, fmt.Errorf("schema %s: %v", name, err)
+		}
+	}
+	for path, operations := range document.Paths {
+		for method, operation := range operations {
+			for _, schema := range operation.schemas() {
+				if err := document.checkRefs(schema); err != nil {
+					return nil, fmt.Errorf("%s %s: %v", strings.ToUpper(method), path, err)
+				}
+			}
+		}
+	}
+
+	return document, nil
+}
+
+// JSON returns the specification as it's maintained
+func (d *Document) JSON() []byte {
+	return d.raw
+}
+
+// Operation finds the operation of a gorilla/mux path template
+// The API prefix and regexps of variables are ignored, so legacy routes have operations of v1
+func (d *Document) Operation(method string, pathTemplate string) (*Operation, bool) {
+	path := strings.TrimPrefix(pathTemplate, Prefix)
+	path = routeVarPattern.ReplaceAllString(path, "{$1}")
+	operation, ok := d.Paths[path][strings.ToLower(method)]
+
+	return operation, ok
+}
+
+// Schema returns a schema of components
+func (d *Document) Schema(name string) (*Schema, bool) {
+	schema, ok := d.Components.Schemas[name]
+
+	return schema, ok
+}
+
+// RequestSchema returns the schema of JSON request bodies, nil if the operation doesn't have a body
+func (o *Operation) RequestSchema() *Schema {
+	if o.RequestBody == nil || o.RequestBody.Content["application/json"] == nil {
+		return nil
+	}
+
+	return o.RequestBody.Content["application/json"].Schema
+}
+
+// ResponseSchema returns the schema of JSON responses of the code
+// Responses without content have no schema
+func (o *Operation) ResponseSchema(code int) (schema *Schema, documented bool) {
+	response, ok := o.Responses[fmt.Sprint(code)]
+	if !ok {
+		response, ok = o.Responses["default"]
+	}
+	if !ok {
+		return nil, false
+	}
+	if response.Content["application/json"] == nil {
+		return nil, true
+	}
+
+	return response.Content["application/json"].Schema, true
+}
+
+func (o *Operation) schemas() []*Schema {
+	schemas := []*Schema{o.RequestSchema()}
+	for _, response := range o.Responses {
+		if response.Content["application/json"] != nil {
+			schemas = append(schemas, response.Content["application/json"].Schema)
+		}
+	}
+
+	return schemas
+}
+
+func (d *Document) checkRefs(schema *Schema) error {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		if _, err := d.resolve(schema); err != nil {
+			return err
+		}
+	}
+	for _, property := range schema.Properties {
+		if err := d.checkRefs(property); err != nil {
+			return err
+		}
+	}
+	for _, subschema := range schema.AllOf {
+		if err := d.checkRefs(subschema); err != nil {
+			return err
+		}
+	}
+	if err := d.checkRefs(schema.Items); err != nil {
+		return err
+	}
+
+	return d.checkRefs(schema.AdditionalProperties)
+}
+
+func (d *Document) resolve(schema *Schema) (*Schema, error) {
+	if schema.Ref == "" {
+		return schema, nil
+	}
+	if !strings.HasPrefix(schema.Ref, refPrefix) {
+		return nil, fmt.Errorf("unsupported reference %s", schema.Ref)
+	}
+	resolved, ok := d.Components.Schemas[strings.TrimPrefix(schema.Ref, refPrefix)]
+	if !ok {
+		return nil, fmt.Errorf("schema of %s not found", schema.Ref)
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,202 @@
+package openapi
+
+import (
+	"encoding/json"
+	wrappedGitlab "github.com/xanzy/go-gitlab"
+	"gitlab-environment-dashboard/server/pkg/dto"
+	"gitlab-environment-dashboard/server/pkg/gitlab"
+	"testing"
+	"time"
+)
+
+func TestOperation(t *testing.T) {
+	document, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		pathTemplate string
+		wantID       string
+	}{
+		{"v1", "POST", "/api/v1/environments/{environment}/projects/{projectID:[0-9]+}/jobs", "playJob"},
+		{"legacy", "GET", "/environments/{environment}/projects/{projectID:[0-9]+}/jobs", "getJob"},
+		{"withoutVariables", "GET", "/api/v1/environments", "listEnvironments"},
+		{"unknownMethod", "PUT", "/api/v1/environments", ""},
+		{"unknownPath", "GET", "/api/v1/unknown", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operation, ok := document.Operation(tt.method, tt.pathTemplate)
+			if tt.wantID == "" {
+				if ok {
+					t.Errorf("Operation() = %s, want none", operation.OperationID)
+				}
+				return
+			}
+			if !ok || operation.OperationID != tt.wantID {
+				t.Errorf("Operation() = %+v, want %s", operation, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestValidateRequests(t *testing.T) {
+	document, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		schema   string
+		body     string
+		wantPath string
+		wantErr  bool
+	}{
+		{"ok", "PlayJobsRequest", `{"query":"feature/","fallbackRefs":{"42":"master"}}`, "", false},
+		{"short", "PlayJobsRequest", `{"query":"fe"}`, "query", true},
+		{"unknownMatch", "PlayJobsRequest", `{"query":"feature/","match":"fuzzy"}`, "match", true},
+		{"wrongFallbackRef", "PlayJobsRequest", `{"query":"feature/","fallbackRefs":{"42":1}}`, "fallbackRefs.42", true},
+		{"withoutQuery", "PlayJobsRequest", `{"dryRun":true}`, "query", true},
+		{"unknownProperties", "PlayJobRequest", `{"ref":"master","sha":"a1"}`, "", false},
+		{"emptyRef", "PlayJobRequest", `{"ref":""}`, "ref", true},
+		{"wrongType", "PlayJobRequest", `{"ref":42}`, "ref", true},
+		{"notObject", "PlayJobRequest", `["master"]`, "", true},
+		{"invalidJSON", "PlayJobRequest", `{"ref":`, "", true},
+		{"unknownScope", "CreateTokenRequest", `{"name":"ci","scopes":["read","root"]}`, "scopes[1]", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, ok := document.Schema(tt.schema)
+			if !ok {
+				t.Fatalf("schema %s not found", tt.schema)
+			}
+			err := document.ValidateJSON(schema, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && err.(*ValidationError).Path != tt.wantPath {
+				t.Errorf("ValidateJSON() error path = %s, want %s", err.(*ValidationError).Path, tt.wantPath)
+			}
+		})
+	}
+}
+
+// TestResponsesMatchSpecification catches DTOs which were changed without their schemas
+func TestResponsesMatchSpecification(t *testing.T) {
+	document, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	finishedAt := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	commit := &wrappedGitlab.Commit{ID: "a1b2c3", ShortID: "a1b2", Title: "Fix login", CreatedAt: &finishedAt}
+	job := &wrappedGitlab.Job{ID: 7, Name: "deploy", Status: "success", Ref: "master", FinishedAt: &finishedAt, Commit: commit}
+	job.Pipeline.ID = 3
+	job.Pipeline.Status = "success"
+	jobWithUser := *job
+	jobWithUser.User = &wrappedGitlab.User{Username: "jdoe", Name: "John Doe"}
+	behindBy := 2
+	deployment := &gitlab.Deployment{
+		ID:         5,
+		Ref:        "master",
+		SHA:        "a1b2c3",
+		User:       &gitlab.ProjectUser{Username: "jdoe", Name: "John Doe"},
+		UpdatedAt:  &finishedAt,
+		Deployable: &gitlab.Deployable{Name: "deploy", Pipeline: gitlab.Pipeline{ID: 3, Status: "success", User: &gitlab.User{}}},
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		code     int
+		response interface{}
+	}{
+		{"environments", "GET", "/environments", 200, map[string]interface{}{
+			"environments": dto.NewEnvironments([]*gitlab.Environment{{Name: "dev", Projects: []*gitlab.Project{
+				{ID: 1, Name: "api", LastDeployment: deployment, BehindBy: &behindBy},
+				{ID: 2, Name: "web"},
+			}}}),
+		}},
+		{"job", "GET", "/environments/{environment}/projects/{projectID}/jobs", 200, map[string]interface{}{
+			"job":   dto.NewJob(&jobWithUser),
+			"start": &gitlab.JobStart{JobID: 7, User: &gitlab.ProjectUser{Username: "jdoe", Token: "ci"}, StartedAt: finishedAt},
+		}},
+		{"withoutJob", "GET", "/environments/{environment}/projects/{projectID}/jobs", 200, map[string]interface{}{
+			"job": dto.NewJob(nil),
+		}},
+		{"jobs", "GET", "/jobs", 200, map[string]interface{}{
+			"jobs": dto.NewJobs(map[string]map[int]*wrappedGitlab.Job{"dev": {1: job}}),
+		}},
+		{"branches", "GET", "/environments/{environment}/projects/{projectID}/repository/branches", 200, map[string]interface{}{
+			"branches": dto.NewBranches([]*wrappedGitlab.Branch{{Name: "master", Default: true, Commit: commit}}),
+		}},
+		{"deployments", "GET", "/environments/{environment}/projects/{projectID}/deployments", 200, map[string]interface{}{
+			"deployments": dto.NewDeployments([]*gitlab.Deployment{deployment}),
+			"nextPage":    0,
+		}},
+		{"queryDeploy", "POST", "/environments/{environment}/jobs", 207, map[string]interface{}{
+			"error": "some jobs cannot be started",
+			"code":  "partially_deployed",
+			"projects": dto.NewQueryDeployResults(gitlab.QueryDeployResults{
+				{ProjectID: 1, Branch: "feature/x", Outcome: gitlab.QueryDeployPlayed, Job: job},
+				{ProjectID: 2, Outcome: gitlab.QueryDeployError, Error: "forbidden"},
+			}),
+		}},
+		{"restore", "POST", "/environments/{environment}/snapshots/{name}/restore", 202, map[string]interface{}{
+			"restore": dto.NewSnapshotRestore(&gitlab.SnapshotRestore{
+				Snapshot:    "release",
+				Environment: "dev",
+				StartedAt:   finishedAt,
+				Projects: []*gitlab.SnapshotRestoreProject{
+					{SnapshotProject: gitlab.SnapshotProject{ProjectID: 1, Ref: "master", SHA: "a1"}, Status: gitlab.RestoreStarted, Job: job},
+				},
+			}),
+		}},
+		{"canceledRestore", "DELETE", "/environments/{environment}/restore", 200, map[string]interface{}{
+			"restore": dto.NewSnapshotRestore(&gitlab.SnapshotRestore{
+				Snapshot:    "release",
+				Environment: "dev",
+				StartedAt:   finishedAt,
+				Finished:    true,
+				Error:       "restore was canceled",
+				Projects: []*gitlab.SnapshotRestoreProject{
+					{SnapshotProject: gitlab.SnapshotProject{ProjectID: 1, Ref: "master", SHA: "a1"}, Status: gitlab.RestoreCanceled},
+				},
+			}),
+		}},
+		{"nothingDeployed", "POST", "/environments/{environment}/jobs", 422, map[string]interface{}{
+			"error":    "nothing was run",
+			"code":     "nothing_deployed",
+			"projects": dto.NewQueryDeployResults(gitlab.QueryDeployResults{{ProjectID: 1, Outcome: gitlab.QueryDeploySkippedNoBranch}}),
+		}},
+		{"error", "GET", "/environments", 503, map[string]interface{}{
+			"error":   "cannot get environments: gitlab is unavailable",
+			"code":    "gitlab_unavailable",
+			"details": map[string]interface{}{"gitlabStatus": 503},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			operation, ok := document.Operation(tt.method, tt.path)
+			if !ok {
+				t.Fatalf("operation of %s %s not found", tt.method, tt.path)
+			}
+			schema, documented := operation.ResponseSchema(tt.code)
+			if !documented || schema == nil {
+				t.Fatalf("response %d isn't documented", tt.code)
+			}
+			data, err := json.Marshal(tt.response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := document.ValidateJSON(schema, data); err != nil {
+				t.Errorf("ValidateJSON() error = %v of %s", err, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// ValidationError tells where a value doesn't match the schema
+type ValidationError struct {
+	// Path is a path of the value, i.e. `projects[0].job.id`, it's empty for the root
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidateJSON decodes the JSON document and validates it against the schema
+func (d *Document) ValidateJSON(schema *Schema, data []byte) error {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return &ValidationError{Message: fmt.Sprintf("invalid json: %v", err)}
+	}
+
+	return d.Validate(schema, value)
+}
+
+// Validate validates a decoded JSON value against the schema
+// Numbers are expected to be decoded as json.Number or float64
+func (d *Document) Validate(schema *Schema, value interface{}) error {
+	return d.validate(schema, value, "")
+}
+
+func (d *Document) validate(schema *Schema, value interface{}, path string) error {
+	schema, err := d.resolve(schema)
+	if err != nil {
+		return &ValidationError{Path: path, Message: err.Error()}
+	}
+	if value == nil {
+		if schema.Nullable || schema.Type == "" {
+			return nil
+		}
+		return &ValidationError{Path: path, Message: fmt.Sprintf("expected %s, got null", schema.Type)}
+	}
+
+	for _, subschema := range schema.AllOf {
+		if err := d.validate(subschema, value, path); err != nil {
+			return err
+		}
+	}
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("%v isn't one of %v", value, schema.Enum)}
+	}
+
+	switch schema.Type {
+	case "":
+		return nil
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return typeError(path, schema.Type, value)
+		}
+		return d.validateObject(schema, object, path)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return typeError(path, schema.Type, value)
+		}
+		for i, item := range items {
+			if err := d.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return typeError(path, schema.Type, value)
+		}
+		if schema.MinLength != nil && len([]rune(text)) < *schema.MinLength {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("should have at least %d characters", *schema.MinLength)}
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, text); err != nil {
+				return &ValidationError{Path: path, Message: fmt.Sprintf("expected date-time, got %q", text)}
+			}
+		}
+	case "integer", "number":
+		number, ok := toFloat(value)
+		if !ok {
+			return typeError(path, schema.Type, value)
+		}
+		if schema.Type == "integer" && number != math.Trunc(number) {
+			return typeError(path, schema.Type, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return typeError(path, schema.Type, value)
+		}
+	default:
+		return &ValidationError{Path: path, Message: fmt.Sprintf("unsupported type %s", schema.Type)}
+	}
+
+	return nil
+}
+
+func (d *Document) validateObject(schema *Schema, object map[string]interface{}, path string) error {
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			return &ValidationError{Path: join(path, name), Message: "is required"}
+		}
+	}
+
+	// Keys are sorted to report the same error every time
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, ok := schema.Properties[name]
+		if !ok {
+			property = schema.AdditionalProperties
+		}
+		// Unknown properties are allowed, so clients could send more than the server knows
+		if property == nil {
+			continue
+		}
+		if err := d.validate(property, object[name], join(path, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func typeError(path string, expected string, value interface{}) error {
+	return &ValidationError{Path: path, Message: fmt.Sprintf("expected %s, got %s", expected, typeName(value))}
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case json.Number:
+		f, err := number.Float64()
+		return f, err == nil
+	case float64:
+		return number, true
+	}
+	return 0, false
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
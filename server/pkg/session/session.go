@@ -0,0 +1,363 @@
+/*
+Package session keeps sessions of logged in users on the server
+
+The cookie only has an opaque session ID signed by HMAC, GitLab tokens never leave the server.
+A session expires `ttl` after the login or `idleTimeout` after the last request.
+The user of a session is revalidated against GitLab every `revalidateInterval`,
+so revoked tokens stop working without waiting for the expiry.
+*/
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"gitlab-environment-dashboard/server/pkg/oauth"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CookieName is a name of the cookie with the signed session ID
+const CookieName = "session"
+
+// The access token is refreshed a bit earlier than it expires
+const tokenExpiryMargin = time.Minute
+
+var (
+	SessionNotFound = errors.New("session not found")
+	// Unauthorized is returned by a ValidateFunc when GitLab rejects the token
+	Unauthorized = errors.New("token is rejected")
+)
+
+// User is a user of a session
+type User struct {
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatarURL"`
+	// Access is checked with the user, so it's cached with the session too
+	Access Access `json:"access"`
+}
+
+// Access tells if the user is allowed to use the dashboard
+type Access struct {
+	Allowed bool `json:"allowed"`
+	// Reasons explain the decision, i.e. groups of the user
+	Reasons []string `json:"reasons"`
+}
+
+// Session is a login of a user
+type Session struct {
+	ID   string `json:"id"`
+	User User   `json:"user"`
+
+	CreatedAt   time.Time `json:"createdAt"`
+	LastSeenAt  time.Time `json:"lastSeenAt"`
+	ValidatedAt time.Time `json:"validatedAt"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+
+	accessToken    string
+	refreshToken   string
+	tokenExpiresAt time.Time
+	// redirectURI is the callback of the login, GitLab expects it on refreshes
+	redirectURI string
+}
+
+// ValidateFunc returns the user of the access token
+type ValidateFunc func(ctx context.Context, accessToken string) (*User, error)
+
+// RefreshFunc gets a new token by the refresh token
+type RefreshFunc func(ctx context.Context, refreshToken string, redirectURI string) (*oauth.Token, error)
+
+// Manager creates, validates and expires sessions
+// Sessions are kept in memory, they are lost on restarts
+type Manager struct {
+	secret             []byte
+	ttl                time.Duration
+	idleTimeout        time.Duration
+	revalidateInterval time.Duration
+	validate           ValidateFunc
+	refresh            RefreshFunc
+
+	mtx      sync.RWMutex
+	sessions map[string]*Session
+
+	now func() time.Time
+}
+
+// NewManager creates a manager without sessions
+// Session IDs are signed by the secret, a random one is used when it's empty
+func NewManager(
+	secret string,
+	ttl time.Duration,
+	idleTimeout time.Duration,
+	revalidateInterval time.Duration,
+	validate ValidateFunc,
+	refresh RefreshFunc,
+) (*Manager, error) {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Manager{
+		secret:             key,
+		ttl:                ttl,
+		idleTimeout:        idleTimeout,
+		revalidateInterval: revalidateInterval,
+		validate:           validate,
+		refresh:            refresh,
+		sessions:           map[string]*Session{},
+		now:                time.Now,
+	}, nil
+}
+
+// Create validates the token and creates a session of its user
+// It returns the session and the signed ID for the cookie
+func (m *Manager) Create(ctx context.Context, token *oauth.Token, redirectURI string) (*Session, string, error) {
+	user, err := m.validate(ctx, token.AccessToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	idBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, "", err
+	}
+
+	now := m.now()
+	session := &Session{
+		ID:           base64.RawURLEncoding.EncodeToString(idBytes),
+		User:         *user,
+		CreatedAt:    now,
+		LastSeenAt:   now,
+		ValidatedAt:  now,
+		ExpiresAt:    now.Add(m.ttl),
+		accessToken:  token.AccessToken,
+		refreshToken: token.RefreshToken,
+		redirectURI:  redirectURI,
+	}
+	if token.ExpiresIn > 0 {
+		session.tokenExpiresAt = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+
+	m.mtx.Lock()
+	m.sessions[session.ID] = session
+	m.mtx.Unlock()
+
+	copied := *session
+	return &copied, m.sign(session.ID), nil
+}
+
+// Get returns the session of the signed ID
+// It refreshes the expired access token and revalidates the user when it's time
+// Expired sessions and sessions which GitLab rejected are removed, SessionNotFound is returned for them
+func (m *Manager) Get(ctx context.Context, signedID string) (*Session, error) {
+	id, ok := m.verify(signedID)
+	if !ok {
+		return nil, SessionNotFound
+	}
+
+	session, ok := m.touch(id)
+	if !ok {
+		return nil, SessionNotFound
+	}
+
+	now := m.now()
+	if !session.tokenExpiresAt.IsZero() && now.After(session.tokenExpiresAt.Add(-tokenExpiryMargin)) {
+		if session.refreshToken == "" {
+			m.Revoke(id)
+			return nil, SessionNotFound
+		}
+		token, err := m.refresh(ctx, session.refreshToken, session.redirectURI)
+		if err != nil {
+			return m.handleFailure(ctx, session, err, "cannot refresh token")
+		}
+		session.accessToken = token.AccessToken
+		if token.RefreshToken != "" {
+			session.refreshToken = token.RefreshToken
+		}
+		session.tokenExpiresAt = time.Time{}
+		if token.ExpiresIn > 0 {
+			session.tokenExpiresAt = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+		}
+		// A new token should be checked anyway
+		session.ValidatedAt = time.Time{}
+	}
+
+	if now.Sub(session.ValidatedAt) >= m.revalidateInterval {
+		user, err := m.validate(ctx, session.accessToken)
+		if err != nil {
+			return m.handleFailure(ctx, session, err, "cannot revalidate session")
+		}
+		session.User = *user
+		session.ValidatedAt = now
+	}
+
+	return m.update(session)
+}
+
+// handleFailure removes the session when GitLab rejected it
+// Other errors (i.e. GitLab is unavailable) don't log users out, the session is used as is
+func (m *Manager) handleFailure(ctx context.Context, session Session, err error, message string) (*Session, error) {
+	var oauthErr *oauth.Error
+	if errors.Is(err, Unauthorized) || errors.As(err, &oauthErr) {
+		log.WithContext(ctx).Infof("%s of %s: %v", message, session.User.Username, err)
+		m.Revoke(session.ID)
+		return nil, SessionNotFound
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	log.WithContext(ctx).Errorf("%s of %s: %v", message, session.User.Username, err)
+	copied := session
+	return &copied, nil
+}
+
+// touch returns a copy of the session and updates its last request time
+func (m *Manager) touch(id string) (Session, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	now := m.now()
+	if m.isExpired(session, now) {
+		delete(m.sessions, id)
+		return Session{}, false
+	}
+	session.LastSeenAt = now
+
+	return *session, true
+}
+
+// update stores tokens and the user of the copy unless the session was revoked meanwhile
+func (m *Manager) update(session Session) (*Session, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	stored, ok := m.sessions[session.ID]
+	if !ok {
+		return nil, SessionNotFound
+	}
+	stored.User = session.User
+	stored.ValidatedAt = session.ValidatedAt
+	stored.accessToken = session.accessToken
+	stored.refreshToken = session.refreshToken
+	stored.tokenExpiresAt = session.tokenExpiresAt
+
+	copied := *stored
+	return &copied, nil
+}
+
+func (m *Manager) isExpired(session *Session, now time.Time) bool {
+	return now.After(session.ExpiresAt) || now.Sub(session.LastSeenAt) > m.idleTimeout
+}
+
+// List returns active sessions, the newest first
+func (m *Manager) List() []*Session {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	now := m.now()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		if m.isExpired(session, now) {
+			continue
+		}
+		copied := *session
+		sessions = append(sessions, &copied)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+
+	return sessions
+}
+
+// Revoke removes the session, it returns SessionNotFound if there is no such session
+func (m *Manager) Revoke(id string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if _, ok := m.sessions[id]; !ok {
+		return SessionNotFound
+	}
+	delete(m.sessions, id)
+
+	return nil
+}
+
+// RevokeSigned removes the session of the signed ID, i.e. on logout
+func (m *Manager) RevokeSigned(signedID string) {
+	if id, ok := m.verify(signedID); ok {
+		_ = m.Revoke(id)
+	}
+}
+
+// RemoveExpired removes expired sessions
+func (m *Manager) RemoveExpired() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	now := m.now()
+	for id, session := range m.sessions {
+		if m.isExpired(session, now) {
+			delete(m.sessions, id)
+		}
+	}
+}
+
+// Run removes expired sessions every `interval` until the context is canceled
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+				m.RemoveExpired()
+			}
+		}
+	}()
+}
+
+// TTL is the maximum lifetime of a session
+func (m *Manager) TTL() time.Duration {
+	return m.ttl
+}
+
+func (m *Manager) sign(id string) string {
+	return id + "." + base64.RawURLEncoding.EncodeToString(m.signature(id))
+}
+
+func (m *Manager) verify(signedID string) (string, bool) {
+	parts := strings.SplitN(signedID, ".", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, m.signature(parts[0])) {
+		return "", false
+	}
+
+	return parts[0], true
+}
+
+func (m *Manager) signature(id string) []byte {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(id))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,281 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"gitlab-environment-dashboard/server/pkg/oauth"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGitLab validates and refreshes tokens like GitLab
+type fakeGitLab struct {
+	mtx         sync.Mutex
+	users       map[string]string
+	err         error
+	validations int
+	refreshes   int
+}
+
+func (g *fakeGitLab) validate(_ context.Context, accessToken string) (*User, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	g.validations++
+	if g.err != nil {
+		return nil, g.err
+	}
+	username, ok := g.users[accessToken]
+	if !ok {
+		return nil, Unauthorized
+	}
+
+	return &User{Username: username}, nil
+}
+
+func (g *fakeGitLab) refresh(_ context.Context, refreshToken string, _ string) (*oauth.Token, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	g.refreshes++
+	if refreshToken != "refresh" {
+		return nil, &oauth.Error{StatusCode: 400, Code: "invalid_grant"}
+	}
+	g.users["access2"] = "jdoe"
+
+	return &oauth.Token{AccessToken: "access2", RefreshToken: "refresh2", ExpiresIn: 7200}, nil
+}
+
+// newTestManager creates a manager with 24h TTL, 1h idle timeout and 5m revalidate interval
+// The returned function moves the clock of the manager
+func newTestManager(t *testing.T, gitLab *fakeGitLab) (*Manager, func(time.Duration)) {
+	manager, err := NewManager("secret", 24*time.Hour, time.Hour, 5*time.Minute, gitLab.validate, gitLab.refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	manager.now = func() time.Time { return now }
+
+	return manager, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCreate(t *testing.T) {
+	gitLab := &fakeGitLab{users: map[string]string{"access": "jdoe"}}
+	manager, _ := newTestManager(t, gitLab)
+
+	session, signedID, err := manager.Create(context.Background(), &oauth.Token{AccessToken: "access"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.User.Username != "jdoe" {
+		t.Errorf("Create() user = %s, want jdoe", session.User.Username)
+	}
+	if !strings.HasPrefix(signedID, session.ID+".") || strings.Contains(signedID, "access") {
+		t.Errorf("Create() signed ID = %s", signedID)
+	}
+
+	_, _, err = manager.Create(context.Background(), &oauth.Token{AccessToken: "unknown"}, "")
+	if err != Unauthorized {
+		t.Errorf("Create() with an unknown token error = %v, want %v", err, Unauthorized)
+	}
+}
+
+func TestGetVerifiesSignature(t *testing.T) {
+	gitLab := &fakeGitLab{users: map[string]string{"access": "jdoe"}}
+	manager, _ := newTestManager(t, gitLab)
+	session, signedID, err := manager.Create(context.Background(), &oauth.Token{AccessToken: "access"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewManager("other", time.Hour, time.Hour, time.Hour, gitLab.validate, gitLab.refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		signedID string
+	}{
+		{"unsigned", session.ID},
+		{"badSignature", session.ID + ".AAAA"},
+		{"otherSecret", other.sign(session.ID)},
+		{"empty", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := manager.Get(context.Background(), tt.signedID); err != SessionNotFound {
+				t.Errorf("Get() error = %v, want %v", err, SessionNotFound)
+			}
+		})
+	}
+
+	if got, err := manager.Get(context.Background(), signedID); err != nil || got.ID != session.ID {
+		t.Errorf("Get() = %v, %v, want the session", got, err)
+	}
+}
+
+func TestGetExpires(t *testing.T) {
+	tests := []struct {
+		name string
+		// requests are delays before requests, the session should expire on the last one
+		requests []time.Duration
+	}{
+		{"idle", []time.Duration{61 * time.Minute}},
+		{"ttl", []time.Duration{50 * time.Minute, 50 * time.Minute, 50 * time.Minute, 21 * time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gitLab := &fakeGitLab{users: map[string]string{"access": "jdoe"}}
+			manager, advance := newTestManager(t, gitLab)
+			_, signedID, err := manager.Create(context.Background(), &oauth.Token{AccessToken: "access"}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, delay := range tt.requests {
+				advance(delay)
+				_, err := manager.Get(context.Background(), signedID)
+				last := i == len(tt.requests)-1
+				if last && err != SessionNotFound {
+					t.Errorf("Get() error = %v, want the session expired", err)
+				}
+				if !last && err != nil {
+					t.Fatalf("Get() request %d error = %v", i, err)
+				}
+			}
+			if len(manager.List()) != 0 {
+				t.Errorf("List() has the expired session")
+			}
+		})
+	}
+}
+
+func TestGetRevalidates(t *testing.T) {
+	gitLab := &fakeGitLab{users: map[string]string{"access": "jdoe"}}
+	manager, advance := newTestManager(t, gitLab)
+	_, signedID, err := manager.Create(context.Background(), &oauth.Token{AccessToken: "access"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	advance(time.Minute)
+	if _, err := manager.Get(context.Background(), signedID); err != nil || gitLab.validations != 1 {
+		t.Fatalf("Get() error = %v, validations = %d, want no revalidation", err, gitLab.validations)
+	}
+
+	// GitLab is unavailable, the session is used as is
+	advance(5 * time.Minute)
+	gitLab.err = errors.New("connection refused")
+	if _, err := manager.Get(context.Background(), signedID); err != nil || gitLab.validations != 2 {
+		t.Fatalf("Get() error = %v, validations = %d, want the session", err, gitLab.validations)
+	}
+
+	// The token is revoked
+	gitLab.err = nil
+	delete(gitLab.users, "access")
+	if _, err := manager.Get(context.Background(), signedID); err != SessionNotFound {
+		t.Fatalf("Get() error = %v, want %v", err, SessionNotFound)
+	}
+	if _, err := manager.Get(context.Background(), signedID); err != SessionNotFound || gitLab.validations != 3 {
+		t.Errorf("Get() error = %v, validations = %d, want the session removed", err, gitLab.validations)
+	}
+}
+
+func TestGetRefreshesToken(t *testing.T) {
+	tests := []struct {
+		name         string
+		refreshToken string
+		wantErr      error
+	}{
+		{"refreshed", "refresh", nil},
+		{"rejected", "revoked", SessionNotFound},
+		{"withoutRefreshToken", "", SessionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gitLab := &fakeGitLab{users: map[string]string{"access": "jdoe"}}
+			manager, advance := newTestManager(t, gitLab)
+			token := &oauth.Token{AccessToken: "access", RefreshToken: tt.refreshToken, ExpiresIn: 3000}
+			session, signedID, err := manager.Create(context.Background(), token, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The token expires in 50 minutes, it's refreshed a minute earlier
+			advance(49*time.Minute + time.Second)
+			_, err = manager.Get(context.Background(), signedID)
+			if err != tt.wantErr {
+				t.Fatalf("Get() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			manager.mtx.RLock()
+			stored := *manager.sessions[session.ID]
+			manager.mtx.RUnlock()
+			if stored.accessToken != "access2" || stored.refreshToken != "refresh2" {
+				t.Errorf("Get() stored tokens %s and %s, want new ones", stored.accessToken, stored.refreshToken)
+			}
+			// The new token is checked right after the refresh
+			if gitLab.validations != 2 {
+				t.Errorf("Get() validations = %d, want 2", gitLab.validations)
+			}
+		})
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	gitLab := &fakeGitLab{users: map[string]string{"access": "jdoe"}}
+	manager, _ := newTestManager(t, gitLab)
+	session, signedID, err := manager.Create(context.Background(), &oauth.Token{AccessToken: "access"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.Revoke(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Get(context.Background(), signedID); err != SessionNotFound {
+		t.Errorf("Get() error = %v, want %v", err, SessionNotFound)
+	}
+	if err := manager.Revoke(session.ID); err != SessionNotFound {
+		t.Errorf("Revoke() twice error = %v, want %v", err, SessionNotFound)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	gitLab := &fakeGitLab{users: map[string]string{"access": "jdoe"}}
+	manager, err := NewManager("secret", time.Hour, time.Hour, 0, gitLab.validate, gitLab.refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, signedID, err := manager.Create(context.Background(), &oauth.Token{AccessToken: "access"}, "")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for j := 0; j < 10; j++ {
+				if _, err := manager.Get(context.Background(), signedID); err != nil {
+					t.Error(err)
+				}
+				manager.List()
+			}
+			manager.RevokeSigned(signedID)
+			manager.RemoveExpired()
+		}()
+	}
+	wg.Wait()
+
+	if len(manager.List()) != 0 {
+		t.Errorf("List() has %d sessions, want all revoked", len(manager.List()))
+	}
+}
@@ -0,0 +1,131 @@
+// Package tracing configures OpenTelemetry tracing of the application
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"os"
+)
+
+// Exporters of spans
+// Tracing is disabled when the exporter is empty
+const (
+	ExporterNone   = ""
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Requests which are not traced, they are done by the orchestrator or Prometheus
+var untracedPaths = []string{"/metrics", "/health", "/health/live", "/health/ready"}
+
+// Setup registers a global tracer provider which sends spans to the exporter
+// The OTLP exporter is configured by standard OTEL_EXPORTER_OTLP_* variables
+// The returned function flushes remaining spans and stops the provider
+func Setup(exporter string, serviceName string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var spanExporter sdktrace.SpanExporter
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(context.Background())
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter: %s", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a span for every request
+// It should be used by mux.Router.Use to name spans by matched routes
+func Middleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(
+		next,
+		"http.request",
+		otelhttp.WithSpanNameFormatter(routeSpanName),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			for _, path := range untracedPaths {
+				if r.URL.Path == path {
+					return false
+				}
+			}
+			return true
+		}),
+	)
+}
+
+// routeSpanName returns a method and a path template of the route, i.e. "GET /environments/{environment}/jobs"
+func routeSpanName(operation string, r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return operation
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return operation
+	}
+
+	return fmt.Sprintf("%s %s", r.Method, template)
+}
+
+// NewTransport traces requests sent through the transport
+func NewTransport(next http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(next)
+}
+
+// Detach returns a context which keeps the span of `ctx` but is not canceled with it
+// It's used to continue a trace in a goroutine which outlives the request
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+}
+
+// LogHook adds IDs of the current span to log entries created with log.WithContext
+type LogHook struct{}
+
+func (LogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (LogHook) Fire(entry *log.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	spanContext := trace.SpanContextFromContext(entry.Context)
+	if !spanContext.IsValid() {
+		return nil
+	}
+	entry.Data["trace_id"] = spanContext.TraceID().String()
+	entry.Data["span_id"] = spanContext.SpanID().String()
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package tracing
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteSpanName(t *testing.T) {
+	var name string
+	r := mux.NewRouter()
+	r.Methods("POST").
+		Path("/environments/{environment}/jobs").
+		HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name = routeSpanName("http.request", r)
+		})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/environments/qa2/jobs", nil))
+
+	if name != "POST /environments/{environment}/jobs" {
+		t.Errorf("routeSpanName() = %q", name)
+	}
+	if name := routeSpanName("http.request", httptest.NewRequest("GET", "/", nil)); name != "http.request" {
+		t.Errorf("routeSpanName() without route = %q", name)
+	}
+}
+
+func TestLogHookFire(t *testing.T) {
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{2},
+	})
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		traceID interface{}
+	}{
+		{"without context", nil, nil},
+		{"without span", context.Background(), nil},
+		{"with span", trace.ContextWithSpanContext(context.Background(), spanContext), spanContext.TraceID().String()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := log.NewEntry(log.StandardLogger())
+			entry.Context = tt.ctx
+			if err := (LogHook{}).Fire(entry); err != nil {
+				t.Fatal(err)
+			}
+			if got := entry.Data["trace_id"]; got != tt.traceID {
+				t.Errorf("trace_id = %v, want %v", got, tt.traceID)
+			}
+		})
+	}
+}